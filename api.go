@@ -0,0 +1,87 @@
+package exec
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/client-go/kubernetes/scheme"
+	restclient "k8s.io/client-go/rest"
+)
+
+// APIOverrides override the path, the group and version, and the parameter
+// codec of the pods API, for API servers serving it elsewhere, such as
+// aggregated APIs and virtual clusters. They apply to the requests going
+// through the REST client of a client: creating pods, and attaching, executing
+// in and forwarding ports of their containers. The requests of the clientset,
+// such as getting and watching pods, are not affected.
+type APIOverrides struct {
+	// APIPath is the path prefix of the pods API, after the path of the host
+	// of the REST config. If empty, it is "/api".
+	APIPath string
+
+	// GroupVersion is the group and version of the pods API. If empty, it is
+	// "v1", of the core group.
+	GroupVersion schema.GroupVersion
+
+	// ParameterCodec encodes the options of the requests, such as the attach
+	// and exec options. If nil, the one of the client-go scheme is used.
+	ParameterCodec runtime.ParameterCodec
+}
+
+// WithAPIOverrides returns a copy of the client making its requests to the
+// pods API with the given overrides. The copy shares the connections of the
+// client, and is closed with it.
+func (c *Client) WithAPIOverrides(o APIOverrides) (*Client, error) {
+	if c.config == nil {
+		return nil, &Error{Kind: ErrClientInit, Err: errNoREST}
+	}
+
+	config := c.config
+	if c.transport != nil {
+		config = clientsetConfig(config, c.transport)
+	} else {
+		config = restclient.CopyConfig(config)
+	}
+
+	config.APIPath = o.APIPath
+	if config.APIPath == "" {
+		config.APIPath = "/api"
+	}
+	gv := o.GroupVersion
+	if gv.Empty() {
+		gv = v1.SchemeGroupVersion
+	}
+	config.GroupVersion = &gv
+	config.NegotiatedSerializer = serializer.DirectCodecFactory{CodecFactory: scheme.Codecs}
+	if config.UserAgent == "" {
+		config.UserAgent = restclient.DefaultKubernetesUserAgent()
+	}
+
+	pods, err := restclient.RESTClientFor(config)
+	if err != nil {
+		return nil, &Error{Kind: ErrClientInit, Err: err}
+	}
+
+	cc := *c
+	cc.pods = pods
+	cc.codec = o.ParameterCodec
+	return &cc, nil
+}
+
+// podsREST returns the REST client of the requests to the pods API
+func (c *Client) podsREST() restclient.Interface {
+	if c.pods != nil {
+		return c.pods
+	}
+	return c.clientset.CoreV1().RESTClient()
+}
+
+// parameterCodec returns the codec of the options of the requests to the pods
+// API
+func (c *Client) parameterCodec() runtime.ParameterCodec {
+	if c.codec != nil {
+		return c.codec
+	}
+	return scheme.ParameterCodec
+}
@@ -0,0 +1,97 @@
+package exec
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+)
+
+const (
+	// applyPatchType is the patch type of server-side apply, which is not
+	// part of the version of the Kubernetes API of the package
+	applyPatchType = types.PatchType("application/apply-patch+yaml")
+
+	// defaultFieldManager is the field manager of the pods applied, if not set
+	defaultFieldManager = "kube-exec"
+
+	// maxApplyRetries is the number of times an apply failing with a transient
+	// error is retried, starting after applyBackoff and doubling every time
+	maxApplyRetries = 3
+	applyBackoff    = 500 * time.Millisecond
+)
+
+// fieldManager returns the field manager of the pods applied for the config
+func (cfg *Config) fieldManager() string {
+	if cfg.FieldManager == "" {
+		return defaultFieldManager
+	}
+	return cfg.FieldManager
+}
+
+// applyPod creates the pod within the namespace with a server-side apply, as
+// the given field manager, retried after transient errors, as applying the
+// same pod again does not create another one. A pod with a generate name is
+// named locally, as apply needs a name. If dryRun is set, the pod is
+// validated and defaulted by the server, but not persisted.
+func (c *Client) applyPod(ctx context.Context, namespace string, pod *v1.Pod, fieldManager string, dryRun bool) (*v1.Pod, error) {
+	if c.config == nil {
+		return nil, &Error{Kind: ErrPodCreate, Err: fmt.Errorf("server-side apply %v", errNoREST)}
+	}
+
+	pod = pod.DeepCopy()
+	pod.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"}
+	pod.Namespace = namespace
+	if pod.Name == "" && pod.GenerateName != "" {
+		pod.Name = pod.GenerateName + utilrand.String(5)
+	}
+	pod.GenerateName = ""
+
+	body, err := json.Marshal(pod)
+	if err != nil {
+		return nil, &Error{Kind: ErrPodCreate, Err: err}
+	}
+
+	backoff := applyBackoff
+	for attempt := 0; ; attempt++ {
+		req := c.podsREST().Patch(applyPatchType).
+			Context(ctx).
+			Namespace(namespace).
+			Resource("pods").
+			Name(pod.Name).
+			Param("fieldManager", fieldManager).
+			Body(body)
+		if dryRun {
+			req = req.Param("dryRun", metav1.DryRunAll)
+		}
+
+		result := &v1.Pod{}
+		err = req.Do().Into(result)
+		if err == nil {
+			return result, nil
+		}
+		if attempt == maxApplyRetries || !transient(err) {
+			return nil, &Error{Kind: ErrPodCreate, Err: err}
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// transient reports whether a request failing with err can succeed if retried
+func transient(err error) bool {
+	return apierrors.IsServerTimeout(err) || apierrors.IsTimeout(err) ||
+		apierrors.IsTooManyRequests(err) || apierrors.IsInternalError(err) ||
+		apierrors.IsUnexpectedServerError(err)
+}
@@ -0,0 +1,126 @@
+package exec
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// artifactsVolume is the name of the volume shared by the container of the
+	// command and the sidecar serving the artifacts, mounted at artifactsDir
+	artifactsVolume = "kube-exec-artifacts"
+	artifactsDir    = "/kube-exec-artifacts"
+
+	// artifactsArchive is the archive of the artifacts in the volume, listed
+	// in artifactsList before being archived
+	artifactsArchive = artifactsDir + "/artifacts.tar"
+	artifactsList    = artifactsDir + "/artifacts.list"
+
+	// artifactsSidecar is the name of the sidecar serving the artifacts
+	artifactsSidecar = "kube-exec-artifacts"
+)
+
+// wrapArtifacts returns the command and arguments of the container running the
+// given command, and archiving the paths matching the glob patterns once it
+// exits, with its exit code. The errors of tar, such as for leading slashes,
+// are not written to the standard error of the command.
+func wrapArtifacts(command, args, patterns []string) ([]string, []string) {
+	run := ShellQuote(append(append([]string{}, command...), args...)...)
+
+	globs := make([]string, len(patterns))
+	for i, p := range patterns {
+		globs[i] = globQuote(p)
+	}
+
+	script := fmt.Sprintf(`%s; rc=$?; for f in %s; do if [ -e "$f" ]; then echo "$f"; fi; done > %s; tar -cf %s -T %s 2>/dev/null; exit $rc`,
+		run, strings.Join(globs, " "), artifactsList, artifactsArchive, artifactsList)
+	return []string{"/bin/sh", "-c"}, []string{script}
+}
+
+// globQuote quotes a glob pattern for a shell, except for its wildcards
+func globQuote(pattern string) string {
+	var b strings.Builder
+	literal := ""
+	for _, r := range pattern {
+		if strings.ContainsRune("*?[]", r) {
+			if literal != "" {
+				b.WriteString(shellQuote(literal))
+				literal = ""
+			}
+			b.WriteRune(r)
+			continue
+		}
+		literal += string(r)
+	}
+	if literal != "" {
+		b.WriteString(shellQuote(literal))
+	}
+
+	return b.String()
+}
+
+// addArtifacts adds the volume of the artifacts to the spec, mounted in c, with
+// the sidecar serving them once the command terminated
+func addArtifacts(spec *v1.PodSpec, c *v1.Container) {
+	spec.Volumes = append(spec.Volumes, v1.Volume{
+		Name:         artifactsVolume,
+		VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}},
+	})
+	mount := v1.VolumeMount{Name: artifactsVolume, MountPath: artifactsDir}
+	c.VolumeMounts = append(c.VolumeMounts, mount)
+
+	spec.Containers = append(spec.Containers, v1.Container{
+		Name:         artifactsSidecar,
+		Image:        defaultWorkspaceImage,
+		Command:      []string{"sh", "-c", "trap 'exit 0' TERM; while true; do sleep 1; done"},
+		VolumeMounts: []v1.VolumeMount{mount},
+	})
+}
+
+// collectArtifacts copies the archive of the artifacts of the command, through
+// the sidecar serving them, to ArtifactsWriter or extracted to ArtifactsDir
+func (cmd *Cmd) collectArtifacts() error {
+	w := cmd.Cfg.ArtifactsWriter
+
+	var pw *io.PipeWriter
+	errc := make(chan error, 1)
+	if w == nil {
+		dir := cmd.Cfg.ArtifactsDir
+		if dir == "" {
+			dir = "."
+		}
+
+		var pr *io.PipeReader
+		pr, pw = io.Pipe()
+		w = pw
+		go func() {
+			err := readTar(pr, "", dir)
+
+			// drain whatever is left so the stream is not blocked
+			io.Copy(ioutil.Discard, pr)
+			errc <- err
+		}()
+	}
+
+	var stderr strings.Builder
+	err := cmd.executeIn(artifactsSidecar, []string{"cat", artifactsArchive}, ExecOptions{Stdout: w, Stderr: &stderr})
+	if pw != nil {
+		pw.Close()
+		if terr := <-errc; terr != nil && err == nil {
+			err = terr
+		}
+	}
+	if err != nil {
+		if s := strings.TrimSpace(stderr.String()); s != "" {
+			return fmt.Errorf("%w: %s", err, s)
+		}
+		return err
+	}
+
+	cmd.log.Debug("artifacts collected", "namespace", cmd.pod.Namespace, "pod", cmd.pod.Name)
+	return nil
+}
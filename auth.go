@@ -0,0 +1,207 @@
+package exec
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	restclient "k8s.io/client-go/rest"
+
+	// the auth providers of kubeconfigs, such as oidc, are registered by their
+	// packages; exec credential plugins, such as aws-iam-authenticator or
+	// gke-gcloud-auth-plugin, are supported by client-go itself
+	_ "k8s.io/client-go/plugin/pkg/client/auth"
+)
+
+// Credentials authenticate a client with the API server, when it is created
+// for an explicit server URL with NewClientForServer.
+type Credentials struct {
+	// BearerToken is a static token sent with every request.
+	BearerToken string
+
+	// Token, if not nil, is called for every request, and every stream, to get
+	// the bearer token to send, so an expiring token can be refreshed. It takes
+	// precedence over BearerToken.
+	Token func() (string, error)
+
+	// TokenFile, if not empty, is a file holding the bearer token, such as a
+	// projected service account token, read again every minute, and when the
+	// server rejects the token, so the rotations of the token are followed.
+	// It takes precedence over Token and BearerToken.
+	TokenFile string
+
+	// CertFile and KeyFile, or CertData and KeyData, are the client certificate
+	// and key, PEM-encoded, for TLS client authentication.
+	CertFile string
+	KeyFile  string
+	CertData []byte
+	KeyData  []byte
+
+	// CAFile or CAData are the PEM-encoded certificate authorities to trust for
+	// the server. If both are empty, the system roots are used.
+	CAFile string
+	CAData []byte
+
+	// Insecure skips the verification of the certificate of the server.
+	Insecure bool
+}
+
+// NewClientForServer returns a new client for the API server at the given URL,
+// without a kubeconfig. Its default namespace is "default".
+func NewClientForServer(server string, creds Credentials) (*Client, error) {
+	config := &restclient.Config{
+		Host:        server,
+		BearerToken: creds.BearerToken,
+		TLSClientConfig: restclient.TLSClientConfig{
+			Insecure: creds.Insecure,
+			CertFile: creds.CertFile,
+			KeyFile:  creds.KeyFile,
+			CAFile:   creds.CAFile,
+			CertData: creds.CertData,
+			KeyData:  creds.KeyData,
+			CAData:   creds.CAData,
+		},
+	}
+
+	switch {
+	case creds.TokenFile != "":
+		refreshToken(config, newFileToken(creds.TokenFile))
+	case creds.Token != nil:
+		refreshToken(config, &funcToken{fn: creds.Token})
+	}
+
+	return NewClientForConfig(config)
+}
+
+// tokenRefreshPeriod is the period token files are read again at, as projected
+// service account tokens are rotated by the kubelet well before they expire
+const tokenRefreshPeriod = time.Minute
+
+// tokenSource returns the bearer token of the requests of a client
+type tokenSource interface {
+	// token returns the token to send.
+	token() (string, error)
+
+	// invalidate reports the last token returned as rejected by the server,
+	// and reports whether a new one may be returned.
+	invalidate() bool
+}
+
+// funcToken is the token source of a token func
+type funcToken struct {
+	fn func() (string, error)
+}
+
+func (f *funcToken) token() (string, error) {
+	return f.fn()
+}
+
+func (f *funcToken) invalidate() bool {
+	return false
+}
+
+// fileToken is the token source of a token file, read again every
+// tokenRefreshPeriod, or once its token was rejected
+type fileToken struct {
+	path string
+
+	mu   sync.Mutex
+	last string
+	read time.Time
+}
+
+func newFileToken(path string) *fileToken {
+	return &fileToken{path: path}
+}
+
+func (f *fileToken) token() (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.last != "" && time.Since(f.read) < tokenRefreshPeriod {
+		return f.last, nil
+	}
+
+	b, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		// the file may be replaced while rotated
+		if f.last != "" {
+			return f.last, nil
+		}
+		return "", err
+	}
+	f.last, f.read = strings.TrimSpace(string(b)), time.Now()
+
+	return f.last, nil
+}
+
+func (f *fileToken) invalidate() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.read = time.Time{}
+	return true
+}
+
+// refreshToken sets the token of the source on the requests of the config,
+// and on the streams, instead of its static bearer token
+func refreshToken(config *restclient.Config, source tokenSource) {
+	config.BearerToken = ""
+
+	wrap := config.WrapTransport
+	config.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		if wrap != nil {
+			rt = wrap(rt)
+		}
+		return &tokenRoundTripper{source: source, rt: rt}
+	}
+}
+
+// tokenRoundTripper sets the bearer token of the source on every request, and
+// sends the requests rejected as unauthorized again with a new token, when the
+// source has one and the request can be sent again
+type tokenRoundTripper struct {
+	source tokenSource
+	rt     http.RoundTripper
+}
+
+func (t *tokenRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.send(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	if req.Body != nil && req.GetBody == nil || !t.source.invalidate() {
+		return resp, nil
+	}
+
+	retry := req
+	if req.Body != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		retry = utilnet.CloneRequest(req)
+		retry.Body = body
+	}
+	resp.Body.Close()
+
+	return t.send(retry)
+}
+
+// send sends the request with the current token
+func (t *tokenRoundTripper) send(req *http.Request) (*http.Response, error) {
+	token, err := t.source.token()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get bearer token: %v", err)
+	}
+
+	// requests must not be modified by round trippers
+	req = utilnet.CloneRequest(req)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	return t.rt.RoundTrip(req)
+}
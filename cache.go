@@ -0,0 +1,244 @@
+package exec
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// maxCachedOutput is the size of the output of each stream of a command above
+// which its result is not cached
+const maxCachedOutput = 4 * 1024 * 1024
+
+// ResultCache stores the run records of the commands that succeeded, with
+// their whole output, by the key of their config, command and input. It must
+// be safe for concurrent use by the commands sharing it.
+type ResultCache interface {
+	// Get returns the record stored for the key, or nil if there is none.
+	Get(key string) (*RunRecord, error)
+
+	// Put stores the record for the key.
+	Put(key string, record *RunRecord) error
+}
+
+// NewMemoryCache returns a ResultCache keeping the records in memory.
+func NewMemoryCache() ResultCache {
+	return &memoryCache{records: map[string]*RunRecord{}}
+}
+
+// memoryCache is a ResultCache keeping the records in memory
+type memoryCache struct {
+	mu      sync.Mutex
+	records map[string]*RunRecord
+}
+
+func (c *memoryCache) Get(key string) (*RunRecord, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.records[key], nil
+}
+
+func (c *memoryCache) Put(key string, record *RunRecord) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.records[key] = record
+	return nil
+}
+
+// NewDirCache returns a ResultCache keeping the records in a directory, as
+// JSON files named after their key, so they are shared by processes.
+func NewDirCache(dir string) ResultCache {
+	return dirCache(dir)
+}
+
+// dirCache is a ResultCache keeping the records in a directory
+type dirCache string
+
+func (d dirCache) Get(key string) (*RunRecord, error) {
+	b, err := ioutil.ReadFile(filepath.Join(string(d), key+".json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r := &RunRecord{}
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (d dirCache) Put(key string, record *RunRecord) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(string(d), 0755); err != nil {
+		return err
+	}
+
+	// written to a temporary file first, so a record is never read partially
+	f, err := ioutil.TempFile(string(d), key+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), filepath.Join(string(d), key+".json"))
+}
+
+// cacheKey returns the key of the result of the command with the given input,
+// as hex: the SHA-256 of its config, with the digest of its image, of its
+// command and of its input
+func cacheKey(cmd *Cmd, stdin []byte) (string, error) {
+	hash, err := configHash(cmd)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	io.WriteString(h, hash)
+	h.Write(stdin)
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// lookupCache looks up the result of the command in the cache of the config,
+// and reports whether it was found, in which case it is replayed instead of
+// running the command. The input of the command is read to compute its key.
+// Errors of the cache, or of computing the key, are logged, and the command
+// runs.
+func (cmd *Cmd) lookupCache() (bool, error) {
+	if _, ok := cmd.Stdin.(*io.PipeReader); ok {
+		return false, errors.New("exec: Cache cannot be used with a piped Stdin")
+	}
+
+	var stdin []byte
+	if cmd.Stdin != nil {
+		var err error
+		if stdin, err = ioutil.ReadAll(cmd.Stdin); err != nil {
+			return false, err
+		}
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+
+	// without a key, the command runs and its result is not stored, rather
+	// than shared with other commands
+	key, err := cacheKey(cmd, stdin)
+	if err != nil {
+		cmd.log.Warn("cannot compute cache key, not caching", "error", err)
+		return false, nil
+	}
+	cmd.cacheKey = key
+	r, err := cmd.Cfg.Cache.Get(cmd.cacheKey)
+	if err != nil {
+		cmd.log.Warn("cannot get cached result", "key", cmd.cacheKey, "error", err)
+	}
+	if r == nil {
+		cmd.setupCache()
+		return false, nil
+	}
+
+	cmd.log.Info("cached result found", "key", cmd.cacheKey, "runID", r.RunID)
+	cmd.replayCached(r)
+	return true, nil
+}
+
+// setupCache keeps the output of the command to cache its result, next to
+// Stdout and Stderr if set
+func (cmd *Cmd) setupCache() {
+	cmd.cacheStdout = &tailWriter{limit: maxCachedOutput}
+	cmd.cacheStderr = &tailWriter{limit: maxCachedOutput}
+
+	stdout, stderr := cmd.Stdout, cmd.Stderr
+	if stdout != nil && sameWriter(stdout, stderr) {
+		// the streams are copied from different goroutines
+		stdout = &lockedWriter{w: stdout}
+		stderr = stdout
+	}
+
+	cmd.Stdout = teeWriter(stdout, cmd.cacheStdout)
+	cmd.Stderr = teeWriter(stderr, cmd.cacheStderr)
+}
+
+// replayCached terminates the command with the cached result, writing its
+// output, without creating a pod
+func (cmd *Cmd) replayCached(cached *RunRecord) {
+	r := *cached
+	r.RunID = cmd.runID
+	r.Cached = true
+
+	cmd.startTime = time.Now()
+	cmd.ProcessState = &ProcessState{Reason: r.Reason, Attempts: r.Attempts}
+	if r.StartedAt != nil && r.FinishedAt != nil {
+		cmd.ProcessState.StartedAt, cmd.ProcessState.FinishedAt = *r.StartedAt, *r.FinishedAt
+	}
+
+	cmd.done = make(chan struct{})
+	cmd.errc = make(chan error, 1)
+	cmd.exited = make(chan struct{})
+
+	// written once Start returned, for the pipes to be read
+	go func() {
+		var err error
+		if cmd.Stdout != nil {
+			_, err = io.WriteString(cmd.Stdout, r.Stdout)
+		}
+		if cmd.Stderr != nil && err == nil {
+			_, err = io.WriteString(cmd.Stderr, r.Stderr)
+		}
+		cmd.endTrace(err)
+		closeAll(cmd.closeAfterStream)
+		cmd.emitRecord(&r)
+		cmd.errc <- err
+		close(cmd.exited)
+
+		if cmd.cancel != nil {
+			cmd.cancel()
+		}
+	}()
+}
+
+// storeResult stores the result of the command in the cache of the config, if
+// it succeeded and its output is not too large
+func (cmd *Cmd) storeResult(err error) {
+	if cmd.cacheStdout == nil || err != nil || cmd.record == nil {
+		return
+	}
+
+	r := *cmd.record
+	var truncated bool
+	r.Stdout, truncated = cmd.cacheStdout.tail()
+	if !truncated {
+		r.Stderr, truncated = cmd.cacheStderr.tail()
+	}
+	if truncated {
+		cmd.log.Debug("output too large to cache", "key", cmd.cacheKey)
+		return
+	}
+	r.StdoutTruncated, r.StderrTruncated = false, false
+	r.CacheKey = cmd.cacheKey
+
+	if perr := cmd.Cfg.Cache.Put(cmd.cacheKey, &r); perr != nil {
+		cmd.log.Warn("cannot cache result", "key", cmd.cacheKey, "error", perr)
+	}
+}
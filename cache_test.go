@@ -0,0 +1,117 @@
+package exec_test
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	exec "github.com/engineerd/kube-exec"
+	"github.com/engineerd/kube-exec/kubeexectest"
+)
+
+func TestCacheReplaysResult(t *testing.T) {
+	backend := kubeexectest.New()
+	backend.SetResult("render template", kubeexectest.Result{Stdout: []byte("rendered\n"), Stderr: []byte("warning\n")})
+
+	cfg := exec.Config{
+		Client:       backend.Client,
+		Namespace:    "test",
+		GenerateName: "run-",
+		Image:        "busybox",
+		ImageDigest:  "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+		Cache:        exec.NewMemoryCache(),
+	}
+
+	for i := 0; i < 2; i++ {
+		cmd := exec.Command(cfg, "render", "template")
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.StdinString("input"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("run %d: Run() = %v", i+1, err)
+		}
+		if stdout.String() != "rendered\n" || stderr.String() != "warning\n" {
+			t.Errorf("run %d: output = %q, %q, want %q, %q", i+1, stdout.String(), stderr.String(), "rendered\n", "warning\n")
+		}
+	}
+
+	// the second run is replayed from the cache
+	if n := len(backend.Pods()); n != 1 {
+		t.Errorf("%d pods, want 1", n)
+	}
+}
+
+// keysCache is a ResultCache recording the keys of the results stored
+type keysCache struct {
+	exec.ResultCache
+
+	mu   sync.Mutex
+	keys []string
+}
+
+func (c *keysCache) Put(key string, record *exec.RunRecord) error {
+	c.mu.Lock()
+	c.keys = append(c.keys, key)
+	c.mu.Unlock()
+
+	return c.ResultCache.Put(key, record)
+}
+
+func TestCacheKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(cfg *exec.Config, cmd *exec.Cmd)
+	}{
+		{name: "base", setup: func(*exec.Config, *exec.Cmd) {}},
+		{name: "args", setup: func(_ *exec.Config, cmd *exec.Cmd) { cmd.Args = []string{"other"} }},
+		{name: "env", setup: func(_ *exec.Config, cmd *exec.Cmd) { cmd.Env = []string{"MODE=fast"} }},
+		{name: "dir", setup: func(_ *exec.Config, cmd *exec.Cmd) { cmd.Dir = "/src" }},
+		{name: "shell", setup: func(_ *exec.Config, cmd *exec.Cmd) { cmd.Shell = []string{"/bin/bash", "-c"} }},
+		{name: "merged stderr", setup: func(_ *exec.Config, cmd *exec.Cmd) { cmd.MergeStderr = true }},
+		// the container of the command, named explicitly
+		{name: "container", setup: func(_ *exec.Config, cmd *exec.Cmd) { cmd.Container = "exec" }},
+		{name: "image digest", setup: func(cfg *exec.Config, _ *exec.Cmd) {
+			cfg.ImageDigest = "sha256:fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+		}},
+		{name: "requests", setup: func(cfg *exec.Config, _ *exec.Cmd) { cfg.Requests.CPU = "2" }},
+	}
+
+	backend := kubeexectest.New()
+	cache := &keysCache{ResultCache: exec.NewMemoryCache()}
+
+	for _, tt := range tests {
+		cfg := exec.Config{
+			Client:       backend.Client,
+			Namespace:    "test",
+			GenerateName: "run-",
+			Image:        "busybox",
+			ImageDigest:  "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+			Cache:        cache,
+		}
+		cmd := exec.Command(cfg, "render", "template")
+		tt.setup(&cmd.Cfg, cmd)
+
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("%s: Run() = %v", tt.name, err)
+		}
+	}
+
+	// no command is replayed from the result of another
+	if n := len(backend.Pods()); n != len(tests) {
+		t.Errorf("%d pods, want %d", n, len(tests))
+	}
+	seen := map[string]string{}
+	for i, key := range cache.keys {
+		if other, ok := seen[key]; ok {
+			t.Errorf("key of %s = key of %s", tests[i].name, other)
+		}
+		seen[key] = tests[i].name
+	}
+	if len(cache.keys) != len(tests) {
+		t.Errorf("%d results stored, want %d", len(cache.keys), len(tests))
+	}
+}
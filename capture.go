@@ -0,0 +1,125 @@
+package exec
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// environmentInfo and environmentEnv are the files of the artifacts
+	// volume the environment of the command is captured in
+	environmentInfo = artifactsDir + "/environment.info"
+	environmentEnv  = artifactsDir + "/environment.env"
+
+	// captureScript captures the environment of the process, then replaces it
+	// with the command given as arguments
+	captureScript = `{ echo "dir=$(pwd)"; echo "uid=$(id -u)"; echo "gid=$(id -g)"; echo "groups=$(id -G)"; echo "hostname=$(hostname)"; echo "kernel=$(uname -srm)"; sed 's/^/os./' /etc/os-release; } > ` + environmentInfo + ` 2>/dev/null; cat /proc/$$/environ > ` + environmentEnv + ` 2>/dev/null; exec "$0" "$@"`
+)
+
+// Environment is the environment the command ran in, in its container, to tell
+// why a command that works locally fails in a pod.
+type Environment struct {
+	// Env are the env variables of the process, as KEY=VALUE.
+	Env []string
+
+	// Dir is the working directory of the process.
+	Dir string
+
+	// UID, GID and Groups are the user, group and supplementary groups the
+	// process ran as.
+	UID    int
+	GID    int
+	Groups []int
+
+	// Hostname is the hostname of the container, and Kernel the name,
+	// release and machine of the kernel of the node.
+	Hostname string
+	Kernel   string
+
+	// OS are the fields of the /etc/os-release of the image, such as ID and
+	// VERSION_ID, if it has one.
+	OS map[string]string
+}
+
+// Environment returns the environment captured before the command ran, with
+// CaptureEnvironment, once it terminated, or nil.
+func (cmd *Cmd) Environment() *Environment {
+	return cmd.environment
+}
+
+// wrapCapture returns the command and arguments of the container capturing its
+// environment, before running the given command
+func wrapCapture(command, args []string) ([]string, []string) {
+	return []string{"/bin/sh", "-c"}, append(append([]string{captureScript}, command...), args...)
+}
+
+// collectEnvironment reads the environment captured by the command, through
+// the sidecar serving the artifacts
+func (cmd *Cmd) collectEnvironment() error {
+	var info, env, stderr bytes.Buffer
+	if err := cmd.executeIn(artifactsSidecar, []string{"cat", environmentInfo}, ExecOptions{Stdout: &info, Stderr: &stderr}); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if err := cmd.executeIn(artifactsSidecar, []string{"cat", environmentEnv}, ExecOptions{Stdout: &env, Stderr: &stderr}); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	e := parseEnvironment(info.String())
+	for _, kv := range bytes.Split(env.Bytes(), []byte{0}) {
+		if len(kv) > 0 {
+			e.Env = append(e.Env, string(kv))
+		}
+	}
+
+	cmd.environment = e
+	cmd.log.Debug("environment captured", "namespace", cmd.pod.Namespace, "pod", cmd.pod.Name)
+	return nil
+}
+
+// parseEnvironment parses the environment info written by captureScript
+func parseEnvironment(info string) *Environment {
+	e := &Environment{}
+	for _, line := range strings.Split(info, "\n") {
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key, value := kv[0], kv[1]
+
+		switch key {
+		case "dir":
+			e.Dir = value
+		case "uid":
+			e.UID, _ = strconv.Atoi(value)
+		case "gid":
+			e.GID, _ = strconv.Atoi(value)
+		case "groups":
+			for _, g := range strings.Fields(value) {
+				if id, err := strconv.Atoi(g); err == nil {
+					e.Groups = append(e.Groups, id)
+				}
+			}
+		case "hostname":
+			e.Hostname = value
+		case "kernel":
+			e.Kernel = value
+		default:
+			if strings.HasPrefix(key, "os.") {
+				if e.OS == nil {
+					e.OS = map[string]string{}
+				}
+				e.OS[strings.TrimPrefix(key, "os.")] = strings.Trim(value, `"'`)
+			}
+		}
+	}
+
+	return e
+}
+
+// needsArtifactsVolume reports whether the pod of the command needs the volume
+// and the sidecar of the artifacts
+func needsArtifactsVolume(cfg *Config) bool {
+	return len(cfg.Artifacts) > 0 || cfg.CaptureEnvironment
+}
@@ -0,0 +1,265 @@
+package exec
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// Client is a connection to a Kubernetes cluster. It holds the clientset and the
+// REST config, and their underlying transport, so they can be reused by many
+// commands. A Client is safe for concurrent use.
+type Client struct {
+	clientset kubernetes.Interface
+	config    *restclient.Config
+	namespace string
+
+	// transport, if not nil, is the transport of the clientset, pooling its
+	// connections, and tlsConfig the TLS config of its connections and of the
+	// SPDY streams
+	transport *http.Transport
+	tlsConfig *tls.Config
+
+	// pods and codec, if not nil, override the REST client and the parameter
+	// codec of the requests to the pods API
+	pods  restclient.Interface
+	codec runtime.ParameterCodec
+
+	// stream, if not nil, replaces the SPDY streams to the API server
+	stream StreamFunc
+}
+
+// StreamRequest is a request to stream to and from a container of a pod, either
+// by attaching to it or by executing a command in it.
+type StreamRequest struct {
+	Pod       *v1.Pod
+	Container string
+
+	// Subresource is "attach" or "exec".
+	Subresource string
+
+	// Command is the command executed, for "exec".
+	Command []string
+
+	// Stdin, Stdout and Stderr are nil for the streams not used. With a TTY,
+	// stderr is merged into stdout.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	TTY    bool
+
+	// SizeQueue, if not nil, sends the size of the terminal with a TTY.
+	SizeQueue remotecommand.TerminalSizeQueue
+
+	// Keepalive, if not zero, is the period of the traffic sent on idle streams.
+	Keepalive time.Duration
+}
+
+// StreamFunc streams to and from a container, and returns once the stream ends
+// or the context is done. Executing a command exiting with a non-zero code
+// returns an *ExitError.
+type StreamFunc func(ctx context.Context, req StreamRequest) error
+
+// NewClient returns a new client for the given kubeconfig path. If the path is
+// empty, the KUBECONFIG environment variable is used, and if that is not set
+// either, the in-cluster config is used.
+func NewClient(kubeconfig string) (*Client, error) {
+	return NewClientWithOverrides(kubeconfig, nil)
+}
+
+// NewClientWithOverrides is like NewClient, but overrides the kubeconfig, for
+// instance to use another context than its current one, or another cluster or
+// user. The overrides are ignored with the in-cluster config.
+func NewClientWithOverrides(kubeconfig string, overrides *clientcmd.ConfigOverrides) (*Client, error) {
+	return newClient(kubeconfig, overrides, nil)
+}
+
+// newClient returns a new client for the kubeconfig path with the given
+// overrides, and with the REST config adjusted by adjust if not nil
+func newClient(kubeconfig string, overrides *clientcmd.ConfigOverrides, adjust func(*restclient.Config)) (*Client, error) {
+	config, namespace, err := getKubeConfig(kubeconfig, overrides)
+	if err != nil {
+		return nil, &Error{Kind: ErrClientInit, Err: err}
+	}
+	if adjust != nil {
+		adjust(config)
+	}
+
+	c, err := NewClientForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	c.namespace = namespace
+
+	return c, nil
+}
+
+// NewClientForConfig returns a new client for the given REST config.
+// Its default namespace is "default".
+//
+// The transport of the client is created once, and its connections to the API
+// server are reused by all the commands using the client, until it is closed.
+func NewClientForConfig(config *restclient.Config) (*Client, error) {
+	c := &Client{config: config, namespace: v1.NamespaceDefault}
+
+	// the client certificates of exec plugins cannot be used with a transport
+	// of the config, which is then left to client-go
+	csConfig := config
+	if config.Transport == nil && config.ExecProvider == nil {
+		tlsConfig, err := restclient.TLSConfigFor(config)
+		if err != nil {
+			return nil, &Error{Kind: ErrClientInit, Err: err}
+		}
+		c.tlsConfig = tlsConfig
+		c.transport = newTransport(config, tlsConfig)
+		csConfig = clientsetConfig(config, c.transport)
+	}
+
+	clientset, err := kubernetes.NewForConfig(csConfig)
+	if err != nil {
+		return nil, &Error{Kind: ErrClientInit, Err: err}
+	}
+	c.clientset = clientset
+
+	return c, nil
+}
+
+// errNoREST is returned by the operations going through the REST client of the
+// clientset, by clients created for a clientset
+var errNoREST = errors.New("not supported by clients created for a clientset")
+
+// NewClientForClientset returns a new client making its requests with the given
+// clientset, such as a fake clientset of k8s.io/client-go/kubernetes/fake, and
+// streaming to and from containers with stream. Its default namespace is
+// "default". Port forwarding, debug containers and dry runs are not supported,
+// and streams fail if stream is nil.
+func NewClientForClientset(clientset kubernetes.Interface, stream StreamFunc) *Client {
+	if stream == nil {
+		stream = func(context.Context, StreamRequest) error {
+			return errors.New("streams are not supported by the clientset")
+		}
+	}
+
+	return &Client{clientset: clientset, namespace: v1.NamespaceDefault, stream: stream}
+}
+
+// Namespace returns the default namespace of the client: the namespace of the
+// current context of the kubeconfig, or of the service account in a cluster.
+func (c *Client) Namespace() string {
+	return c.namespace
+}
+
+// Clientset returns the clientset of the client, to make other requests to the
+// cluster with the same configuration.
+func (c *Client) Clientset() kubernetes.Interface {
+	return c.clientset
+}
+
+// RESTConfig returns the REST config of the client, or nil if it was created
+// for a clientset. It must not be modified.
+func (c *Client) RESTConfig() *restclient.Config {
+	return c.config
+}
+
+// kubeconfigKey is a kubeconfig path, with the context, cluster and user
+// selected in it, if any, and the rate limits of the client
+type kubeconfigKey struct {
+	path    string
+	context string
+	cluster string
+	user    string
+
+	qps     float32
+	burst   int
+	limiter flowcontrol.RateLimiter
+}
+
+// rateLimits sets the rate limits of the key on the REST config, keeping the
+// defaults of client-go for those not set
+func (k kubeconfigKey) rateLimits(config *restclient.Config) {
+	if k.qps > 0 {
+		config.QPS = k.qps
+	}
+	if k.burst > 0 {
+		config.Burst = k.burst
+	}
+	if k.limiter != nil {
+		config.RateLimiter = k.limiter
+	}
+}
+
+// overrides returns the kubeconfig overrides selecting the context, cluster
+// and user of the key
+func (k kubeconfigKey) overrides() *clientcmd.ConfigOverrides {
+	return &clientcmd.ConfigOverrides{
+		CurrentContext: k.context,
+		Context: clientcmdapi.Context{
+			Cluster:  k.cluster,
+			AuthInfo: k.user,
+		},
+	}
+}
+
+// clients caches the clients created for commands that do not set one,
+// keyed by kubeconfig path and selected context, cluster and user
+var clients = struct {
+	sync.Mutex
+	m map[kubeconfigKey]*Client
+}{m: map[kubeconfigKey]*Client{}}
+
+// getClient returns the client to use: the given one if not nil, or else the
+// cached client for the kubeconfig, which is created on first use
+func getClient(client *Client, key kubeconfigKey, log Logger) (*Client, error) {
+	if client != nil {
+		return client, nil
+	}
+
+	if key.path == "" {
+		key.path = os.Getenv(clientcmd.RecommendedConfigPathEnvVar)
+	}
+
+	clients.Lock()
+	defer clients.Unlock()
+
+	if c, ok := clients.m[key]; ok {
+		return c, nil
+	}
+
+	c, err := newClient(key.path, key.overrides(), key.rateLimits)
+	if err != nil {
+		log.Error("cannot create kubernetes client", "kubeconfig", key.path, "context", key.context, "error", err)
+		return nil, err
+	}
+	clients.m[key] = c
+	log.Debug("kubernetes client created", "kubeconfig", key.path, "context", key.context, "host", c.config.Host)
+
+	return c, nil
+}
+
+// kubeconfigKey returns the kubeconfig path of the config, and the context,
+// cluster and user selected in it
+func (cfg *Config) kubeconfigKey() kubeconfigKey {
+	return kubeconfigKey{
+		path:    cfg.Kubeconfig,
+		context: cfg.Context,
+		cluster: cfg.Cluster,
+		user:    cfg.User,
+		qps:     cfg.QPS,
+		burst:   cfg.Burst,
+		limiter: cfg.RateLimiter,
+	}
+}
@@ -1,23 +1,411 @@
 package exec
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	batchv1 "k8s.io/api/batch/v1"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/util/flowcontrol"
 )
 
 // Config contains all Kubernetes configuration
 type Config struct {
+	// Kubeconfig is the path to the kubeconfig file. If empty, the KUBECONFIG
+	// environment variable is used, then the in-cluster configuration.
 	Kubeconfig string
-	Namespace  string
-	Name       string
-	Image      string
 
-	Secrets []Secret
+	// Context, Cluster and User, if not empty, select the context of the
+	// kubeconfig, and the cluster and user used in it, instead of those of
+	// its current context.
+	Context string
+	Cluster string
+	User    string
+
+	// QPS and Burst, if not zero, are the sustained and burst rates of the
+	// requests of the client to the API server, instead of the client-go
+	// defaults of 5 and 10. RateLimiter, if not nil, limits the requests
+	// instead, and can be shared by clients to bound their overall rate.
+	QPS         float32
+	Burst       int
+	RateLimiter flowcontrol.RateLimiter
+
+	// Client is the client used to reach the cluster. If nil, a client for
+	// Kubeconfig is created on first use and shared by all commands using
+	// the same Kubeconfig, Context, Cluster, User and rate limits.
+	Client *Client
+
+	// Executor, if not nil, is the backend running the pod of the command,
+	// instead of Client. The features that need a Kubernetes cluster fail
+	// with executors other than a *Client.
+	Executor Executor
+
+	// Namespace is the namespace of the pod. If empty, the default namespace of
+	// the client is used.
+	Namespace string
+	Name      string
+	Image     string
+
+	// ImageDigest pins the image to a digest, such as "sha256:...", replacing
+	// its tag, for reproducible runs.
+	ImageDigest string
+
+	// ResolveDigest, if not nil and ImageDigest is empty, is called when the
+	// command starts to resolve the image to the digest it is pinned to.
+	ResolveDigest func(ctx context.Context, image string) (string, error)
+
+	// ImagePullPolicy is the pull policy of the image. If empty, the image is
+	// always pulled, unless it is pinned by digest.
+	ImagePullPolicy v1.PullPolicy
+
+	// GenerateName is a prefix, such as "kube-exec-", used to generate a unique
+	// name for the pod when Name is empty, so concurrent commands with the same
+	// config do not collide. The generated name is returned by Cmd.PodName.
+	// Either way, the pod is tracked by its UID once created: another pod
+	// created with its name after it was deleted is never watched, attached
+	// to or deleted in its place.
+	GenerateName string
+
+	// Precheck checks, before creating the pod, that it fits in the resource
+	// quotas and limit ranges of the namespace, and satisfies its enforced Pod
+	// Security Standard, and fails with an error of kind ErrAdmission listing
+	// the problems otherwise.
+	Precheck bool
+
+	// Preflight checks, before creating anything, that the API server can be
+	// reached and that the client is granted the permissions the command
+	// needs in the namespace, and fails with an error of kind ErrPreflight
+	// naming the missing ones otherwise (see Client.Ping).
+	Preflight bool
+
+	// CreateNamespace creates the namespace, with NamespaceLabels, if it does
+	// not exist. Otherwise, a missing namespace fails with ErrNamespaceNotFound.
+	CreateNamespace bool
+	NamespaceLabels map[string]string
+
+	Secrets    []Secret
+	ConfigMaps []ConfigMapKey
+	Volumes    []Volume
+
+	// EphemeralSecrets are secrets created for the command, exposed to it as
+	// env variables or files, and deleted once it terminates (see
+	// WithEphemeralSecret).
+	EphemeralSecrets []EphemeralSecret
+
+	// EnvVars are added to the environment of the command, for values that are
+	// not literals, such as downward API fields (see FieldEnv and ResourceEnv).
+	// Literal values are set with Cmd.Env.
+	EnvVars []v1.EnvVar
+
+	// EnvFrom sets all the keys of config maps or secrets as env variables.
+	EnvFrom []v1.EnvFromSource
+
+	// ServiceAccountName is the service account the pod runs as. If empty, the
+	// default service account of the namespace is used.
+	ServiceAccountName string
+
+	// ImagePullSecrets are the names of the secrets used to pull the image
+	// from private registries.
+	ImagePullSecrets []string
+
+	// AutomountServiceAccountToken controls whether the token of the service
+	// account is mounted into the pod. If nil, the cluster default is used.
+	AutomountServiceAccountToken *bool
+
+	// PodSecurityContext and SecurityContext are the security contexts of the
+	// pod and of the container running the command. If SecurityContext is nil,
+	// the container is not privileged.
+	PodSecurityContext *v1.PodSecurityContext
+	SecurityContext    *v1.SecurityContext
+
+	// Restricted adjusts the security contexts to satisfy the "restricted" Pod
+	// Security Standard: the container runs as non-root, without privilege
+	// escalation, with all capabilities dropped and the runtime default
+	// seccomp profile. The image must run as a non-root user.
+	Restricted bool
+
+	// OS and Arch, if not empty, are the operating system, such as OSLinux or
+	// OSWindows, and the architecture, such as "amd64", of the node the pod must
+	// be scheduled on. With OSWindows, the Linux-only fields of the security
+	// contexts are not set, and Restricted only requires a non-root user.
+	OS   string
+	Arch string
+
+	// NodeSelector, Tolerations and Affinity constrain the nodes the pod can
+	// be scheduled on. NodeSelector and Tolerations are added to those of the
+	// PodTemplate, and Affinity replaces its affinity if not nil.
+	NodeSelector map[string]string
+	Tolerations  []v1.Toleration
+	Affinity     *v1.Affinity
+
+	// NodeName, if not empty, is the name of the node the pod runs on,
+	// bypassing the scheduler.
+	NodeName string
+
+	// Host, if not nil, gives the pod access to the namespaces and to the
+	// filesystem of its node. This is dangerous: the command can observe and
+	// disrupt everything running on the node. It cannot be used with Restricted.
+	Host *HostAccess
+
+	// DNSPolicy, DNSConfig and HostAliases, if set, are the DNS policy of the
+	// pod, the options of its /etc/resolv.conf, such as name servers and
+	// search domains, and the entries added to its /etc/hosts. With the None
+	// DNS policy, for cluster-external DNS only, the resolv.conf only has the
+	// options of DNSConfig, which must have a name server.
+	DNSPolicy   v1.DNSPolicy
+	DNSConfig   *v1.PodDNSConfig
+	HostAliases []v1.HostAlias
+
+	// RuntimeClassName and PriorityClassName are the names of the runtime class,
+	// such as "nvidia" for GPUs, and of the priority class of the pod, if not
+	// empty.
+	RuntimeClassName  string
+	PriorityClassName string
+
+	// NoPreemption refuses to run the command, with an error of kind
+	// ErrPreemption, if its pod could preempt other pods of the cluster: if
+	// its priority class, or the default one, has a higher value than another
+	// class or than pods without a class. It needs the permission to list
+	// priority classes, which are cluster-wide.
+	//
+	// The PreemptionPolicy of pods and priority classes, which would run the
+	// pod with its priority but without preempting others, is not supported
+	// by the version of the Kubernetes API of the package, Kubernetes 1.13,
+	// as it was added in 1.15. Refusing to run the command is then the only
+	// guarantee that it does not evict the pods of shared clusters.
+	NoPreemption bool
+
+	// Requests and Limits are the compute resources requested by, and the
+	// limits of, the container running the command.
+	Requests Resources
+	Limits   Resources
+
+	// FieldManager is the name of the field manager the pods and jobs are
+	// created as, recorded by the server in their managed fields, so GitOps
+	// and audit tooling can attribute them.
+	FieldManager string
+
+	// ServerSideApply creates the pod with a server-side apply, as
+	// FieldManager or "kube-exec" if empty, retried after transient errors
+	// since applying it again does not create another pod. The name of the pod
+	// is generated locally from its generate name, and an existing pod of the
+	// same name and spec applied by the same field manager is adopted, while
+	// conflicting fields fail with ErrPodCreate. It needs Kubernetes 1.16 and
+	// cannot be used with RunAsJob.
+	ServerSideApply bool
+
+	// DryRun sends the pod to the server to be validated and defaulted, but not
+	// persisted, and the command is not run. The pod returned by the server is
+	// available from Cmd.Manifest.
+	DryRun bool
+
+	// Cleanup deletes the pod after the command completes.
+	Cleanup bool
+
+	// CleanupGracePeriod is the grace period, in seconds, used when deleting the pod.
+	// If nil, the default grace period of the pod is used.
+	CleanupGracePeriod *int64
+
+	// StartTimeout bounds the time to wait for the pod to start running. If zero,
+	// there is no timeout. Independently of the timeout, waiting fails early if
+	// the pod cannot be scheduled or its container cannot start.
+	StartTimeout time.Duration
+
+	// ImagePullRetries is the number of failed pulls of the image of a
+	// container, retried by the kubelet with backoff, tolerated before waiting
+	// for the pod to start fails with an *ImagePullError. If zero, the first
+	// failure fails it.
+	ImagePullRetries int
+
+	// TimeToSchedule and TimeToStart, if not zero, bound the time for the pod
+	// to be scheduled on a node, and then to start, pulling its images and
+	// running its init containers. ExecutionTimeout bounds the time the
+	// command runs once started, and StreamIdleTimeout the time it runs
+	// without output while attached to. Exceeding them fails the command with
+	// a *TimeoutError of the corresponding stage, and the last two stop the
+	// command as if its context was done.
+	TimeToSchedule    time.Duration
+	TimeToStart       time.Duration
+	ExecutionTimeout  time.Duration
+	StreamIdleTimeout time.Duration
+
+	// FlushTimeout, if not zero, bounds the time spent copying the output of
+	// a command stopped while attached to, such as by its context or a
+	// timeout, or whose stream was lost, from the logs of its pod before it
+	// is deleted, so the output not received yet is not lost. The logs have
+	// a precision of a second, so some output may be repeated, and are
+	// written to Stdout.
+	FlushTimeout time.Duration
+
+	// WaitFor selects when the pod is considered started, and the command is
+	// attached to. If zero, it is once the pod is running, even if the
+	// container of the command is still starting.
+	WaitFor WaitMode
+
+	// ReadinessProbe and LivenessProbe, if not nil, are set on the container
+	// of the command, to wait for it to be ready with WaitReady, and to have
+	// it restarted when it hangs. The startup probes are not supported by the
+	// version of the Kubernetes API of the package: the initial delay of the
+	// probes serves the same purpose.
+	ReadinessProbe *v1.Probe
+	LivenessProbe  *v1.Probe
+
+	// HealthCheck, if not nil, waits for a server started by the command to
+	// respond, once the pod started and before the command is attached to.
+	// The output produced before is missed, unless reading the Logs. Waiting
+	// is bounded by the context of the command.
+	HealthCheck *HealthCheck
+
+	// ActiveDeadlineSeconds, if not nil, bounds the time the pod, or the job
+	// when RunAsJob is set, may run before the cluster kills it, even if the
+	// client is gone. Exceeding it fails the command with a *DeadlineError.
+	ActiveDeadlineSeconds *int64
+
+	// RestartPolicy is the restart policy of the pod, Never or OnFailure. If
+	// empty, it is the one of the PodTemplate, or Never, so a failed command
+	// fails. Otherwise, the container of a failed command is restarted by the
+	// kubelet, up to MaxRestarts times if not zero, and its output is read
+	// from the logs of every new container, with stderr merged into stdout.
+	// The input of the command is not sent again to the new containers.
+	RestartPolicy v1.RestartPolicy
+	MaxRestarts   int
+
+	// Retry, if not nil, runs the command again in a new pod when its pod is
+	// evicted or lost, or when it exits with some exit codes. It is ignored
+	// with RunAsJob, whose pods are retried by the job.
+	Retry *RetryPolicy
+
+	// DiagnosticLogLines is the number of last lines of the logs collected,
+	// with the events and the container statuses of the pod, when the command
+	// fails (see Cmd.Diagnostics). If zero, 20 lines are collected. If
+	// negative, no diagnostics are collected.
+	DiagnosticLogLines int64
+
+	// KeepFailed keeps the pod for debugging if the command fails, even if Cleanup is set.
+	KeepFailed bool
+
+	// OwnerReferences are set on the created pod, so it is garbage collected
+	// together with its owner, even if the program never gets to clean it up.
+	OwnerReferences []metav1.OwnerReference
+
+	// RecordWriter, if not nil, receives the RunRecord of every command once
+	// it terminated, as a line of JSON written with a single call to Write,
+	// so the records of the commands sharing it form NDJSON. It must be safe
+	// for concurrent use by the commands running at the same time.
+	// RecordOutputLimit is the number of last bytes of each output stream
+	// kept in the records. If zero, it is 16KiB, and if negative, the output
+	// is not kept.
+	RecordWriter      io.Writer
+	RecordOutputLimit int
+
+	// Cache, if not nil, memoizes the results of deterministic commands, such
+	// as rendering templates: the result of a command is looked up by the
+	// SHA-256 of its config, with the digest of its image, of its command and
+	// of its input, and replayed without creating a pod if found. Otherwise
+	// the run record of the command, with its whole output, is stored once it
+	// succeeded, unless a stream exceeds 4MiB. The input is read before the
+	// command starts, so it cannot be a pipe. Cache needs ImageDigest or
+	// ResolveDigest.
+	Cache ResultCache
+
+	// Logger receives the events of the commands, such as pod created or
+	// stream closed. If nil, events are discarded.
+	Logger Logger
+
+	// Metrics, if not nil, records measurements of the commands, such as the
+	// time for the pod to run and the bytes streamed.
+	Metrics Metrics
+
+	// Tracer, if not nil, creates spans around creating the pod, waiting for it
+	// to be scheduled and running, and streaming, as children of a span for
+	// the whole command. The trace context is added to the pod annotations.
+	Tracer Tracer
+
+	// RunAsJob runs the command in a Kubernetes Job instead of a bare pod, so
+	// retries and backoff are handled by the cluster. The logs of the pods of
+	// the job are streamed to Stdout, and Stdin is not used.
+	RunAsJob bool
+
+	// BackoffLimit, Completions and TTLSecondsAfterFinished are set on the
+	// job spec when RunAsJob is set. If nil, the cluster defaults are used.
+	BackoffLimit            *int32
+	Completions             *int32
+	TTLSecondsAfterFinished *int32
+
+	// Mutators are called in order with every pod created for commands, after
+	// all the other fields of the config are applied, for instance to add the
+	// labels and annotations required by the policies of the cluster. With
+	// RunAsJob, the labels and annotations are set on the job and on its pods.
+	Mutators []PodMutator
+
+	// PodTemplate is an optional base spec for the created pod. The first container
+	// of the template, if any, is used for the command, and is otherwise added.
+	// Fields set in the template take precedence over the defaults of the package.
+	PodTemplate *v1.PodSpec
+
+	// MainContainer, if not empty, is the name of the container of the
+	// PodTemplate used for the command, instead of the first one.
+	MainContainer string
+
+	// InitContainers are run in order before the command, after those of the
+	// PodTemplate, for instance to fetch files into a volume shared with the
+	// command. If one of them fails, waiting for the command to start fails
+	// with an *InitError.
+	InitContainers []v1.Container
+
+	// Workspace, if not nil, is a scratch directory mounted in the container
+	// of the command, populated with local inputs before the command starts,
+	// and whose outputs are copied back after it terminates.
+	Workspace *Workspace
+
+	// Artifacts are glob patterns of paths in the container of the command,
+	// such as "/src/build/*.jar", archived with tar once the command
+	// terminates, whether it succeeded or not. The archive is written to
+	// ArtifactsWriter if not nil, or extracted to ArtifactsDir, or to the
+	// current directory if empty, with the leading slashes of the paths
+	// removed. The command runs through /bin/sh, and the image must have tar.
+	// Artifacts cannot be used with RunAsJob.
+	Artifacts       []string
+	ArtifactsDir    string
+	ArtifactsWriter io.Writer
+
+	// CaptureEnvironment captures the environment of the process before the
+	// command runs: its env variables, working directory, user and groups,
+	// hostname, kernel and the os-release of the image, returned by
+	// Environment and in the ProcessState once it terminated. The command runs
+	// through /bin/sh. CaptureEnvironment cannot be used with RunAsJob.
+	CaptureEnvironment bool
+
+	// Helper, if not nil, injects a static binary providing a shell, tar and
+	// kill into the container of the command, for images without them, such
+	// as scratch or distroless ones.
+	Helper *Helper
+
+	// Sidecars are containers run next to the command, such as a database
+	// proxy. The command succeeds or fails on the termination of its own
+	// container, and the pod is then deleted to stop the sidecars, unless
+	// KeepFailed is set and the command failed. Sidecars cannot be used with
+	// RunAsJob.
+	Sidecars []v1.Container
 }
 
+// PodMutator modifies a pod before it is created. An error aborts the creation.
+type PodMutator func(pod *v1.Pod) error
+
 // Secret represents a Kubernetes secret to pass into the pod as env variable
 type Secret struct {
 	EnvVarName string
@@ -25,21 +413,395 @@ type Secret struct {
 	SecretKey  string
 }
 
+// ConfigMapKey represents a key of a Kubernetes config map to pass into the pod
+// as env variable
+type ConfigMapKey struct {
+	EnvVarName    string
+	ConfigMapName string
+	ConfigMapKey  string
+}
+
+// FieldEnv returns an env variable set to a field of the pod, through the
+// downward API, such as metadata.name, metadata.namespace or spec.nodeName.
+func FieldEnv(name, fieldPath string) v1.EnvVar {
+	return v1.EnvVar{
+		Name: name,
+		ValueFrom: &v1.EnvVarSource{
+			FieldRef: &v1.ObjectFieldSelector{FieldPath: fieldPath},
+		},
+	}
+}
+
+// ResourceEnv returns an env variable set to a resource request or limit of the
+// container running the command, through the downward API, such as
+// limits.cpu or requests.memory.
+func ResourceEnv(name, resource string) v1.EnvVar {
+	return v1.EnvVar{
+		Name: name,
+		ValueFrom: &v1.EnvVarSource{
+			ResourceFieldRef: &v1.ResourceFieldSelector{Resource: resource},
+		},
+	}
+}
+
+// Volume represents a Kubernetes volume to mount into the pod, such as a
+// secret, a config map, an empty dir or a persistent volume claim
+type Volume struct {
+	Name      string
+	MountPath string
+	SubPath   string
+	ReadOnly  bool
+
+	Source v1.VolumeSource
+}
+
+// HostAccess is the access of a pod to its node, for troubleshooting the node
+type HostAccess struct {
+	// Network, PID and IPC run the pod in the network, process and IPC
+	// namespaces of the node.
+	Network bool
+	PID     bool
+	IPC     bool
+
+	// Paths are the directories or files of the node mounted in the container.
+	Paths []HostPath
+}
+
+// HostPath is a path of the node mounted in the container of the command
+type HostPath struct {
+	Path      string
+	MountPath string
+	ReadOnly  bool
+}
+
+// Resources are amounts of compute resources, as quantity strings such as
+// "500m" for CPU or "256Mi" for memory. Empty amounts are not set.
+type Resources struct {
+	CPU              string
+	Memory           string
+	EphemeralStorage string
+
+	// Extended are the amounts of extended resources, such as
+	// ResourceNvidiaGPU, and of hugepages, such as "hugepages-2Mi", by name.
+	// They cannot be overcommitted: an amount only requested is also set as
+	// the limit, and an amount both requested and limited must be the same.
+	// Extended resources are whole numbers, and hugepages need a CPU or
+	// Memory amount too.
+	Extended map[string]string
+}
+
+// Names of common extended resources, advertised by the device plugins of
+// the nodes.
+const (
+	ResourceNvidiaGPU = "nvidia.com/gpu"
+	ResourceAMDGPU    = "amd.com/gpu"
+)
+
+// resourceList parses the amounts into a Kubernetes API resource list
+func (r Resources) resourceList() (v1.ResourceList, error) {
+	list := v1.ResourceList{}
+	for name, amount := range map[v1.ResourceName]string{
+		v1.ResourceCPU:              r.CPU,
+		v1.ResourceMemory:           r.Memory,
+		v1.ResourceEphemeralStorage: r.EphemeralStorage,
+	} {
+		if amount == "" {
+			continue
+		}
+		q, err := resource.ParseQuantity(amount)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s quantity %q: %v", name, amount, err)
+		}
+		list[name] = q
+	}
+	for name, amount := range r.Extended {
+		q, err := resource.ParseQuantity(amount)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s quantity %q: %v", name, amount, err)
+		}
+		list[v1.ResourceName(name)] = q
+	}
+
+	return list, nil
+}
+
+// overcommittable reports whether the requests of the named resource can be
+// lower than its limits, which is not the case of the extended resources and
+// hugepages
+func overcommittable(name v1.ResourceName) bool {
+	return !strings.HasPrefix(string(name), v1.ResourceHugePagesPrefix) && !strings.Contains(string(name), "/")
+}
+
 // Cmd represents the command to execute inside the pod
 type Cmd struct {
 	Path string
 	Args []string
-	//Env  []string
+
+	// Script, if not empty, is a script run by Path as interpreter, from a
+	// config map mounted in the pod, at ScriptPath passed before Args (see
+	// Script).
+	Script string
+
+	// Env specifies the environment of the command, each entry of the form "key=value".
+	// Unlike os/exec, an empty Env does not inherit the local environment.
+	Env []string
+
+	// Dir specifies the working directory of the command inside the container.
+	// If Dir is empty, the image's default working directory is used.
 	Dir string
 
-	Cfg Config
-	pod *v1.Pod
+	// Shell, if not empty, runs the command through a shell, such as
+	// []string{"/bin/sh", "-c"}, or []string{"/bin/bash", "-lc"} for a login
+	// shell. Path and Args are quoted, so they reach the command unchanged.
+	// On Windows, []string{"cmd", "/S", "/C"} and
+	// []string{"powershell", "-NoProfile", "-Command"} are quoted accordingly.
+	Shell []string
+
+	// ProcessState contains information about the terminated command. It is
+	// available after Wait, or once the channel returned by Done is closed,
+	// and is nil for commands that run as jobs, or did not terminate.
+	ProcessState *ProcessState
+
+	Cfg    Config
+	pod    *v1.Pod
+	job    *batchv1.Job
+	client *Client
+	exec   Executor
+	log    Logger
+	hooks  *hookTracker
+
+	// diagnostics are collected when the command fails
+	diagnostics *Diagnostics
+
+	// attempt is the number of the current attempt, and idempotencyKey the
+	// key labeling the pods of all attempts, with a retry policy
+	attempt        int
+	idempotencyKey string
+
+	// avoidedNodes are the nodes of the disrupted pods of the previous
+	// attempts, with RetryPolicy.OtherNodes
+	avoidedNodes []string
+
+	// runID labels the pods of the command, and its job
+	runID string
+
+	// pulls follows the image pulls of the pod
+	pulls *pullTracker
 
-	Stdin  io.Reader
+	// placement is where the started pod runs
+	placementMu sync.Mutex
+	placement   *Placement
+
+	// lastOutput is the time, in Unix nanoseconds, the last output of the
+	// command was received while attached to it
+	lastOutput int64
+
+	// flushed is closed once the output of the command is flushed from the
+	// logs of its pod, which is only deleted then
+	flushed chan struct{}
+
+	// deleting is set once the pod is deleted by the command itself, and
+	// killed once it is deleted to kill the command
+	deleting int32
+	killed   int32
+
+	// restarts is the number of restarts of the container of the command
+	// followed in the current pod
+	restarts int
+
+	// lines receives the output of the command for OnLine
+	lines *lineSink
+
+	// record is the run record of the terminated command, with the last bytes
+	// of its output kept by stdoutTail and stderrTail
+	record     *RunRecord
+	stdoutTail *tailWriter
+	stderrTail *tailWriter
+
+	// cacheKey is the key of the result of the command in the cache, whose
+	// output is kept by cacheStdout and cacheStderr to be stored
+	cacheKey    string
+	cacheStdout *tailWriter
+	cacheStderr *tailWriter
+
+	// environment is the environment captured by the command
+	environment *Environment
+
+	// timeoutErr is the timeout exceeded by the command, which cannot expire
+	// anymore once disarmed
+	timeoutMu  sync.Mutex
+	timeoutErr *TimeoutError
+	disarmed   bool
+
+	// secrets are the ephemeral secrets created for the command, and script
+	// the config map of its script
+	secrets []*v1.Secret
+	script  *v1.ConfigMap
+
+	// sessions are the sessions opened next to the command, closed once it
+	// terminated
+	sessionsMu     sync.Mutex
+	sessions       map[*Session]struct{}
+	sessionsClosed bool
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	traceCtx  context.Context
+	endTrace  func(error)
+	startTime time.Time
+	done      chan struct{}
+	exited    chan struct{}
+	errc      chan error
+	finished  bool
+
+	// closeAfterStream are the pipe ends closed when the streams end, and
+	// closeAfterWait the ones closed by Wait
+	closeAfterStream []io.Closer
+	closeAfterWait   []io.Closer
+
+	// stdinData and stdinPath are the input set by StdinBytes or StdinString,
+	// and the file set by StdinFile, sent again by the clones of the command
+	stdinData []byte
+	stdinPath string
+
+	// Stdin is the standard input of the command. Once it returns EOF, or
+	// the stream to the pod ends, the command reads EOF: it cannot be attached
+	// to again with a new stdin, and reconnecting does not resume it.
+	Stdin io.Reader
+
+	// Stdout and Stderr receive the standard output and error of the command,
+	// on separate streams. If they are the same writer, with a type that can
+	// be compared with ==, at most one goroutine at a time calls Write, and
+	// every write is whole.
 	Stdout io.Writer
 	Stderr io.Writer
+
+	// MergeStderr writes the standard error of the command to Stdout, as a
+	// single stream. With a Shell, the command is run with 2>&1, so the order
+	// of the writes of the command is preserved. Without one, the standard
+	// error is still received on its own stream, and written whole to Stdout
+	// in the order received, which may differ from the order of the writes
+	// made close together by the command. With a TTY, or when following the
+	// Logs, both are already merged by the cluster.
+	MergeStderr bool
+
+	// Compression, if not nil, compresses the standard output of the command
+	// in the container, and decompresses it before writing it to Stdout.
+	Compression *Compression
+
+	// Container is the name of the container of the pod to attach to, and to
+	// read the logs of. If empty, the container running the command is used.
+	// Other containers, such as sidecars of the PodTemplate or init containers,
+	// can be attached to instead. An init container is attached to once it
+	// runs, or its logs are read if it already terminated.
+	Container string
+
+	// InitOutput, if not nil, receives the logs of the init containers of the
+	// pod while it starts, in order, each once it terminated and with its
+	// lines prefixed with "[name] ", up to the first one that failed.
+	InitOutput io.Writer
+
+	// Logs selects how the output of the command is read. By default, the
+	// command is attached to, which misses the output produced before the
+	// stream connects.
+	Logs LogMode
+
+	// DisableReconnect disables attaching again to the pod, with exponential
+	// backoff, when the stream breaks before the command completes.
+	DisableReconnect bool
+
+	// MaxReconnects bounds the number of times the pod is attached to again.
+	// If zero, it is attached to again up to 5 times.
+	MaxReconnects int
+
+	// Keepalive, if not zero, is the period of the traffic sent on idle streams
+	// so they are not closed by proxies and load balancers, such as ELBs and
+	// nginx: TCP keepalives, and with a TTY the terminal size.
+	Keepalive time.Duration
+
+	// OnPodUpdate, if not nil, is called with every state of the pod observed
+	// while waiting for it to start and to complete.
+	OnPodUpdate func(pod *v1.Pod)
+
+	// Hooks, if not nil, are called on the lifecycle events of the pod, such
+	// as scheduled, pulling, started and terminated.
+	Hooks *Hooks
+
+	// TTY allocates a terminal for the command, for interactive sessions.
+	// Stderr is merged into Stdout. If Stdin is a local terminal, it is put in
+	// raw mode while the command runs, and its size changes are propagated.
+	TTY bool
+
+	// OnLine, if not nil, is called with every line of the output of the
+	// command, in addition to writing it to Stdout and Stderr if set. It is
+	// called from a single goroutine, with up to LineBuffer lines buffered,
+	// or 1000 if zero: while the buffer is full, the output of the command is
+	// not read, which eventually blocks the command. Wait returns once it was
+	// called with all the lines.
+	OnLine     func(line Line)
+	LineBuffer int
+
+	// NoEcho disables the echo of the lines typed in the local terminal of
+	// Stdin when the command runs without a TTY, such as to type secrets.
+	// With a TTY, the echo is controlled by the command, through its terminal.
+	NoEcho bool
+
+	// ForwardSignals forwards the interrupt and termination signals received
+	// by the local process, such as Ctrl-C, to the command with Signal while
+	// it runs, instead of terminating the local process. A second signal kills
+	// the command. With a TTY, Ctrl-C is already sent through the terminal.
+	ForwardSignals bool
+
+	// SessionRecording, if not nil, receives a recording of the session
+	// attached to the command, for audit trails of interactive sessions: its
+	// output, the resizes of its terminal, and its input if RecordInput is
+	// set, with their timing, in the asciicast v2 format of asciinema. It can
+	// be played with ReadRecording and Play, or with asciinema.
+	SessionRecording io.Writer
+	RecordInput      bool
 }
 
+const (
+	// maxReconnects is the number of times to attach again to a pod after the
+	// stream breaks, starting after reconnectBackoff and doubling every time
+	maxReconnects    = 5
+	reconnectBackoff = time.Second
+)
+
+// LogMode selects how the output of a command is read from the pod
+type LogMode int
+
+const (
+	// LogsAttach attaches to the pod, and only gets the output produced after
+	// the stream connects.
+	LogsAttach LogMode = iota
+
+	// LogsFollow follows the logs of the pod instead of attaching, so no output
+	// is missed. Stdin is not used, and stderr is merged into stdout.
+	LogsFollow
+
+	// LogsBacklog writes the logs of the pod produced so far to stdout, then
+	// attaches to the pod. Output produced while the stream connects may
+	// still be missed.
+	LogsBacklog
+)
+
+// WaitMode selects when the pod of a command is considered started
+type WaitMode int
+
+const (
+	// WaitRunning waits for the pod to be running: one of its containers at
+	// least runs.
+	WaitRunning WaitMode = iota
+
+	// WaitStarted waits for the container attached to to be running.
+	WaitStarted
+
+	// WaitReady waits for the container attached to to be ready: running,
+	// and passing its readiness probe if it has one.
+	WaitReady
+)
+
 // Command returns the Cmd struct to execute the named program with
 // the given arguments.
 func Command(cfg Config, name string, arg ...string) *Cmd {
@@ -47,26 +809,497 @@ func Command(cfg Config, name string, arg ...string) *Cmd {
 		Cfg:  cfg,
 		Path: name,
 		Args: arg,
+		ctx:  context.Background(),
+	}
+}
+
+// CommandContext is like Command but includes a context.
+//
+// The provided context is used to abort pod creation, waiting and streaming,
+// and to delete the pod if the context becomes done before the command
+// completes on its own.
+func CommandContext(ctx context.Context, cfg Config, name string, arg ...string) *Cmd {
+	if ctx == nil {
+		panic("nil Context")
+	}
+
+	cmd := Command(cfg, name, arg...)
+	cmd.ctx = ctx
+
+	return cmd
+}
+
+// clone returns a new command, not started, with the exported fields and the
+// context of cmd, to run it again. The input set with StdinBytes, StdinString
+// or StdinFile is sent again from the start, while another Stdin is shared.
+// The fields added to Cmd must be copied here.
+func (cmd *Cmd) clone() (*Cmd, error) {
+	c := &Cmd{
+		Path:             cmd.Path,
+		Args:             cmd.Args,
+		Script:           cmd.Script,
+		Env:              cmd.Env,
+		Dir:              cmd.Dir,
+		Shell:            cmd.Shell,
+		Cfg:              cmd.Cfg,
+		Stdin:            cmd.Stdin,
+		Stdout:           cmd.Stdout,
+		Stderr:           cmd.Stderr,
+		MergeStderr:      cmd.MergeStderr,
+		Compression:      cmd.Compression,
+		Container:        cmd.Container,
+		InitOutput:       cmd.InitOutput,
+		Logs:             cmd.Logs,
+		DisableReconnect: cmd.DisableReconnect,
+		MaxReconnects:    cmd.MaxReconnects,
+		Keepalive:        cmd.Keepalive,
+		OnPodUpdate:      cmd.OnPodUpdate,
+		Hooks:            cmd.Hooks,
+		TTY:              cmd.TTY,
+		OnLine:           cmd.OnLine,
+		LineBuffer:       cmd.LineBuffer,
+		NoEcho:           cmd.NoEcho,
+		ForwardSignals:   cmd.ForwardSignals,
+		SessionRecording: cmd.SessionRecording,
+		RecordInput:      cmd.RecordInput,
+		ctx:              cmd.ctx,
+	}
+
+	switch {
+	case cmd.stdinPath != "":
+		c.Stdin = nil
+		if err := c.StdinFile(cmd.stdinPath); err != nil {
+			return nil, err
+		}
+	case cmd.stdinData != nil:
+		c.Stdin = nil
+		if err := c.StdinBytes(cmd.stdinData); err != nil {
+			return nil, err
+		}
 	}
+
+	return c, nil
 }
 
 // Start starts the specified command but does not wait for it to complete.
-func (cmd *Cmd) Start() error {
-	pod, err := createPod(cmd.Cfg, []string{cmd.Path}, cmd.Args)
+func (cmd *Cmd) Start() (err error) {
+	if cmd.done != nil {
+		return errors.New("exec: already started")
+	}
+
+	cmd.log = loggerOrNop(cmd.Cfg.Logger)
+	cmd.attempt = 1
+	cmd.runID = newRunID()
+	cmd.pulls = &pullTracker{}
+	if cmd.Hooks != nil {
+		cmd.hooks = &hookTracker{hooks: cmd.Hooks}
+	}
+
+	// the command is stopped through its context when it exceeds a timeout
+	if cmd.Cfg.ExecutionTimeout > 0 || cmd.Cfg.StreamIdleTimeout > 0 {
+		cmd.ctx, cmd.cancel = context.WithCancel(cmd.ctx)
+	}
+
+	cmd.traceCtx, cmd.endTrace = cmd.ctx, func(error) {}
+	if cmd.Cfg.Tracer != nil {
+		var span Span
+		cmd.traceCtx, span = cmd.Cfg.Tracer.Start(cmd.ctx, "kube-exec.command", map[string]string{
+			"process.command": cmd.Path,
+		})
+		cmd.endTrace = span.End
+	}
+	defer func() {
+		if err != nil {
+			cmd.endTrace(err)
+			if cmd.cancel != nil {
+				cmd.cancel()
+			}
+			closeAll(cmd.closeAfterStream)
+			closeAll(cmd.closeAfterWait)
+		}
+	}()
+
+	if err := cmd.Cfg.Validate(); err != nil {
+		cmd.log.Error("invalid config", "error", err)
+		return err
+	}
+	if cmd.Compression != nil && (cmd.TTY || cmd.Logs != LogsAttach) {
+		return errors.New("exec: Compression cannot be used with TTY nor Logs")
+	}
+	if cmd.OnLine != nil {
+		cmd.setupLines()
+	}
+	if cmd.Cfg.RecordWriter != nil {
+		cmd.setupRecord()
+	}
+
+	if cmd.Cfg.Executor != nil {
+		cmd.exec = cmd.Cfg.Executor
+		cmd.client, _ = cmd.Cfg.Executor.(*Client)
+	} else {
+		client, err := getClient(cmd.Cfg.Client, cmd.Cfg.kubeconfigKey(), cmd.log)
+		if err != nil {
+			return err
+		}
+		cmd.client, cmd.exec = client, client
+	}
+
+	if cmd.Cfg.Namespace == "" {
+		cmd.Cfg.Namespace = v1.NamespaceDefault
+		if cmd.client != nil {
+			cmd.Cfg.Namespace = cmd.client.namespace
+		}
+	}
+
+	if cmd.Cfg.Preflight {
+		if err := cmd.client.Ping(cmd.Cfg.Namespace, cmd.permissions()...); err != nil {
+			cmd.log.Error("preflight check failed", "namespace", cmd.Cfg.Namespace, "error", err)
+			return err
+		}
+	}
+
+	if cmd.Cfg.NoPreemption {
+		if err := cmd.client.checkPreemption(cmd.Cfg.PriorityClassName); err != nil {
+			cmd.log.Error("pod could preempt other pods", "namespace", cmd.Cfg.Namespace, "priorityClass", cmd.Cfg.PriorityClassName, "error", err)
+			return err
+		}
+	}
+
+	if cmd.Cfg.ResolveDigest != nil && cmd.Cfg.ImageDigest == "" {
+		cmd.Cfg.ImageDigest, err = cmd.Cfg.ResolveDigest(cmd.ctx, cmd.Cfg.Image)
+		if err != nil {
+			return fmt.Errorf("cannot resolve digest of image %s: %w", cmd.Cfg.Image, err)
+		}
+	}
+
+	if cmd.Cfg.Cache != nil {
+		hit, err := cmd.lookupCache()
+		if err != nil {
+			return err
+		}
+		if hit {
+			return nil
+		}
+	}
+
+	err = cmd.create()
 	if err != nil {
-		return fmt.Errorf("cannot create pod: %v", err)
+		cmd.log.Error("cannot create pod", "namespace", cmd.Cfg.Namespace, "name", cmd.Cfg.Name, "error", err)
+		cmd.deleteSecrets()
+		cmd.deleteScript()
+		return err
+	}
+
+	cmd.startTime = time.Now()
+	if cmd.Cfg.Metrics != nil {
+		cmd.Cfg.Metrics.PodCreated(cmd.Cfg.Namespace)
 	}
 
-	cmd.pod = pod
+	cmd.done = make(chan struct{})
+	cmd.errc = make(chan error, 1)
+	cmd.exited = make(chan struct{})
+
+	if cmd.Cfg.FlushTimeout > 0 {
+		cmd.flushed = make(chan struct{})
+	}
+
+	// streaming starts right away, so the pipes can be used before Wait
+	go func() {
+		err := cmd.run()
+		cmd.closeSessions()
+		cmd.flushLogs(err)
+		if terr := cmd.disarm(); terr != nil {
+			err = terr
+		}
+		err = cmd.terminationError(err)
+		if err != nil && cmd.job == nil {
+			cmd.collectDiagnostics(err)
+		}
+		cmd.deleteSecrets()
+		cmd.deleteScript()
+		cmd.endTrace(err)
+		closeAll(cmd.closeAfterStream)
+		cmd.writeRecord(err)
+		cmd.storeResult(err)
+		cmd.errc <- err
+		close(cmd.exited)
+
+		// release the context of the timeouts from its parent
+		if cmd.cancel != nil {
+			cmd.cancel()
+		}
+	}()
+
+	if cmd.ForwardSignals && cmd.pod != nil && !cmd.Cfg.DryRun {
+		go cmd.forwardSignals()
+	}
+
+	if cmd.ctx.Done() != nil {
+		go func() {
+			select {
+			case <-cmd.ctx.Done():
+				// the command completed on its own
+				select {
+				case <-cmd.exited:
+					return
+				default:
+				}
+
+				cmd.log.Info("context done, deleting pod", "namespace", cmd.Cfg.Namespace, "name", cmd.Cfg.Name, "error", cmd.ctx.Err())
+				if cmd.flushed != nil {
+					select {
+					case <-cmd.flushed:
+					case <-time.After(cmd.Cfg.FlushTimeout):
+					}
+				}
+				cmd.delete()
+			case <-cmd.done:
+			}
+		}()
+	}
 
 	return nil
 }
 
+// Client returns the client used by the command, or nil if the command is not
+// started or runs with another executor.
+func (cmd *Cmd) Client() *Client {
+	return cmd.client
+}
+
+// Done returns a channel that is closed when the command terminates and its
+// output is copied, or nil if the command is not started. Wait must still be
+// called to get the error of the command, and to clean up.
+func (cmd *Cmd) Done() <-chan struct{} {
+	return cmd.exited
+}
+
+// create creates the pod, or the job, for the command
+func (cmd *Cmd) create() (err error) {
+	endSpan := cmd.startSpan("kube-exec.create")
+	defer func() { endSpan(err) }()
+
+	if cmd.client != nil {
+		err = cmd.client.ensureNamespace(cmd.Cfg.Namespace, cmd.Cfg.CreateNamespace && !cmd.Cfg.DryRun, cmd.Cfg.NamespaceLabels)
+		if err != nil {
+			return err
+		}
+	}
+	if len(cmd.secrets) == 0 {
+		if err := cmd.createSecrets(); err != nil {
+			return err
+		}
+	}
+	if err := cmd.createScript(); err != nil {
+		return err
+	}
+
+	if cmd.Cfg.RunAsJob {
+		job, err := newJob(cmd)
+		if err != nil {
+			return err
+		}
+		job.Annotations = cmd.injectTrace(job.Annotations)
+		if cmd.Cfg.Precheck {
+			if err := cmd.client.precheck(cmd.Cfg.Namespace, &job.Spec.Template.Spec); err != nil {
+				return err
+			}
+		}
+		err = retryNameCollision(&job.ObjectMeta, func() (err error) {
+			cmd.job, err = cmd.client.createJob(cmd.ctx, cmd.Cfg.Namespace, job, cmd.Cfg.FieldManager, cmd.Cfg.DryRun)
+			return err
+		})
+		if err == nil {
+			cmd.log.Info("job created", "namespace", cmd.job.Namespace, "job", cmd.job.Name)
+			owner := ownerReference("batch/v1", "Job", cmd.job.ObjectMeta)
+			cmd.ownSecrets(owner)
+			cmd.ownScript(owner)
+		}
+		return err
+	}
+
+	pod, err := newPod(cmd)
+	if err != nil {
+		return err
+	}
+	pod.Namespace = cmd.Cfg.Namespace
+	pod.Annotations = cmd.injectTrace(pod.Annotations)
+	cmd.labelPod(pod)
+	cmd.avoidNodes(pod)
+	if cmd.Cfg.Precheck {
+		if err := cmd.client.precheck(cmd.Cfg.Namespace, &pod.Spec); err != nil {
+			return err
+		}
+	}
+	err = retryNameCollision(&pod.ObjectMeta, func() (err error) {
+		switch {
+		case cmd.Cfg.ServerSideApply:
+			cmd.pod, err = cmd.client.applyPod(cmd.ctx, cmd.Cfg.Namespace, pod, cmd.Cfg.fieldManager(), cmd.Cfg.DryRun)
+		case cmd.Cfg.DryRun || cmd.Cfg.FieldManager != "":
+			cmd.pod, err = cmd.client.createPod(cmd.ctx, cmd.Cfg.Namespace, pod, cmd.Cfg.FieldManager, cmd.Cfg.DryRun)
+		default:
+			cmd.pod, err = cmd.exec.CreatePod(cmd.ctx, pod)
+		}
+		return err
+	})
+	if err == nil {
+		cmd.log.Info("pod created", "namespace", cmd.pod.Namespace, "pod", cmd.pod.Name)
+		owner := ownerReference("v1", "Pod", cmd.pod.ObjectMeta)
+		cmd.ownSecrets(owner)
+		cmd.ownScript(owner)
+	}
+	return err
+}
+
+// maxNameRetries is the number of times to retry creating an object with a
+// generated name that collides with an existing one
+const maxNameRetries = 3
+
+// retryNameCollision calls create, and calls it again with a new name if the
+// name generated for the object collides with an existing object. The retries
+// generate the name locally, in case the server does not generate it.
+func retryNameCollision(meta *metav1.ObjectMeta, create func() error) error {
+	generated := meta.Name == "" && meta.GenerateName != ""
+
+	err := create()
+	for i := 0; i < maxNameRetries && generated && apierrors.IsAlreadyExists(unwrap(err)); i++ {
+		meta.Name = meta.GenerateName + utilrand.String(5)
+		err = create()
+	}
+
+	return err
+}
+
+// Manifest returns the pod, or the job if the command runs as a job, created
+// for the command. Before the command is started, the object that would be
+// created is returned, without calling the API server. After, the object
+// returned by the server is, which is defaulted by the server in dry-run mode.
+func (cmd *Cmd) Manifest() (runtime.Object, error) {
+	switch {
+	case cmd.job != nil:
+		return cmd.job.DeepCopy(), nil
+	case cmd.pod != nil:
+		return cmd.pod.DeepCopy(), nil
+	}
+
+	if cmd.Cfg.RunAsJob {
+		job, err := newJob(cmd)
+		if err != nil {
+			return nil, err
+		}
+		job.TypeMeta = metav1.TypeMeta{APIVersion: "batch/v1", Kind: "Job"}
+		job.Namespace = cmd.Cfg.Namespace
+		return job, nil
+	}
+
+	pod, err := newPod(cmd)
+	if err != nil {
+		return nil, err
+	}
+	pod.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"}
+	pod.Namespace = cmd.Cfg.Namespace
+
+	return pod, nil
+}
+
+// PodName returns the name of the pod created for the command, or of the job
+// if the command runs as a job. It is empty until the command is started.
+func (cmd *Cmd) PodName() string {
+	switch {
+	case cmd.job != nil:
+		return cmd.job.Name
+	case cmd.pod != nil:
+		return cmd.pod.Name
+	}
+
+	return ""
+}
+
 // Wait waits for the command to exit and waits for any copying to
 // stdin or copying from stdout or stderr to complete.
 //
+// If the command exits with a non-zero code, the error is of type
+// *ExitError. Other error types may be returned for API or I/O problems.
+//
 // The command must have been started by Start.
 func (cmd *Cmd) Wait() error {
+	if cmd.done == nil {
+		return errors.New("exec: not started")
+	}
+	if cmd.finished {
+		return errors.New("exec: Wait was already called")
+	}
+	cmd.finished = true
+	defer close(cmd.done)
+
+	err := <-cmd.errc
+	closeAll(cmd.closeAfterWait)
+
+	if cmd.Cfg.Cleanup && !(cmd.Cfg.KeepFailed && err != nil) {
+		if cerr := cmd.Cleanup(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// Cleanup deletes the pod, or the job, created for the command. It is safe to
+// call Cleanup multiple times, or on a command whose pod was already deleted.
+func (cmd *Cmd) Cleanup() error {
+	if cmd.pod == nil && cmd.job == nil {
+		return nil
+	}
+
+	err := cmd.delete()
+	if err != nil && !apierrors.IsNotFound(err) {
+		cmd.log.Error("cannot delete pod", "namespace", cmd.Cfg.Namespace, "name", cmd.Cfg.Name, "error", err)
+		return fmt.Errorf("cannot delete pod: %w", err)
+	}
+	cmd.log.Debug("pod deleted", "namespace", cmd.Cfg.Namespace, "name", cmd.Cfg.Name)
+
+	return nil
+}
+
+// delete deletes the pod, or the job and its pods, created for the command
+func (cmd *Cmd) delete() error {
+	if cmd.Cfg.DryRun {
+		return nil
+	}
+
+	switch {
+	case cmd.job != nil:
+		return cmd.client.deleteJob(cmd.job, cmd.Cfg.CleanupGracePeriod)
+	case cmd.pod != nil:
+		// the context of the command may be done already
+		return cmd.deletePod(context.Background(), cmd.Cfg.CleanupGracePeriod)
+	}
+
+	return nil
+}
+
+// deletePod deletes the pod of the command, which is then not reported as
+// disrupted while it terminates
+func (cmd *Cmd) deletePod(ctx context.Context, gracePeriod *int64) error {
+	atomic.StoreInt32(&cmd.deleting, 1)
+	return cmd.exec.Delete(ctx, cmd.pod, gracePeriod)
+}
+
+// podDisrupted returns an *EvictionError if the pod of the command was evicted,
+// or is deleted by another client while the command runs
+func (cmd *Cmd) podDisrupted(pod *v1.Pod) error {
+	if err := podEvicted(pod); err != nil {
+		return err
+	}
+	if atomic.LoadInt32(&cmd.deleting) != 0 {
+		return nil
+	}
+
+	return podDeleted(pod)
+}
+
+// wait attaches to the pod and waits for the command to terminate
+func (cmd *Cmd) wait() error {
 	if cmd.Stdin == nil {
 		cmd.Stdin = ioutil.NopCloser(nil)
 	}
@@ -79,25 +1312,404 @@ func (cmd *Cmd) Wait() error {
 		cmd.Stderr = ioutil.Discard
 	}
 
-	// wait for pod to be running
-	waitPod(cmd.Cfg.Kubeconfig, cmd.pod)
+	// nothing was created to run the command
+	if cmd.Cfg.DryRun {
+		return nil
+	}
+
+	if cmd.job != nil {
+		defer cmd.executionTimer()()
+		return cmd.waitJob()
+	}
+
+	stopEvents := make(chan struct{})
+	defer close(stopEvents)
+	go cmd.watchEvents(stopEvents)
+
+	// wait for pod to be running, or to have already run when reading logs
+	cond := cmd.startCondition()
+	if cmd.Logs != LogsAttach {
+		if _, err := cmd.kubernetes("Logs"); err != nil {
+			return err
+		}
+		cond = podStarted
+	}
+	if w := cmd.Cfg.Workspace; w != nil && len(w.Inputs) > 0 {
+		if err := cmd.uploadWorkspace(); err != nil {
+			return err
+		}
+	}
+
+	initLogs := cmd.followInitLogs()
+	started, err := cmd.waitStarted(cond)
+	initLogs(err)
+	if err != nil {
+		return err
+	}
+	defer cmd.executionTimer()()
+
+	if cmd.Cfg.HealthCheck != nil && !podCompleted(started) {
+		if err := cmd.waitHealthy(); err != nil {
+			return err
+		}
+	}
+
+	switch cmd.Logs {
+	case LogsFollow:
+		err = cmd.client.streamLogs(cmd.ctx, cmd.pod, &v1.PodLogOptions{Container: cmd.Container, Follow: true}, cmd.countBytes("stdout", cmd.Stdout))
+		if err != nil {
+			if cmd.ctx.Err() != nil {
+				return cmd.ctx.Err()
+			}
+			return fmt.Errorf("cannot stream logs: %w", err)
+		}
+	case LogsBacklog:
+		err = cmd.client.streamLogs(cmd.ctx, cmd.pod, &v1.PodLogOptions{Container: cmd.Container}, cmd.countBytes("stdout", cmd.Stdout))
+		if err != nil {
+			if cmd.ctx.Err() != nil {
+				return cmd.ctx.Err()
+			}
+			return fmt.Errorf("cannot get logs: %w", err)
+		}
+
+		// nothing left to attach to
+		if podCompleted(started) {
+			break
+		}
+
+		err = cmd.attach()
+		if err != nil {
+			return err
+		}
+	default:
+		// an init container may have terminated before the pod started
+		if s := initStatus(started, cmd.Container); s != nil && s.State.Terminated != nil {
+			if _, err := cmd.kubernetes("Container"); err != nil {
+				return err
+			}
+			err = cmd.client.streamLogs(cmd.ctx, started, &v1.PodLogOptions{Container: cmd.Container}, cmd.countBytes("stdout", cmd.Stdout))
+			if err != nil {
+				if cmd.ctx.Err() != nil {
+					return cmd.ctx.Err()
+				}
+				return fmt.Errorf("cannot get logs: %w", err)
+			}
+			break
+		}
+
+		err = cmd.attach()
+		if err != nil {
+			return err
+		}
+	}
+
+	// the stream closed, wait for the container to actually terminate
+	pod, err := cmd.exec.WaitReady(cmd.ctx, cmd.pod, cmd.observe(podCompleted), cmd.podDisrupted)
+	if err != nil {
+		var evictionErr *EvictionError
+		if errors.As(err, &evictionErr) {
+			cmd.log.Warn("pod disrupted", "namespace", cmd.pod.Namespace, "pod", cmd.pod.Name, "reason", evictionErr.Reason)
+		}
+		return err
+	}
+	pod, err = cmd.followRestarts(pod)
+	if err != nil {
+		return err
+	}
+
+	if err := deadlineExceeded(pod.Status.Reason, pod.Status.Message, cmd.Cfg.ActiveDeadlineSeconds); err != nil {
+		cmd.log.Warn("pod deadline exceeded", "namespace", pod.Namespace, "pod", pod.Name)
+		return err
+	}
+	if err := cmd.podDisrupted(pod); err != nil {
+		cmd.log.Warn("pod disrupted", "namespace", pod.Namespace, "pod", pod.Name, "reason", err.(*EvictionError).Reason)
+		return err
+	}
+
+	state := terminatedState(pod)
+
+	var outputErr error
+	if w := cmd.Cfg.Workspace; w != nil && len(w.Outputs) > 0 {
+		if err := cmd.downloadWorkspace(); err != nil {
+			outputErr = fmt.Errorf("cannot copy workspace outputs: %w", err)
+		}
+	}
+	if len(cmd.Cfg.Artifacts) > 0 {
+		if err := cmd.collectArtifacts(); err != nil && outputErr == nil {
+			outputErr = fmt.Errorf("cannot collect artifacts: %w", err)
+		}
+	}
+	if cmd.Cfg.CaptureEnvironment {
+		if err := cmd.collectEnvironment(); err != nil && outputErr == nil {
+			outputErr = fmt.Errorf("cannot capture environment: %w", err)
+		}
+	}
+
+	if cmd.hasSidecars() && !(cmd.Cfg.KeepFailed && (state == nil || state.ExitCode != 0)) {
+		cmd.stopSidecars()
+	}
+	if state != nil {
+		cmd.ProcessState = newProcessState(commandStatus(pod), state)
+		cmd.ProcessState.Attempts = cmd.attempt
+		cmd.ProcessState.Placement = newPlacement(pod)
+		cmd.ProcessState.InitContainers = initResults(pod)
+		cmd.ProcessState.Environment = cmd.environment
+		if cmd.Cfg.Metrics != nil {
+			cmd.Cfg.Metrics.CommandCompleted(pod.Namespace, time.Since(cmd.startTime), int(state.ExitCode))
+		}
+		cmd.log.Info("command completed", "namespace", pod.Namespace, "pod", pod.Name, "exitCode", state.ExitCode, "reason", state.Reason)
+	}
+	if state != nil && state.ExitCode != 0 {
+		return &ExitError{
+			Code:    int(state.ExitCode),
+			Reason:  state.Reason,
+			Message: state.Message,
+		}
+	}
+	if outputErr != nil {
+		return outputErr
+	}
+
+	return nil
+}
+
+// stopSidecars deletes the pod once the command terminated, as its sidecars
+// keep it running
+func (cmd *Cmd) stopSidecars() {
+	err := cmd.deletePod(cmd.ctx, cmd.Cfg.CleanupGracePeriod)
+	if err != nil && !apierrors.IsNotFound(err) {
+		cmd.log.Warn("cannot delete pod to stop sidecars", "namespace", cmd.pod.Namespace, "pod", cmd.pod.Name, "error", err)
+		return
+	}
+	cmd.log.Debug("pod deleted to stop sidecars", "namespace", cmd.pod.Namespace, "pod", cmd.pod.Name)
+}
+
+// waitStarted waits for the pod to satisfy the given condition, within the
+// start timeout of the config, and fails early if the pod cannot start
+func (cmd *Cmd) waitStarted(cond func(*v1.Pod) bool) (_ *v1.Pod, err error) {
+	endSpan := cmd.startSpan("kube-exec.schedule")
+	defer func() { endSpan(err) }()
+
+	ctx := cmd.ctx
+	if cmd.Cfg.StartTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cmd.Cfg.StartTimeout)
+		defer cancel()
+	}
+
+	var pod *v1.Pod
+	if cmd.Cfg.TimeToSchedule > 0 || cmd.Cfg.TimeToStart > 0 {
+		pod, err = cmd.waitStage(ctx, TimeoutSchedule, cmd.Cfg.TimeToSchedule, podScheduled)
+	}
+	if err == nil {
+		pod, err = cmd.waitStage(ctx, TimeoutStart, cmd.Cfg.TimeToStart, cond)
+	}
+	if err == nil {
+		cmd.log.Debug("pod started", "namespace", pod.Namespace, "pod", pod.Name, "phase", pod.Status.Phase, "node", pod.Spec.NodeName)
+		cmd.setPlacement(pod)
+		if cmd.Cfg.Metrics != nil {
+			cmd.Cfg.Metrics.PodRunning(pod.Namespace, time.Since(cmd.startTime))
+		}
+		return pod, nil
+	}
+
+	// the command context is done, not the start timeout
+	if cmd.ctx.Err() != nil {
+		return nil, cmd.ctx.Err()
+	}
+	if err == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %v", cmd.Cfg.StartTimeout)
+	}
+	if cmd.client != nil {
+		if event := cmd.client.lastWarning(pod); event != "" {
+			err = fmt.Errorf("%w (last event: %s)", err, event)
+		}
+	}
+
+	cmd.log.Warn("pod did not start", "namespace", cmd.pod.Namespace, "pod", cmd.pod.Name, "error", err)
+	return nil, &Error{Kind: ErrPodStart, Err: err}
+}
+
+// startCondition returns the condition of the pod to wait for before using its
+// container, as selected by WaitFor
+func (cmd *Cmd) startCondition() func(*v1.Pod) bool {
+	if cmd.pod != nil && isInitContainer(cmd.pod, cmd.Container) {
+		return initContainerStarted(cmd.Container)
+	}
+
+	switch cmd.Cfg.WaitFor {
+	case WaitStarted:
+		return containerRunning(cmd.Container)
+	case WaitReady:
+		return containerReady(cmd.Container)
+	}
+
+	return podRunning
+}
+
+// observe returns the given pod condition, reporting every pod it is checked
+// against to OnPodUpdate and to the hooks
+func (cmd *Cmd) observe(cond func(*v1.Pod) bool) func(*v1.Pod) bool {
+	if cmd.OnPodUpdate == nil && cmd.hooks == nil {
+		return cond
+	}
+
+	return func(pod *v1.Pod) bool {
+		if cmd.OnPodUpdate != nil {
+			cmd.OnPodUpdate(pod)
+		}
+		if cmd.hooks != nil {
+			cmd.hooks.update(pod)
+		}
+		return cond(pod)
+	}
+}
+
+// attach attaches to the pod and streams stdin, stdout and stderr until the
+// stream closes
+func (cmd *Cmd) attach() (err error) {
+	var sizeQueue remotecommand.TerminalSizeQueue
+	var termSize *remotecommand.TerminalSize
+	if cmd.TTY {
+		t, err := setupTerminal(cmd.Stdin, cmd.Stdout)
+		if err != nil {
+			return fmt.Errorf("cannot set up terminal: %v", err)
+		}
+		if t != nil {
+			defer t.restore()
+			termSize = t.size()
+
+			stop := make(chan struct{})
+			defer close(stop)
+			sizeQueue = t.sizeQueue(stop)
+		}
+	}
+
+	req := StreamRequest{
+		Pod:         cmd.pod,
+		Container:   cmd.Container,
+		Subresource: "attach",
+		TTY:         cmd.TTY,
+		SizeQueue:   sizeQueue,
+		Keepalive:   cmd.Keepalive,
+	}
+	if cmd.Stdin != ioutil.NopCloser(nil) {
+		req.Stdin = cmd.Stdin
+		if cmd.NoEcho && !cmd.TTY {
+			req.Stdin = noEcho(cmd.Stdin)
+		}
+	}
 
-	attachOptions := &v1.PodAttachOptions{
-		Stdin:  cmd.Stdin != ioutil.NopCloser(nil),
-		Stdout: cmd.Stdout != ioutil.Discard,
+	// the streams are copied from different goroutines, so a writer shared by
+	// both is locked
+	stdout, stderr := cmd.Stdout, cmd.Stderr
+	if !cmd.TTY && (cmd.MergeStderr || sameWriter(stdout, stderr)) {
+		stdout = &lockedWriter{w: stdout}
+		stderr = stdout
+	}
+	var decompress *decompressWriter
+	if cmd.Compression != nil {
+		decompress = newDecompressWriter(stdout, cmd.Compression)
+		stdout = decompress
+	}
+	if cmd.Cfg.StreamIdleTimeout > 0 || cmd.Cfg.FlushTimeout > 0 {
+		atomic.StoreInt64(&cmd.lastOutput, time.Now().UnixNano())
+		stdout = &activityWriter{w: stdout, last: &cmd.lastOutput}
+		stderr = &activityWriter{w: stderr, last: &cmd.lastOutput}
+	}
+	if cmd.Cfg.StreamIdleTimeout > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		go cmd.watchIdle(&cmd.lastOutput, stop)
+	}
+	stdout = cmd.countBytes("stdout", stdout)
+	if cmd.Stdout != ioutil.Discard {
+		req.Stdout = stdout
+	}
+
+	// For k8s 1.9 - see https://github.com/kubernetes/kubernetes/pull/52686
+	// stderr is requested even if discarded, unless merged into stdout by a TTY
+	if !cmd.TTY {
+		req.Stderr = cmd.countBytes("stderr", stderr)
+	}
+	if cmd.SessionRecording != nil {
+		rec := newSessionRecorder(cmd.SessionRecording, termSize, ShellQuote(append([]string{cmd.Path}, cmd.Args...)...))
+		rec.wrap(&req, cmd.RecordInput)
+	}
 
-		// For k8s 1.9 - see https://github.com/kubernetes/kubernetes/pull/52686
-		//Stderr: cmd.Stderr != ioutil.Discard,
+	endSpan := cmd.startSpan("kube-exec.attach")
+	defer func() { endSpan(err) }()
 
-		Stderr: true,
-		TTY:    false,
+	if decompress != nil {
+		defer func() {
+			if cerr := decompress.Close(); cerr != nil && err == nil {
+				err = fmt.Errorf("cannot decompress output: %w", cerr)
+			}
+		}()
+	}
+
+	cmd.log.Debug("attach started", "namespace", cmd.pod.Namespace, "pod", cmd.pod.Name, "tty", cmd.TTY)
+	err = cmd.exec.Stream(cmd.ctx, req)
+
+	backoff := reconnectBackoff
+	disconnected := metav1.Now()
+	reconnects := cmd.MaxReconnects
+	if reconnects == 0 {
+		reconnects = maxReconnects
+	}
+	// resuming the output needs the logs of the pod, and cannot be done in
+	// the middle of compressed output
+	if cmd.client == nil || cmd.Compression != nil {
+		reconnects = 0
+	}
+	for attempt := 0; err != nil && cmd.ctx.Err() == nil && !cmd.DisableReconnect && attempt < reconnects && atomic.LoadInt32(&cmd.killed) == 0; attempt++ {
+		cmd.log.Warn("stream broken, reconnecting", "namespace", cmd.pod.Namespace, "pod", cmd.pod.Name, "attempt", attempt+1, "backoff", backoff, "error", err)
+
+		select {
+		case <-time.After(backoff):
+		case <-cmd.ctx.Done():
+			return cmd.ctx.Err()
+		}
+		backoff *= 2
+
+		pod, gerr := cmd.client.refreshPod(cmd.pod)
+		if apierrors.IsNotFound(gerr) && atomic.LoadInt32(&cmd.deleting) == 0 {
+			return &EvictionError{Reason: reasonDeleted, Message: "pod deleted while the command ran"}
+		}
+		if gerr != nil {
+			continue
+		}
+		if derr := cmd.podDisrupted(pod); derr != nil {
+			return derr
+		}
+
+		// resume the output produced while disconnected from the logs, which
+		// have a precision of a second, so some output may be repeated
+		lerr := cmd.client.streamLogs(cmd.ctx, pod, &v1.PodLogOptions{Container: cmd.Container, SinceTime: &disconnected}, stdout)
+		if lerr != nil {
+			continue
+		}
+
+		if podCompleted(pod) {
+			return nil
+		}
+
+		err = cmd.exec.Stream(cmd.ctx, req)
+		disconnected = metav1.Now()
 	}
 
-	err := attach(cmd.Cfg.Kubeconfig, cmd.pod, attachOptions, cmd.Stdin, cmd.Stdout, cmd.Stderr)
 	if err != nil {
-		return fmt.Errorf("cannot attach: %v", err)
+		if cmd.ctx.Err() != nil {
+			return cmd.ctx.Err()
+		}
+		cmd.log.Error("attach failed", "namespace", cmd.pod.Namespace, "pod", cmd.pod.Name, "error", err)
+		if cmd.Cfg.Metrics != nil {
+			cmd.Cfg.Metrics.AttachFailed(cmd.pod.Namespace)
+		}
+		return &Error{Kind: ErrStreamLost, Err: err}
 	}
 
+	cmd.log.Debug("stream closed", "namespace", cmd.pod.Namespace, "pod", cmd.pod.Name)
 	return nil
 }
 
@@ -105,19 +1717,178 @@ func (cmd *Cmd) Wait() error {
 func (cmd *Cmd) Run() error {
 	err := cmd.Start()
 	if err != nil {
-		return fmt.Errorf("cannot start command: %v", err)
+		return fmt.Errorf("cannot start command: %w", err)
 	}
 
 	return cmd.Wait()
 }
 
+// Output runs the command and returns its standard output.
+// If the command exits with a non-zero code and Stderr was not set, the
+// standard error of the command is saved in the Stderr field of the *ExitError.
+func (cmd *Cmd) Output() ([]byte, error) {
+	if cmd.Stdout != nil {
+		return nil, errors.New("exec: Stdout already set")
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+
+	captureErr := cmd.Stderr == nil
+	if captureErr {
+		cmd.Stderr = &stderr
+	}
+
+	err := cmd.Run()
+	if exitErr, ok := err.(*ExitError); ok && captureErr {
+		exitErr.Stderr = stderr.Bytes()
+	}
+
+	return stdout.Bytes(), err
+}
+
+// CombinedOutput runs the command and returns its combined standard output
+// and standard error.
+func (cmd *Cmd) CombinedOutput() ([]byte, error) {
+	if cmd.Stdout != nil {
+		return nil, errors.New("exec: Stdout already set")
+	}
+	if cmd.Stderr != nil {
+		return nil, errors.New("exec: Stderr already set")
+	}
+
+	// the same writer is locked by attach
+	var b bytes.Buffer
+	cmd.Stdout = &b
+	cmd.Stderr = &b
+
+	err := cmd.Run()
+	return b.Bytes(), err
+}
+
 // StdinPipe returns a pipe that will be connected to the command's standard input
-// when the command starts.
+// when the command starts. The pipe is closed after the stream ends, and by Wait.
 //
-// Different than os/exec.StdinPipe, returned io.WriteCloser should be closed by user.
+// The returned io.WriteCloser should be closed by the caller to send EOF to the
+// command.
 func (cmd *Cmd) StdinPipe() (io.WriteCloser, error) {
+	if cmd.Stdin != nil {
+		return nil, errors.New("exec: Stdin already set")
+	}
+	if cmd.done != nil {
+		return nil, errors.New("exec: StdinPipe after command started")
+	}
 
 	pr, pw := io.Pipe()
 	cmd.Stdin = pr
+	cmd.closeAfterStream = append(cmd.closeAfterStream, pr)
+	cmd.closeAfterWait = append(cmd.closeAfterWait, pw)
+
 	return pw, nil
 }
+
+// StdoutPipe returns a pipe that will be connected to the command's standard
+// output when the command starts. The pipe returns EOF after the stream ends,
+// and is closed by Wait, so all reads from it must complete before calling Wait.
+func (cmd *Cmd) StdoutPipe() (io.ReadCloser, error) {
+	if cmd.Stdout != nil {
+		return nil, errors.New("exec: Stdout already set")
+	}
+	if cmd.done != nil {
+		return nil, errors.New("exec: StdoutPipe after command started")
+	}
+
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.closeAfterStream = append(cmd.closeAfterStream, pw)
+	cmd.closeAfterWait = append(cmd.closeAfterWait, pr)
+
+	return pr, nil
+}
+
+// StderrPipe returns a pipe that will be connected to the command's standard
+// error when the command starts. The pipe returns EOF after the stream ends,
+// and is closed by Wait, so all reads from it must complete before calling Wait.
+//
+// With a TTY, stderr is merged into stdout and nothing is written to the pipe.
+func (cmd *Cmd) StderrPipe() (io.ReadCloser, error) {
+	if cmd.Stderr != nil {
+		return nil, errors.New("exec: Stderr already set")
+	}
+	if cmd.done != nil {
+		return nil, errors.New("exec: StderrPipe after command started")
+	}
+
+	pr, pw := io.Pipe()
+	cmd.Stderr = pw
+	cmd.closeAfterStream = append(cmd.closeAfterStream, pw)
+	cmd.closeAfterWait = append(cmd.closeAfterWait, pr)
+
+	return pr, nil
+}
+
+// sameWriter reports whether a and b are the same writer, and false if their
+// type cannot be compared
+func sameWriter(a, b io.Writer) (same bool) {
+	defer func() {
+		if recover() != nil {
+			same = false
+		}
+	}()
+
+	return a == b
+}
+
+// closeAll closes all the given closers, ignoring errors
+func closeAll(closers []io.Closer) {
+	for _, c := range closers {
+		c.Close()
+	}
+}
+
+// ShellQuote returns the arguments as a single string to pass to a POSIX shell,
+// each quoted so it is passed as a single word and without expansions.
+func ShellQuote(args ...string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = shellQuote(a)
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// shellQuote quotes a single argument, unless it only contains safe characters
+func shellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+
+	safe := true
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("_-./=:,+@%", r)) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return s
+	}
+
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// envVars converts "key=value" pairs to Kubernetes API env vars.
+// Entries without a "=" are passed with an empty value.
+func envVars(env []string) []v1.EnvVar {
+	vars := []v1.EnvVar{}
+	for _, e := range env {
+		kv := strings.SplitN(e, "=", 2)
+		v := v1.EnvVar{Name: kv[0]}
+		if len(kv) == 2 {
+			v.Value = kv[1]
+		}
+		vars = append(vars, v)
+	}
+
+	return vars
+}
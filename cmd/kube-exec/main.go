@@ -0,0 +1,292 @@
+// Command kube-exec runs a command in a new Kubernetes pod, streaming its
+// input and output, and exits with the exit code of the command:
+//
+//	kube-exec run --image busybox -- sh -c 'echo hi'
+//
+// It also prints the Role and RoleBinding granting the permissions needed to
+// run commands with the same flags:
+//
+//	kube-exec rbac --namespace ci --service-account runner | kubectl apply -f -
+//
+// Interactive sessions recorded with --record can be replayed:
+//
+//	kube-exec run --image busybox --tty --record session.cast -- sh
+//	kube-exec replay session.cast
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	kube "github.com/engineerd/kube-exec"
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+const usage = `Usage: kube-exec run [flags] -- COMMAND [ARG...]
+       kube-exec rbac [flags] [-- COMMAND [ARG...]]
+       kube-exec replay [--speed N] [--max-idle D] FILE
+
+Runs a command in a new pod, streaming its input and output, prints the
+Role and RoleBinding granting the permissions needed to run it, or replays a
+session recorded with --record.
+
+Flags:
+`
+
+// stringsFlag is a flag that can be repeated
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		newRunFlags("rbac").PrintDefaults()
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "run":
+		os.Exit(run(os.Args[2:]))
+	case "rbac":
+		os.Exit(rbac(os.Args[2:]))
+	case "replay":
+		os.Exit(replay(os.Args[2:]))
+	}
+
+	fmt.Fprint(os.Stderr, usage)
+	newRunFlags("rbac").PrintDefaults()
+	os.Exit(2)
+}
+
+// runFlags are the flags of the run command
+type runFlags struct {
+	*flag.FlagSet
+
+	kubeconfig string
+	context    string
+	namespace  string
+	name       string
+	image      string
+	env        stringsFlag
+	secrets    stringsFlag
+	stdin      bool
+	tty        bool
+	cleanup    bool
+	timeout    time.Duration
+	record     string
+
+	// role and serviceAccount are the flags of the rbac command
+	role           string
+	serviceAccount string
+}
+
+func newRunFlags(name string) *runFlags {
+	f := &runFlags{FlagSet: flag.NewFlagSet(name, flag.ContinueOnError)}
+	f.StringVar(&f.kubeconfig, "kubeconfig", "", "path of the kubeconfig, KUBECONFIG if empty")
+	f.StringVar(&f.context, "context", "", "context of the kubeconfig to use")
+	f.StringVar(&f.namespace, "namespace", "", "namespace of the pod, the namespace of the context if empty")
+	f.StringVar(&f.name, "name", "", "name of the pod, generated if empty")
+	f.StringVar(&f.image, "image", "", "image of the pod (required)")
+	f.Var(&f.env, "env", "environment variable KEY=VALUE of the command (repeatable)")
+	f.Var(&f.secrets, "secret", "environment variable KEY=SECRET/KEY of the command, from a secret (repeatable)")
+	f.BoolVar(&f.stdin, "stdin", false, "pass the standard input to the command")
+	f.BoolVar(&f.tty, "tty", false, "allocate a terminal for the command")
+	f.BoolVar(&f.cleanup, "cleanup", true, "delete the pod once the command completes")
+	f.DurationVar(&f.timeout, "timeout", 0, "time after which the command is stopped and its pod deleted, none if zero")
+	f.StringVar(&f.record, "record", "", "file the session is recorded to, in the asciicast v2 format")
+	if name == "rbac" {
+		f.StringVar(&f.role, "role", "kube-exec", "name of the role and of the role binding (rbac)")
+		f.StringVar(&f.serviceAccount, "service-account", "", "service account bound to the role, in the namespace (rbac)")
+	}
+
+	return f
+}
+
+// config returns the config of the command given by the flags
+func (f *runFlags) config() (kube.Config, error) {
+	cfg := kube.Config{
+		Kubeconfig: f.kubeconfig,
+		Context:    f.context,
+		Namespace:  f.namespace,
+		Name:       f.name,
+		Image:      f.image,
+		Cleanup:    f.cleanup,
+	}
+	if cfg.Name == "" {
+		cfg.GenerateName = "kube-exec-"
+	}
+	for _, s := range f.secrets {
+		secret, err := parseSecret(s)
+		if err != nil {
+			return kube.Config{}, err
+		}
+		cfg.Secrets = append(cfg.Secrets, secret)
+	}
+
+	return cfg, nil
+}
+
+// run runs the command given by the arguments, and returns the exit code of
+// the tool
+func run(args []string) int {
+	f := newRunFlags("run")
+	f.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		f.PrintDefaults()
+	}
+	if err := f.Parse(args); err != nil {
+		return 2
+	}
+	if f.image == "" || f.NArg() == 0 {
+		f.Usage()
+		return 2
+	}
+
+	cfg, err := f.config()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kube-exec: %v\n", err)
+		return 2
+	}
+
+	ctx := context.Background()
+	if f.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.timeout)
+		defer cancel()
+	}
+
+	cmd := kube.CommandContext(ctx, cfg, f.Arg(0), f.Args()[1:]...)
+	cmd.Env = f.env
+	cmd.TTY = f.tty
+	cmd.ForwardSignals = !f.tty
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if f.stdin || f.tty {
+		cmd.Stdin = os.Stdin
+	}
+	if f.record != "" {
+		out, err := os.Create(f.record)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "kube-exec: %v\n", err)
+			return 1
+		}
+		defer out.Close()
+		cmd.SessionRecording = out
+		cmd.RecordInput = true
+	}
+
+	err = cmd.Run()
+
+	var exitErr *kube.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr):
+		return exitErr.Code
+	case errors.Is(err, context.DeadlineExceeded):
+		fmt.Fprintf(os.Stderr, "kube-exec: timed out after %v\n", f.timeout)
+	default:
+		fmt.Fprintf(os.Stderr, "kube-exec: %v\n", err)
+	}
+
+	return 1
+}
+
+// rbac prints the Role and RoleBinding granting the permissions needed to run
+// the command given by the arguments, and returns the exit code of the tool
+func rbac(args []string) int {
+	f := newRunFlags("rbac")
+	f.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		f.PrintDefaults()
+	}
+	if err := f.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := f.config()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kube-exec: %v\n", err)
+		return 2
+	}
+
+	var subjects []rbacv1.Subject
+	if f.serviceAccount != "" {
+		subjects = append(subjects, rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: f.serviceAccount, Namespace: f.namespace})
+	}
+
+	// the permissions do not depend on the command itself
+	manifest, err := kube.Command(cfg, f.Arg(0)).RBACManifest(f.role, subjects...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kube-exec: %v\n", err)
+		return 1
+	}
+	os.Stdout.Write(manifest)
+
+	return 0
+}
+
+// replay plays the session recorded in the file given by the arguments, and
+// returns the exit code of the tool
+func replay(args []string) int {
+	f := flag.NewFlagSet("replay", flag.ContinueOnError)
+	speed := f.Float64("speed", 1, "speed of the replay")
+	maxIdle := f.Duration("max-idle", 0, "longest pause of the replay, none if zero")
+	f.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		f.PrintDefaults()
+	}
+	if err := f.Parse(args); err != nil {
+		return 2
+	}
+	if f.NArg() != 1 {
+		f.Usage()
+		return 2
+	}
+
+	in, err := os.Open(f.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kube-exec: %v\n", err)
+		return 1
+	}
+	defer in.Close()
+
+	rec, err := kube.ReadRecording(in)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kube-exec: %v\n", err)
+		return 1
+	}
+	if err := rec.Play(context.Background(), os.Stdout, *speed, *maxIdle); err != nil {
+		fmt.Fprintf(os.Stderr, "kube-exec: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+// parseSecret parses a secret flag of the form KEY=SECRET/KEY
+func parseSecret(s string) (kube.Secret, error) {
+	env := strings.SplitN(s, "=", 2)
+	if len(env) != 2 {
+		return kube.Secret{}, fmt.Errorf("invalid secret %q, expected KEY=SECRET/KEY", s)
+	}
+	ref := strings.SplitN(env[1], "/", 2)
+	if len(ref) != 2 {
+		return kube.Secret{}, fmt.Errorf("invalid secret %q, expected KEY=SECRET/KEY", s)
+	}
+
+	return kube.Secret{EnvVarName: env[0], SecretName: ref[0], SecretKey: ref[1]}, nil
+}
@@ -0,0 +1,119 @@
+package exec
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// Compression compresses the standard output of a command in its container,
+// and decompresses it locally, for commands writing a lot of output. The
+// command runs through /bin/sh in the container, which pipes its output to the
+// compression command, and still exits with the exit code of the command.
+//
+// Compression cannot be used with a TTY, nor when reading the Logs, and the
+// stream is not resumed if it breaks.
+type Compression struct {
+	// Command compresses its standard input to its standard output in the
+	// container, such as []string{"gzip", "-c"}. It must be in the image.
+	Command []string
+
+	// NewReader returns a reader decompressing r.
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+
+	// Threshold, if not zero, is the number of bytes of output sent before
+	// compressing the rest, so short outputs are not compressed at all. The
+	// bytes below the threshold are copied one at a time by dd, which must
+	// be in the image, so it should stay small, such as 64KiB.
+	Threshold int
+}
+
+// Gzip returns a compression with gzip, which must be in the image.
+func Gzip() *Compression {
+	return &Compression{
+		Command: []string{"gzip", "-c"},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	}
+}
+
+// wrap returns the command and arguments of the container running the given
+// command with its output compressed. The exit code of the command is passed
+// on fd 4, and the output written on fd 3, around the pipe.
+func (c *Compression) wrap(command, args []string) ([]string, []string) {
+	run := ShellQuote(append(append([]string{}, command...), args...)...)
+	compress := ShellQuote(c.Command...)
+	if c.Threshold > 0 {
+		// compress only once the threshold is reached, as counted by dd
+		compress = fmt.Sprintf(`n=$(dd bs=1 count=%d 2>&1 >&3 | head -n 1 | cut -d+ -f1); if [ "$n" = %d ]; then %s; fi`,
+			c.Threshold, c.Threshold, compress)
+	}
+
+	script := fmt.Sprintf(`exec 3>&1; rc=$( { { %s; echo $? >&4; } | { %s; } >&3; } 4>&1 ); exit $rc`, run, compress)
+	return []string{"/bin/sh", "-c"}, []string{script}
+}
+
+// decompressWriter writes the output of a command with compression to w: the
+// bytes below the threshold as they are, and the rest through the decompressor
+type decompressWriter struct {
+	w         io.Writer
+	c         *Compression
+	remaining int
+
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func newDecompressWriter(w io.Writer, c *Compression) *decompressWriter {
+	return &decompressWriter{w: w, c: c, remaining: c.Threshold}
+}
+
+func (d *decompressWriter) Write(p []byte) (int, error) {
+	n := 0
+	if d.remaining > 0 {
+		raw := p
+		if len(raw) > d.remaining {
+			raw = raw[:d.remaining]
+		}
+		written, err := d.w.Write(raw)
+		n += written
+		d.remaining -= written
+		if err != nil {
+			return n, err
+		}
+		p = p[written:]
+	}
+	if len(p) == 0 {
+		return n, nil
+	}
+
+	// the decompressor starts with the first compressed byte
+	if d.pw == nil {
+		pr, pw := io.Pipe()
+		d.pw = pw
+		d.done = make(chan error, 1)
+		go func() {
+			r, err := d.c.NewReader(pr)
+			if err == nil {
+				_, err = io.Copy(d.w, r)
+				r.Close()
+			}
+			pr.CloseWithError(err)
+			d.done <- err
+		}()
+	}
+
+	written, err := d.pw.Write(p)
+	return n + written, err
+}
+
+// Close ends the compressed output, and waits for it to be decompressed
+func (d *decompressWriter) Close() error {
+	if d.pw == nil {
+		return nil
+	}
+
+	d.pw.Close()
+	return <-d.done
+}
@@ -0,0 +1,24 @@
+// Package compression provides the compressions of kube-exec command outputs
+// that need a decompressor outside of the standard library.
+package compression
+
+import (
+	"io"
+
+	exec "github.com/engineerd/kube-exec"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Zstd returns a compression with zstd, which must be in the image.
+func Zstd() *exec.Compression {
+	return &exec.Compression{
+		Command: []string{"zstd", "-c", "-q"},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		},
+	}
+}
@@ -0,0 +1,69 @@
+package exec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+)
+
+func TestDecompressWriter(t *testing.T) {
+	output := []byte(strings.Repeat("0123456789", 100))
+
+	tests := []struct {
+		name      string
+		threshold int
+		chunk     int
+	}{
+		{name: "no threshold", threshold: 0, chunk: 64},
+		{name: "threshold in a write", threshold: 100, chunk: 64},
+		{name: "threshold between writes", threshold: 128, chunk: 64},
+		{name: "one byte writes", threshold: 10, chunk: 1},
+		{name: "output below the threshold", threshold: 2000, chunk: 64},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Gzip()
+			c.Threshold = tt.threshold
+
+			// the container sends the bytes below the threshold as they are
+			raw := output
+			if len(raw) > tt.threshold {
+				raw = output[:tt.threshold]
+			}
+			sent := append([]byte{}, raw...)
+			if len(raw) < len(output) {
+				var compressed bytes.Buffer
+				zw := gzip.NewWriter(&compressed)
+				zw.Write(output[len(raw):])
+				zw.Close()
+				sent = append(sent, compressed.Bytes()...)
+			}
+
+			var out bytes.Buffer
+			d := newDecompressWriter(&out, c)
+			for len(sent) > 0 {
+				n := tt.chunk
+				if n > len(sent) {
+					n = len(sent)
+				}
+				written, err := d.Write(sent[:n])
+				if err != nil {
+					t.Fatalf("Write() error = %v", err)
+				}
+				if written != n {
+					t.Fatalf("Write() = %d, want %d", written, n)
+				}
+				sent = sent[n:]
+			}
+			if err := d.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			if !bytes.Equal(out.Bytes(), output) {
+				t.Errorf("output = %q, want %q", out.Bytes(), output)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package exec
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// CopyTo copies a local file or directory into a container of an already running
+// pod, like kubectl cp. If container is empty, the first container of the pod
+// is used. Directories are copied recursively, keeping permissions and symlinks.
+//
+// The tar binary must be available in the container. The Stdin and Stdout of
+// the options are not used.
+func CopyTo(ctx context.Context, namespace, pod, container, localPath, remotePath string, opts ExecOptions) error {
+	if _, err := os.Lstat(localPath); err != nil {
+		return err
+	}
+
+	remotePath = path.Clean(remotePath)
+	pr, pw := io.Pipe()
+
+	go func() {
+		pw.CloseWithError(writeTar(pw, localPath, path.Base(remotePath)))
+	}()
+	defer pr.Close()
+
+	opts.Stdin = pr
+	opts.Stdout = nil
+	opts.TTY = false
+
+	err := ExecInPod(ctx, namespace, pod, container, []string{"tar", "-xmf", "-", "-C", path.Dir(remotePath)}, opts)
+	if err != nil {
+		return fmt.Errorf("cannot copy to %s: %w", remotePath, err)
+	}
+
+	return nil
+}
+
+// CopyFrom copies a file or directory from a container of an already running pod
+// to a local path, like kubectl cp. If container is empty, the first container
+// of the pod is used. Directories are copied recursively, keeping permissions
+// and symlinks, except for symlinks pointing outside of localPath.
+//
+// The tar binary must be available in the container. The Stdin and Stdout of
+// the options are not used.
+func CopyFrom(ctx context.Context, namespace, pod, container, remotePath, localPath string, opts ExecOptions) error {
+	remotePath = path.Clean(remotePath)
+	pr, pw := io.Pipe()
+
+	errc := make(chan error, 1)
+	go func() {
+		err := readTar(pr, path.Base(remotePath), localPath)
+
+		// drain whatever is left so the stream is not blocked
+		io.Copy(ioutil.Discard, pr)
+		errc <- err
+	}()
+
+	opts.Stdin = nil
+	opts.Stdout = pw
+	opts.TTY = false
+
+	err := ExecInPod(ctx, namespace, pod, container, []string{"tar", "-cf", "-", "-C", path.Dir(remotePath), path.Base(remotePath)}, opts)
+	pw.Close()
+	terr := <-errc
+
+	if err != nil {
+		return fmt.Errorf("cannot copy from %s: %w", remotePath, err)
+	}
+	if terr != nil {
+		return fmt.Errorf("cannot copy from %s: %v", remotePath, terr)
+	}
+
+	return nil
+}
+
+// writeTar writes the local file or directory src to w as a tar archive, with
+// its entries named after name
+func writeTar(w io.Writer, src, name string) error {
+	tw := tar.NewWriter(w)
+
+	if err := addTar(tw, src, name); err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// addTar adds the local file or directory src to the tar archive, with its
+// entries named after name
+func addTar(tw *tar.Writer, src, name string) error {
+	return filepath.Walk(src, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err = os.Readlink(file)
+			if err != nil {
+				return err
+			}
+		}
+
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = path.Join(name, filepath.ToSlash(rel))
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+		return err
+	})
+}
+
+// readTar extracts the tar archive read from r to dst, mapping the entries
+// named after name to dst itself
+func readTar(r io.Reader, name, dst string) error {
+	tr := tar.NewReader(r)
+	dst = filepath.Clean(dst)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		rel := strings.TrimPrefix(path.Clean(hdr.Name), name)
+		target := filepath.Join(dst, filepath.FromSlash(rel))
+		if !within(dst, target) {
+			return fmt.Errorf("invalid entry in archive: %s", hdr.Name)
+		}
+
+		mode := os.FileMode(hdr.Mode).Perm()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, mode); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeFile(target, mode, tr); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			// do not create links to files outside of the destination
+			link := hdr.Linkname
+			if filepath.IsAbs(link) || !within(dst, filepath.Join(filepath.Dir(target), link)) {
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// writeFile creates the file at path with the given mode and the content read from r
+func writeFile(path string, mode os.FileMode, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// within reports whether path is dir or is inside of dir
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,210 @@
+package exec
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+)
+
+// DebugOptions contains the options for debugging a running pod with an
+// ephemeral container
+type DebugOptions struct {
+	Kubeconfig string
+
+	// Client is the client used to reach the cluster. If nil, the shared
+	// client for Kubeconfig is used.
+	Client *Client
+
+	// Logger receives the events of the session. If nil, events are discarded.
+	Logger Logger
+
+	// Name is the name of the ephemeral container. If empty, a name is generated.
+	Name  string
+	Image string
+
+	// Command is the command to run in the ephemeral container. If empty, the
+	// entrypoint of the image is used.
+	Command []string
+
+	// TargetContainer is the container of the pod whose process namespace is
+	// shared with the ephemeral container, if any.
+	TargetContainer string
+
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	TTY bool
+}
+
+// DebugPod adds an ephemeral container to an already running pod, like kubectl
+// debug, and attaches to it. The pod is not restarted, and the ephemeral
+// container cannot be removed once added.
+//
+// Ephemeral containers are not part of the API types this package is built
+// with, so they are added with a raw patch, and require a cluster where the
+// ephemeralcontainers subresource of pods is available.
+func DebugPod(ctx context.Context, namespace, pod string, opts DebugOptions) error {
+	if opts.Image == "" {
+		return fmt.Errorf("no image for the ephemeral container")
+	}
+
+	client, err := getClient(opts.Client, kubeconfigKey{path: opts.Kubeconfig}, loggerOrNop(opts.Logger))
+	if err != nil {
+		return err
+	}
+
+	if namespace == "" {
+		namespace = client.namespace
+	}
+
+	p, err := client.getPod(namespace, pod)
+	if err != nil {
+		return fmt.Errorf("cannot get pod: %w", err)
+	}
+
+	if p.Status.Phase != v1.PodRunning {
+		return fmt.Errorf("pod %s/%s is not running (%s)", namespace, pod, p.Status.Phase)
+	}
+
+	name := opts.Name
+	if name == "" {
+		name = "debugger-" + utilrand.String(5)
+	}
+
+	container := map[string]interface{}{
+		"name":                     name,
+		"image":                    opts.Image,
+		"stdin":                    true,
+		"tty":                      opts.TTY,
+		"terminationMessagePolicy": v1.TerminationMessageReadFile,
+		"imagePullPolicy":          v1.PullIfNotPresent,
+	}
+	if len(opts.Command) > 0 {
+		container["command"] = opts.Command
+	}
+	if opts.TargetContainer != "" {
+		container["targetContainerName"] = opts.TargetContainer
+	}
+
+	err = client.addEphemeralContainer(ctx, p, container)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return fmt.Errorf("cannot add ephemeral container: not supported by the cluster: %w", err)
+		}
+		return fmt.Errorf("cannot add ephemeral container: %w", err)
+	}
+
+	err = client.waitEphemeralContainer(ctx, p, name)
+	if err != nil {
+		return err
+	}
+
+	stdin, stdout, stderr := opts.Stdin, opts.Stdout, opts.Stderr
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+	if stderr == nil {
+		stderr = ioutil.Discard
+	}
+
+	attachOptions := &v1.PodAttachOptions{
+		Container: name,
+		Stdin:     stdin != nil,
+		Stdout:    opts.Stdout != nil,
+		Stderr:    opts.Stderr != nil && !opts.TTY,
+		TTY:       opts.TTY,
+	}
+
+	return client.attach(ctx, p, attachOptions, stdin, stdout, stderr, nil, 0)
+}
+
+// addEphemeralContainer adds the ephemeral container, given as its JSON fields,
+// to the pod
+func (c *Client) addEphemeralContainer(ctx context.Context, pod *v1.Pod, container map[string]interface{}) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"ephemeralContainers": []interface{}{container},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if c.config == nil {
+		return errNoREST
+	}
+
+	return c.podsREST().Patch(types.StrategicMergePatchType).
+		Context(ctx).
+		Namespace(pod.Namespace).
+		Resource("pods").
+		Name(pod.Name).
+		SubResource("ephemeralcontainers").
+		Body(patch).
+		Do().
+		Error()
+}
+
+// ephemeralPod holds the fields of a pod about ephemeral containers, which are
+// not part of the API types of the package
+type ephemeralPod struct {
+	Status struct {
+		EphemeralContainerStatuses []v1.ContainerStatus `json:"ephemeralContainerStatuses"`
+	} `json:"status"`
+}
+
+// waitEphemeralContainer polls the pod until the ephemeral container is running,
+// or returns an error if it terminated or cannot start
+func (c *Client) waitEphemeralContainer(ctx context.Context, pod *v1.Pod, name string) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		raw, err := c.podsREST().Get().
+			Context(ctx).
+			Namespace(pod.Namespace).
+			Resource("pods").
+			Name(pod.Name).
+			DoRaw()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("cannot get pod: %w", err)
+		}
+
+		var p ephemeralPod
+		if err := json.Unmarshal(raw, &p); err != nil {
+			return fmt.Errorf("cannot decode pod: %v", err)
+		}
+
+		for _, s := range p.Status.EphemeralContainerStatuses {
+			if s.Name != name {
+				continue
+			}
+			if s.State.Running != nil {
+				return nil
+			}
+			if t := s.State.Terminated; t != nil {
+				return fmt.Errorf("ephemeral container %s terminated (%s)", name, t.Reason)
+			}
+			if w := s.State.Waiting; w != nil && fatalWaitingReasons[w.Reason] {
+				return &Error{Kind: ErrPodStart, Err: fmt.Errorf("container %s cannot start (%s): %s", name, w.Reason, w.Message)}
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// defaultDiagnosticLogLines is the number of last lines of the logs
+	// collected for a failed command, if not set in the config
+	defaultDiagnosticLogLines = 20
+
+	// diagnosticsTimeout bounds the time to collect the diagnostics, which is
+	// not bound by the context of the command, as it may be done
+	diagnosticsTimeout = 10 * time.Second
+)
+
+// Diagnostics describe the pod of a failed command, so the failure can be
+// understood without inspecting the cluster.
+type Diagnostics struct {
+	Pod       string
+	Namespace string
+	Node      string
+	Phase     v1.PodPhase
+
+	// Reason and Message are those of the pod status, if any.
+	Reason  string
+	Message string
+
+	// InitContainerStatuses and ContainerStatuses are the statuses of the
+	// containers of the pod when the command failed.
+	InitContainerStatuses []v1.ContainerStatus
+	ContainerStatuses     []v1.ContainerStatus
+
+	// Events are the events about the pod, oldest first.
+	Events []v1.Event
+
+	// Logs are the last lines of the logs of the container of the command.
+	Logs []string
+}
+
+// String returns a readable summary of the diagnostics.
+func (d *Diagnostics) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "pod %s/%s", d.Namespace, d.Pod)
+	if d.Node != "" {
+		fmt.Fprintf(&b, " on node %s", d.Node)
+	}
+	fmt.Fprintf(&b, " is %s", d.Phase)
+	if d.Reason != "" {
+		fmt.Fprintf(&b, " (%s: %s)", d.Reason, d.Message)
+	}
+	b.WriteString("\n")
+
+	for _, s := range append(append([]v1.ContainerStatus{}, d.InitContainerStatuses...), d.ContainerStatuses...) {
+		fmt.Fprintf(&b, "container %s: %s, %d restarts\n", s.Name, containerState(s.State), s.RestartCount)
+	}
+
+	if len(d.Events) > 0 {
+		b.WriteString("events:\n")
+		for _, e := range d.Events {
+			fmt.Fprintf(&b, "  %s %s: %s\n", e.Type, e.Reason, e.Message)
+		}
+	}
+
+	if len(d.Logs) > 0 {
+		b.WriteString("logs:\n")
+		for _, l := range d.Logs {
+			fmt.Fprintf(&b, "  %s\n", l)
+		}
+	}
+
+	return b.String()
+}
+
+// containerState describes the state of a container in a few words
+func containerState(s v1.ContainerState) string {
+	switch {
+	case s.Waiting != nil:
+		return fmt.Sprintf("waiting (%s)", s.Waiting.Reason)
+	case s.Running != nil:
+		return "running"
+	case s.Terminated != nil:
+		return fmt.Sprintf("terminated with exit code %d (%s)", s.Terminated.ExitCode, s.Terminated.Reason)
+	}
+
+	return "unknown"
+}
+
+// Diagnostics returns the diagnostics collected when the command failed, or
+// nil if it did not fail, or no diagnostics could be collected.
+func (cmd *Cmd) Diagnostics() *Diagnostics {
+	return cmd.diagnostics
+}
+
+// collectDiagnostics collects the diagnostics of the pod of the command after
+// it failed with err, and adds them to err if it is an *ExitError
+func (cmd *Cmd) collectDiagnostics(err error) {
+	lines := cmd.Cfg.DiagnosticLogLines
+	if lines == 0 {
+		lines = defaultDiagnosticLogLines
+	}
+	if lines < 0 || cmd.pod == nil || cmd.client == nil || cmd.Cfg.DryRun || errors.Is(err, context.Canceled) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), diagnosticsTimeout)
+	defer cancel()
+
+	pod, gerr := cmd.client.refreshPod(cmd.pod)
+	if gerr != nil {
+		pod = cmd.pod
+	}
+
+	d := &Diagnostics{
+		Pod:                   pod.Name,
+		Namespace:             pod.Namespace,
+		Node:                  pod.Spec.NodeName,
+		Phase:                 pod.Status.Phase,
+		Reason:                pod.Status.Reason,
+		Message:               pod.Status.Message,
+		InitContainerStatuses: pod.Status.InitContainerStatuses,
+		ContainerStatuses:     pod.Status.ContainerStatuses,
+	}
+
+	if events, err := cmd.client.podEvents(pod); err == nil {
+		sort.Slice(events, func(i, j int) bool {
+			return events[i].LastTimestamp.Before(&events[j].LastTimestamp)
+		})
+		d.Events = events
+	}
+
+	// the logs are those of the container of the command
+	container := cmd.Container
+	if container == "" {
+		container = pod.Spec.Containers[0].Name
+	}
+	var logs bytes.Buffer
+	lerr := cmd.client.streamLogs(ctx, pod, &v1.PodLogOptions{Container: container, TailLines: &lines}, &logs)
+	if lerr == nil && logs.Len() > 0 {
+		d.Logs = strings.Split(strings.TrimSuffix(logs.String(), "\n"), "\n")
+	}
+
+	cmd.diagnostics = d
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		exitErr.Diagnostics = d
+	}
+}
@@ -0,0 +1,298 @@
+package exec
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// ExitError reports an unsuccessful exit by a command executed in a pod.
+type ExitError struct {
+	// Code is the exit code of the command.
+	Code int
+
+	// Reason is the brief reason the container terminated (i.e. Error, OOMKilled),
+	// if known.
+	Reason string
+
+	// Message is the termination message of the container, if any.
+	Message string
+
+	// Stderr holds the standard error output of the command, if it was
+	// collected by Cmd.Output.
+	Stderr []byte
+
+	// Diagnostics describe the pod of the command, if they could be collected.
+	Diagnostics *Diagnostics
+}
+
+func (e *ExitError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("exit status %d (%s)", e.Code, e.Reason)
+	}
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// ExitCode returns the exit code of the command.
+func (e *ExitError) ExitCode() int {
+	return e.Code
+}
+
+// JobError reports an unsuccessful job, when the command runs as a job.
+type JobError struct {
+	// Succeeded and Failed are the number of pods of the job that succeeded
+	// and failed.
+	Succeeded int32
+	Failed    int32
+
+	// Reason and Message describe why the job failed (i.e. BackoffLimitExceeded).
+	Reason  string
+	Message string
+}
+
+func (e *JobError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("job failed (%s): %d pods failed", e.Reason, e.Failed)
+	}
+	return fmt.Sprintf("job failed: %d pods failed", e.Failed)
+}
+
+// InitError reports an init container of the pod of a command that failed, so
+// the command could not start.
+type InitError struct {
+	// Container is the name of the init container.
+	Container string
+
+	// Code is the exit code of the init container, or -1 if it could not start.
+	Code int
+
+	// Reason and Message describe why the init container failed.
+	Reason  string
+	Message string
+
+	// Results are the results of all the init containers of the pod, to tell
+	// which stages of the setup completed.
+	Results []InitResult
+}
+
+func (e *InitError) Error() string {
+	if e.Code < 0 {
+		return fmt.Sprintf("init container %s cannot start (%s): %s", e.Container, e.Reason, e.Message)
+	}
+	return fmt.Sprintf("init container %s failed with exit status %d (%s)", e.Container, e.Code, e.Reason)
+}
+
+// DeadlineError reports a command killed by the cluster because its pod, or
+// its job, ran longer than the ActiveDeadlineSeconds of the config. It matches
+// ErrTimeout with errors.Is.
+type DeadlineError struct {
+	Deadline time.Duration
+
+	// Message is the message of the cluster, if any.
+	Message string
+}
+
+func (e *DeadlineError) Error() string {
+	return fmt.Sprintf("active deadline of %v exceeded", e.Deadline)
+}
+
+// Is reports whether the target is ErrTimeout.
+func (e *DeadlineError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+// deadlineExceeded returns a *DeadlineError if the reason of the pod or job
+// status is that it ran longer than its active deadline
+func deadlineExceeded(reason, message string, deadline *int64) error {
+	if reason != "DeadlineExceeded" {
+		return nil
+	}
+
+	e := &DeadlineError{Message: message}
+	if deadline != nil {
+		e.Deadline = time.Duration(*deadline) * time.Second
+	}
+
+	return e
+}
+
+// EvictionError reports a pod of a command that failed because of its node
+// rather than of the command: it was evicted, or lost or shut down with its
+// node, or deleted while the command ran, such as by a node drain or to
+// preempt it. It matches ErrEvicted with errors.Is.
+type EvictionError struct {
+	// Reason and Message are those of the status of the pod (i.e. Evicted).
+	Reason  string
+	Message string
+}
+
+func (e *EvictionError) Error() string {
+	return fmt.Sprintf("pod failed (%s): %s", e.Reason, e.Message)
+}
+
+// Is reports whether the target is ErrEvicted.
+func (e *EvictionError) Is(target error) bool {
+	return target == ErrEvicted
+}
+
+// evictionReasons are the reasons of the status of a failed pod that did not
+// fail because of its containers
+var evictionReasons = map[string]bool{
+	"Evicted":                  true,
+	"NodeLost":                 true,
+	"Shutdown":                 true,
+	"Terminated":               true,
+	"UnexpectedAdmissionError": true,
+}
+
+// podEvicted returns an *EvictionError if the pod failed because of its node
+func podEvicted(pod *v1.Pod) error {
+	if pod.Status.Phase != v1.PodFailed || !evictionReasons[pod.Status.Reason] {
+		return nil
+	}
+
+	return &EvictionError{Reason: pod.Status.Reason, Message: pod.Status.Message}
+}
+
+// reasonDeleted is the reason of the *EvictionError of a pod deleted while the
+// command runs, by another client than the command
+const reasonDeleted = "Deleted"
+
+// podDeleted returns an *EvictionError if the pod is being deleted before the
+// command completed, or its command was killed by the deletion
+func podDeleted(pod *v1.Pod) error {
+	if pod.DeletionTimestamp == nil {
+		return nil
+	}
+	if state := terminatedState(pod); state != nil && state.ExitCode == 0 {
+		return nil
+	}
+
+	return &EvictionError{
+		Reason:  reasonDeleted,
+		Message: fmt.Sprintf("pod deleted while the command ran, on node %s", pod.Spec.NodeName),
+	}
+}
+
+// SelectorError reports a command that failed in some of the pods it was
+// executed in by RunOnSelector or RunInWorkload.
+type SelectorError struct {
+	Results []PodResult
+}
+
+func (e *SelectorError) Error() string {
+	failed := 0
+	for _, r := range e.Results {
+		if r.Err != nil {
+			failed++
+		}
+	}
+	return fmt.Sprintf("command failed in %d of %d pods", failed, len(e.Results))
+}
+
+// ShardError reports a command that failed in some of its shards, run by
+// RunShards.
+type ShardError struct {
+	Results []ShardResult
+}
+
+func (e *ShardError) Error() string {
+	failed := 0
+	for _, r := range e.Results {
+		if r.Err != nil {
+			failed++
+		}
+	}
+	return fmt.Sprintf("command failed in %d of %d shards", failed, len(e.Results))
+}
+
+// Kinds of errors returned when an operation against the Kubernetes API fails.
+// The returned errors are of type *Error, and can be matched against these
+// with errors.Is.
+var (
+	ErrClientInit = errors.New("cannot initialize kubernetes client")
+	ErrPodCreate  = errors.New("cannot create pod")
+	ErrAttach     = errors.New("cannot attach to pod")
+	ErrPodStart   = errors.New("pod did not start")
+	ErrAdmission  = errors.New("pod would not be admitted")
+	ErrPreflight  = errors.New("preflight check failed")
+	ErrPreemption = errors.New("pod could preempt other pods")
+
+	// ErrInvalidConfig is returned before any API call is made.
+	ErrInvalidConfig = errors.New("invalid config")
+
+	ErrNamespaceNotFound = errors.New("namespace not found")
+
+	// ErrEvicted is matched by the *EvictionError of a pod disrupted while
+	// the command runs.
+	ErrEvicted = errors.New("pod evicted")
+)
+
+// Kinds of the termination of a command stopped before it exited on its own.
+// The error of such a command matches exactly one of them, and ErrEvicted,
+// with errors.Is, and wraps the error it was stopped with.
+var (
+	// ErrCanceled is matched when the context of the command was canceled.
+	// The error also matches context.Canceled.
+	ErrCanceled = errors.New("command canceled")
+
+	// ErrTimeout is matched when the deadline of the context of the command,
+	// one of the timeouts of its config, or the active deadline of its pod
+	// was exceeded, by a *TimeoutError, a *DeadlineError, or an error also
+	// matching context.DeadlineExceeded.
+	ErrTimeout = errors.New("command timed out")
+
+	// ErrKilled is matched when the command was killed with Signal, which
+	// deletes its pod.
+	ErrKilled = errors.New("command killed")
+
+	// ErrStreamLost is matched when the stream attached to the command broke,
+	// and attaching to it again failed.
+	ErrStreamLost = errors.New("stream lost")
+)
+
+// Termination returns the kind of the termination of a command failing with
+// err: ErrCanceled, ErrTimeout, ErrKilled, ErrStreamLost or ErrEvicted, or nil
+// if the command was not stopped, such as when it exited with a non-zero code.
+func Termination(err error) error {
+	for _, kind := range []error{ErrCanceled, ErrTimeout, ErrKilled, ErrStreamLost, ErrEvicted} {
+		if errors.Is(err, kind) {
+			return kind
+		}
+	}
+	return nil
+}
+
+// Error records a failed operation against the Kubernetes API and its cause.
+type Error struct {
+	// Kind is one of the Err* kinds of errors of the package.
+	Kind error
+
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *Error) Error() string {
+	return e.Kind.Error() + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the error is of the given kind.
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// unwrap returns the underlying error of an *Error, so it can be checked with
+// the predicates of the Kubernetes API errors package
+func unwrap(err error) error {
+	if e, ok := err.(*Error); ok {
+		return e.Err
+	}
+	return err
+}
@@ -0,0 +1,93 @@
+// Command soak runs many short commands through a single client, and reports
+// the goroutines and open files of the process before and after, so leaked
+// connections to the API server show as a growth proportional to the runs. It
+// exits with a non-zero code if they still grew by more than -slack once the
+// client is closed.
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	kube "github.com/engineerd/kube-exec"
+)
+
+func main() {
+	runs := flag.Int("runs", 200, "number of commands to run")
+	parallel := flag.Int("parallel", 10, "number of commands running at the same time")
+	slack := flag.Int("slack", 5, "growth of the goroutines and open files tolerated once the client is closed")
+	flag.Parse()
+
+	client, err := kube.NewClient(os.Getenv("KUBECONFIG"))
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+
+	cfg := kube.Config{
+		Client:       client,
+		Image:        "busybox",
+		GenerateName: "kube-soak-",
+		Namespace:    "default",
+	}
+
+	// warm up the connections of the client before measuring
+	if err := kube.Command(cfg, "true").Run(); err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	goroutines, files := runtime.NumGoroutine(), openFiles()
+	log.Printf("before: %d goroutines, %d open files", goroutines, files)
+
+	start := time.Now()
+	sem := make(chan struct{}, *parallel)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := 0
+
+	for i := 0; i < *runs; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			cmd := kube.Command(cfg, "/bin/sh", "-c", "echo soak")
+			cmd.Stdout = ioutil.Discard
+			if err := cmd.Run(); err != nil {
+				mu.Lock()
+				failed++
+				mu.Unlock()
+				log.Printf("run failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	log.Printf("%d runs, %d failed, in %v", *runs, failed, time.Since(start))
+	log.Printf("after: %d goroutines, %d open files", runtime.NumGoroutine(), openFiles())
+
+	client.Close()
+	time.Sleep(time.Second)
+	closedGoroutines, closedFiles := runtime.NumGoroutine(), openFiles()
+	log.Printf("closed: %d goroutines, %d open files", closedGoroutines, closedFiles)
+
+	if closedGoroutines > goroutines+*slack {
+		log.Fatalf("error: %d goroutines leaked", closedGoroutines-goroutines)
+	}
+	if files >= 0 && closedFiles > files+*slack {
+		log.Fatalf("error: %d open files leaked", closedFiles-files)
+	}
+}
+
+// openFiles returns the number of open files of the process, or -1 if unknown
+func openFiles() int {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(fds)
+}
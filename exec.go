@@ -0,0 +1,147 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// ExecOptions contains the options for executing a command in an existing pod
+type ExecOptions struct {
+	Kubeconfig string
+
+	// Client is the client used to reach the cluster. If nil, the shared
+	// client for Kubeconfig is used.
+	Client *Client
+
+	// Logger receives the events of the session. If nil, events are discarded.
+	Logger Logger
+
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	TTY bool
+
+	// Keepalive, if not zero, is the period of the traffic sent on idle streams
+	// so they are not closed by proxies and load balancers.
+	Keepalive time.Duration
+}
+
+// ExecInPod executes a new command in a container of an already running pod,
+// using the pod's exec subresource, like kubectl exec. If container is empty, the
+// first container of the pod is used. Init and ephemeral containers can be given
+// by name. If namespace is empty, the default namespace of the client is used.
+//
+// With TTY, if Stdin is a terminal it is put in raw mode and its size is sent to
+// the container while the command runs.
+//
+// If the command exits with a non-zero code, the returned error is an *ExitError.
+func ExecInPod(ctx context.Context, namespace, pod, container string, command []string, opts ExecOptions) error {
+	if len(command) == 0 {
+		return fmt.Errorf("no command to execute")
+	}
+
+	log := loggerOrNop(opts.Logger)
+
+	client, err := getClient(opts.Client, kubeconfigKey{path: opts.Kubeconfig}, log)
+	if err != nil {
+		return err
+	}
+
+	if namespace == "" {
+		namespace = client.namespace
+	}
+
+	p, err := client.getPod(namespace, pod)
+	if err != nil {
+		return fmt.Errorf("cannot get pod: %w", err)
+	}
+
+	if p.Status.Phase != v1.PodRunning {
+		return fmt.Errorf("pod %s/%s is not running (%s)", namespace, pod, p.Status.Phase)
+	}
+
+	c, err := containerName(container, p)
+	if err != nil {
+		return fmt.Errorf("cannot get container to execute in: %v", err)
+	}
+
+	execOptions := &v1.PodExecOptions{
+		Container: c,
+		Command:   command,
+		Stdin:     opts.Stdin != nil,
+		Stdout:    opts.Stdout != nil,
+
+		// with a TTY, stderr is merged into stdout
+		Stderr: opts.Stderr != nil && !opts.TTY,
+		TTY:    opts.TTY,
+	}
+
+	stdin, stdout, stderr := opts.Stdin, opts.Stdout, opts.Stderr
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+	if stderr == nil {
+		stderr = ioutil.Discard
+	}
+	if !opts.TTY && sameWriter(stdout, stderr) {
+		stdout = &lockedWriter{w: stdout}
+		stderr = stdout
+	}
+
+	var sizeQueue remotecommand.TerminalSizeQueue
+	if opts.TTY {
+		t, err := setupTerminal(stdin, stdout)
+		if err != nil {
+			return fmt.Errorf("cannot set up terminal: %v", err)
+		}
+		if t != nil {
+			defer t.restore()
+
+			stop := make(chan struct{})
+			defer close(stop)
+			sizeQueue = t.sizeQueue(stop)
+		}
+	}
+
+	log.Debug("exec started", "namespace", namespace, "pod", pod, "container", c, "command", command)
+	err = client.execInPod(ctx, p, execOptions, stdin, stdout, stderr, sizeQueue, opts.Keepalive)
+	if err != nil {
+		if _, ok := err.(*ExitError); ok {
+			log.Info("command completed", "namespace", namespace, "pod", pod, "error", err)
+			return err
+		}
+		log.Error("exec failed", "namespace", namespace, "pod", pod, "error", err)
+		return fmt.Errorf("cannot exec: %w", err)
+	}
+
+	log.Debug("stream closed", "namespace", namespace, "pod", pod)
+	return nil
+}
+
+// Exec executes a new command in the container of the pod of the command, next
+// to the running command, like ExecInPod. It waits for the pod to be started,
+// as selected by the WaitFor of the config.
+// The Kubeconfig, Client and Logger of the options are those of the command,
+// and the command is executed through its Executor if it is not a *Client.
+//
+// The command must have been started by Start, and must not run as a job.
+func (cmd *Cmd) Exec(command []string, opts ExecOptions) error {
+	if cmd.pod == nil {
+		return errors.New("exec: Exec before command started")
+	}
+
+	_, err := cmd.exec.WaitReady(cmd.ctx, cmd.pod, cmd.startCondition(), cmd.startFailure)
+	if err != nil {
+		return err
+	}
+
+	return cmd.execute(command, opts)
+}
@@ -0,0 +1,124 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Executor is the backend running the pods of commands. *Client implements it
+// with a Kubernetes cluster, and other backends, such as a local container
+// engine for development, can be set in Config.Executor.
+//
+// The features going through other Kubernetes APIs, such as jobs, dry runs,
+// prechecks, logs, events, diagnostics, workspace outputs and port forwarding,
+// need a *Client.
+type Executor interface {
+	// CreatePod creates the pod in its namespace, and returns it as created.
+	CreatePod(ctx context.Context, pod *v1.Pod) (*v1.Pod, error)
+
+	// WaitReady waits until the pod satisfies ready, and returns its last
+	// observed state, or returns the context error if the context is done
+	// first. If fail is not nil and returns an error for a state of the pod,
+	// waiting stops and the error is returned.
+	WaitReady(ctx context.Context, pod *v1.Pod, ready func(*v1.Pod) bool, fail func(*v1.Pod) error) (*v1.Pod, error)
+
+	// Stream streams to and from a container of the pod, and returns once the
+	// stream ends or the context is done.
+	Stream(ctx context.Context, req StreamRequest) error
+
+	// Delete deletes the pod, with an optional grace period in seconds.
+	Delete(ctx context.Context, pod *v1.Pod, gracePeriod *int64) error
+}
+
+// CreatePod creates the pod in its namespace.
+func (c *Client) CreatePod(ctx context.Context, pod *v1.Pod) (*v1.Pod, error) {
+	return c.createPod(ctx, pod.Namespace, pod, "", false)
+}
+
+// WaitReady waits until the pod satisfies ready, by watching it.
+func (c *Client) WaitReady(ctx context.Context, pod *v1.Pod, ready func(*v1.Pod) bool, fail func(*v1.Pod) error) (*v1.Pod, error) {
+	return c.waitPod(ctx, pod, ready, fail)
+}
+
+// Stream attaches to a container of the pod, or executes a command in it.
+func (c *Client) Stream(ctx context.Context, req StreamRequest) error {
+	switch req.Subresource {
+	case "attach":
+		attachOptions := &v1.PodAttachOptions{
+			Container: req.Container,
+			Stdin:     req.Stdin != nil,
+			Stdout:    req.Stdout != nil,
+			Stderr:    req.Stderr != nil,
+			TTY:       req.TTY,
+		}
+		return c.attach(ctx, req.Pod, attachOptions, req.Stdin, req.Stdout, req.Stderr, req.SizeQueue, req.Keepalive)
+	case "exec":
+		execOptions := &v1.PodExecOptions{
+			Container: req.Container,
+			Command:   req.Command,
+			Stdin:     req.Stdin != nil,
+			Stdout:    req.Stdout != nil,
+			Stderr:    req.Stderr != nil,
+			TTY:       req.TTY,
+		}
+		return c.execInPod(ctx, req.Pod, execOptions, req.Stdin, req.Stdout, req.Stderr, req.SizeQueue, req.Keepalive)
+	}
+
+	return fmt.Errorf("unknown subresource %q", req.Subresource)
+}
+
+// Delete deletes the pod.
+func (c *Client) Delete(ctx context.Context, pod *v1.Pod, gracePeriod *int64) error {
+	return c.deletePod(pod, gracePeriod)
+}
+
+// kubernetes returns the client of the command, or an error if its executor
+// is not a Kubernetes client, for the features that need one
+func (cmd *Cmd) kubernetes(feature string) (*Client, error) {
+	if cmd.client == nil {
+		return nil, fmt.Errorf("exec: %s needs a Kubernetes client, not %T", feature, cmd.exec)
+	}
+
+	return cmd.client, nil
+}
+
+// execute executes a command in the container of the pod of the command: with
+// ExecInPod for a Kubernetes client, or through the executor
+func (cmd *Cmd) execute(command []string, opts ExecOptions) error {
+	return cmd.executeIn(cmd.Container, command, opts)
+}
+
+// executeIn executes a command in the named container of the pod of the
+// command, like execute
+func (cmd *Cmd) executeIn(container string, command []string, opts ExecOptions) error {
+	return cmd.executeContext(cmd.ctx, container, command, opts)
+}
+
+// executeContext executes a command in the named container of the pod of the
+// command until ctx is done, like executeIn
+func (cmd *Cmd) executeContext(ctx context.Context, container string, command []string, opts ExecOptions) error {
+	if len(command) == 0 {
+		return errors.New("no command to execute")
+	}
+
+	if cmd.client != nil {
+		opts.Client = cmd.client
+		opts.Logger = cmd.Cfg.Logger
+		return ExecInPod(ctx, cmd.pod.Namespace, cmd.pod.Name, container, command, opts)
+	}
+
+	return cmd.exec.Stream(ctx, StreamRequest{
+		Pod:         cmd.pod,
+		Container:   container,
+		Subresource: "exec",
+		Command:     command,
+		Stdin:       opts.Stdin,
+		Stdout:      opts.Stdout,
+		Stderr:      opts.Stderr,
+		TTY:         opts.TTY,
+		Keepalive:   opts.Keepalive,
+	})
+}
@@ -0,0 +1,103 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// Forward forwards connections to a local port to a port of the pod of the
+// command, so a server started by the command can be reached from the local
+// machine. If localPort is zero, a random port is chosen. It returns the local
+// port once it is listening.
+//
+// Forward waits for the pod to be started, as selected by the WaitFor of the
+// config, so WaitReady waits for the server to pass its readiness probe.
+// Forwarding stops when the command terminates, or when its context is done.
+// The command must have been started by Start, and must not run as a job.
+func (cmd *Cmd) Forward(localPort, remotePort int) (int, error) {
+	if cmd.pod == nil {
+		return 0, errors.New("exec: Forward before command started")
+	}
+
+	client, err := cmd.kubernetes("Forward")
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = client.waitPod(cmd.ctx, cmd.pod, cmd.startCondition(), cmd.startFailure)
+	if err != nil {
+		return 0, err
+	}
+
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-cmd.exited:
+		case <-cmd.ctx.Done():
+		}
+		close(stop)
+	}()
+
+	port, err := client.portForward(cmd.ctx, cmd.pod, localPort, remotePort, stop)
+	if err != nil {
+		return 0, fmt.Errorf("cannot forward port %d: %w", remotePort, err)
+	}
+
+	cmd.log.Debug("port forwarded", "namespace", cmd.pod.Namespace, "pod", cmd.pod.Name, "localPort", port, "remotePort", remotePort)
+	return port, nil
+}
+
+// portForward forwards a local port to a port of the pod until stop is closed,
+// and returns the local port once it is listening
+func (c *Client) portForward(ctx context.Context, pod *v1.Pod, localPort, remotePort int, stop chan struct{}) (int, error) {
+	if c.config == nil {
+		return 0, errNoREST
+	}
+
+	transport, upgrader, err := c.roundTripperFor(0)
+	if err != nil {
+		return 0, err
+	}
+
+	req := c.podsREST().Post().
+		Resource("pods").
+		Name(pod.Name).
+		Namespace(pod.Namespace).
+		SubResource("portforward")
+
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, "POST", req.URL())
+
+	ready := make(chan struct{})
+	ports := []string{fmt.Sprintf("%d:%d", localPort, remotePort)}
+	fw, err := portforward.New(dialer, ports, stop, ready, ioutil.Discard, ioutil.Discard)
+	if err != nil {
+		return 0, err
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- fw.ForwardPorts()
+	}()
+
+	select {
+	case <-ready:
+	case err := <-errc:
+		return 0, err
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+
+	forwarded, err := fw.GetPorts()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(forwarded[0].Local), nil
+}
@@ -0,0 +1,121 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultHealthInterval is the time between the checks of a health check, if
+// not set
+const defaultHealthInterval = time.Second
+
+// HealthCheck checks that a server started by the command responds, through a
+// port forwarded from the pod, before the command is attached to, for commands
+// with a warm-up phase such as databases or language servers.
+type HealthCheck struct {
+	// Port is the port of the pod the server listens on.
+	Port int
+
+	// Path, if not empty, is the path of an HTTP endpoint of the server, which
+	// must respond with a 2xx or 3xx status. Otherwise, the server must accept
+	// TCP connections on the port.
+	Path string
+
+	// Interval is the time between the checks. If zero, it is 1 second.
+	Interval time.Duration
+}
+
+// interval returns the time between the checks
+func (h *HealthCheck) interval() time.Duration {
+	if h.Interval <= 0 {
+		return defaultHealthInterval
+	}
+	return h.Interval
+}
+
+// check checks the server once, through the local address forwarded to it
+func (h *HealthCheck) check(ctx context.Context, addr string) error {
+	if h.Path != "" {
+		ctx, cancel := context.WithTimeout(ctx, h.interval())
+		defer cancel()
+
+		req, err := http.NewRequest(http.MethodGet, "http://"+addr+h.Path, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return fmt.Errorf("status %s", resp.Status)
+		}
+		return nil
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, h.interval())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// the local connection is always accepted, and closed by the forwarder
+	// right away if the port of the pod is not
+	conn.SetReadDeadline(time.Now().Add(h.interval() / 2))
+	n, err := conn.Read(make([]byte, 1))
+	if n > 0 {
+		return nil
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return nil
+	}
+	return fmt.Errorf("connection closed: %v", err)
+}
+
+// waitHealthy waits for the server of the command to pass its health check,
+// or for the command to terminate
+func (cmd *Cmd) waitHealthy() (err error) {
+	h := cmd.Cfg.HealthCheck
+
+	client, err := cmd.kubernetes("HealthCheck")
+	if err != nil {
+		return err
+	}
+
+	endSpan := cmd.startSpan("kube-exec.health")
+	defer func() { endSpan(err) }()
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	port, err := client.portForward(cmd.ctx, cmd.pod, 0, h.Port, stop)
+	if err != nil {
+		return fmt.Errorf("cannot forward port %d for health check: %w", h.Port, err)
+	}
+	addr := net.JoinHostPort("localhost", strconv.Itoa(port))
+
+	for {
+		cerr := h.check(cmd.ctx, addr)
+		if cerr == nil {
+			cmd.log.Debug("health check passed", "namespace", cmd.pod.Namespace, "pod", cmd.pod.Name, "port", h.Port)
+			return nil
+		}
+
+		// nothing left to wait for
+		if pod, gerr := client.refreshPod(cmd.pod); gerr == nil && podCompleted(pod) {
+			return nil
+		}
+
+		cmd.log.Debug("health check failed", "namespace", cmd.pod.Namespace, "pod", cmd.pod.Name, "port", h.Port, "error", cerr)
+		select {
+		case <-time.After(h.interval()):
+		case <-cmd.ctx.Done():
+			return cmd.ctx.Err()
+		}
+	}
+}
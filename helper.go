@@ -0,0 +1,100 @@
+package exec
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// HelperDir is the directory the binary of the Helper is injected in, with
+	// a link for each of its applets, such as HelperDir + "/tar", which can be
+	// executed with Exec in images without them.
+	HelperDir = "/kube-exec-helper"
+
+	// helperVolume is the name of the volume of the helper, and helperInit the
+	// name of the init container copying it
+	helperVolume = "kube-exec-helper"
+	helperInit   = "kube-exec-helper"
+
+	// defaultHelperImage is the image of the helper, if not set: the busybox
+	// of its musl variant is statically linked
+	defaultHelperImage = "busybox:musl"
+	defaultHelperPath  = "/bin/busybox"
+)
+
+// Helper injects a static multi-call binary, busybox by default, into the
+// container of the command, for scratch and distroless images without a
+// shell. The shell wrapping the command for Artifacts, CaptureEnvironment,
+// Compression or a "/bin/sh" Shell is then the sh of the helper, finding the
+// tools it needs, such as tar, after those of the image, and signals are sent
+// with its kill.
+//
+// The binary is copied by an init container into a volume mounted read-only
+// at HelperDir, so it must not depend on the libraries of its image.
+type Helper struct {
+	// Image is the image of the binary. If empty, it is busybox:musl.
+	Image string
+
+	// Path is the path of the binary in the image, which must provide the sh,
+	// cp and ln applets, and list its applets with --list, as busybox does.
+	// If empty, it is /bin/busybox.
+	Path string
+}
+
+// image returns the image of the binary
+func (h *Helper) image() string {
+	if h.Image == "" {
+		return defaultHelperImage
+	}
+	return h.Image
+}
+
+// path returns the path of the binary in its image
+func (h *Helper) path() string {
+	if h.Path == "" {
+		return defaultHelperPath
+	}
+	return h.Path
+}
+
+// addHelper adds the volume of the helper to the spec, mounted in c, with the
+// init container copying the binary and linking its applets in it
+func addHelper(spec *v1.PodSpec, c *v1.Container, h *Helper) {
+	spec.Volumes = append(spec.Volumes, v1.Volume{
+		Name:         helperVolume,
+		VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}},
+	})
+	c.VolumeMounts = append(c.VolumeMounts, v1.VolumeMount{Name: helperVolume, MountPath: HelperDir, ReadOnly: true})
+
+	bin := HelperDir + "/helper"
+	script := fmt.Sprintf(`cp %s %s && for a in $(%s --list); do ln -sf helper %s/"$a"; done`,
+		shellQuote(h.path()), bin, bin, HelperDir)
+	spec.InitContainers = append(spec.InitContainers, v1.Container{
+		Name:         helperInit,
+		Image:        h.image(),
+		Command:      []string{h.path(), "sh", "-c", script},
+		VolumeMounts: []v1.VolumeMount{{Name: helperVolume, MountPath: HelperDir}},
+	})
+}
+
+// wrapHelper returns the command and arguments of the container running the
+// given command through the sh of the helper, if it runs through "/bin/sh -c",
+// with the applets of the helper added to the end of the PATH
+func wrapHelper(command, args []string) ([]string, []string) {
+	if len(command) != 2 || (command[0] != "/bin/sh" && command[0] != "sh") || command[1] != "-c" || len(args) == 0 {
+		return command, args
+	}
+
+	script := fmt.Sprintf(`PATH="${PATH:-/usr/local/bin:/usr/bin:/bin}:%s"; export PATH; %s`, HelperDir, args[0])
+	return []string{HelperDir + "/sh", "-c"}, append([]string{script}, args[1:]...)
+}
+
+// helperCommand returns the given command, executed in the container of the
+// command, as the applet of the helper if the config has one
+func (cmd *Cmd) helperCommand(command ...string) []string {
+	if cmd.Cfg.Helper == nil {
+		return command
+	}
+	return append([]string{HelperDir + "/" + command[0]}, command[1:]...)
+}
@@ -0,0 +1,158 @@
+package exec
+
+import (
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Hooks are callbacks called on the lifecycle events of the pod of a command,
+// fed by the watch of the pod and by the events about it. All callbacks are
+// optional, and are called from a single goroutine per kind of source.
+type Hooks struct {
+	// OnScheduled is called when the pod is scheduled on a node.
+	OnScheduled func(pod *v1.Pod)
+
+	// OnInitialized is called when all the init containers of the pod
+	// completed, if it has any.
+	OnInitialized func(pod *v1.Pod)
+
+	// OnPulling is called when the image of a container starts being pulled,
+	// with the message of the event.
+	OnPulling func(pod *v1.Pod, message string)
+
+	// OnImagePull is called with the progress of the image pulls of the
+	// containers: when they start and complete, from the events about the
+	// pod, and when they fail or back off, from the watch of the pod.
+	OnImagePull func(pod *v1.Pod, pull ImagePull)
+
+	// OnStarted is called when the container running the command starts.
+	OnStarted func(pod *v1.Pod)
+
+	// OnTerminated is called when the container running the command terminates.
+	OnTerminated func(pod *v1.Pod, state *ProcessState)
+
+	// OnRestart is called when the container running the command terminated
+	// and is restarted by the kubelet, with a RestartPolicy, with the state of
+	// the terminated container.
+	OnRestart func(pod *v1.Pod, state *ProcessState)
+
+	// OnWarning is called for every warning event about the pod, such as
+	// FailedScheduling or BackOff.
+	OnWarning func(pod *v1.Pod, event *v1.Event)
+}
+
+// hookTracker calls the hooks on the transitions of the pod, once each
+type hookTracker struct {
+	hooks *Hooks
+
+	scheduled   bool
+	initialized bool
+	started     bool
+	terminated  bool
+}
+
+// update calls the hooks for the transitions of the pod not seen yet
+func (t *hookTracker) update(pod *v1.Pod) {
+	h := t.hooks
+
+	if !t.scheduled && pod.Spec.NodeName != "" {
+		t.scheduled = true
+		if h.OnScheduled != nil {
+			h.OnScheduled(pod)
+		}
+	}
+
+	if !t.initialized && len(pod.Spec.InitContainers) > 0 && podInitialized(pod) {
+		t.initialized = true
+		if h.OnInitialized != nil {
+			h.OnInitialized(pod)
+		}
+	}
+
+	s := commandStatus(pod)
+	if s == nil {
+		return
+	}
+
+	if !t.started && (s.State.Running != nil || s.State.Terminated != nil) {
+		t.started = true
+		if h.OnStarted != nil {
+			h.OnStarted(pod)
+		}
+	}
+
+	if state := terminatedState(pod); !t.terminated && state != nil {
+		t.terminated = true
+		if h.OnTerminated != nil {
+			h.OnTerminated(pod, newProcessState(s, state))
+		}
+	}
+}
+
+// watchEvents calls fn with every event about the pod, and every time an event
+// is repeated, until stop is closed
+func (c *Client) watchEvents(pod *v1.Pod, stop <-chan struct{}, fn func(*v1.Event)) {
+	events := c.clientset.CoreV1().Events(pod.Namespace)
+	selector := fields.Set{
+		"involvedObject.name": pod.Name,
+		"involvedObject.uid":  string(pod.UID),
+	}.AsSelector().String()
+	watchlist := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = selector
+			return events.List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = selector
+			return events.Watch(options)
+		},
+	}
+
+	// fake clientsets ignore field selectors
+	handle := func(e *v1.Event) {
+		if e.InvolvedObject.UID == pod.UID {
+			fn(e)
+		}
+	}
+	_, controller := cache.NewInformer(watchlist, &v1.Event{}, time.Second*1, cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			handle(obj.(*v1.Event))
+		},
+		UpdateFunc: func(o, n interface{}) {
+			handle(n.(*v1.Event))
+		},
+	})
+
+	controller.Run(stop)
+}
+
+// watchEvents calls the hooks for the events about the pod of the command,
+// until stop is closed
+func (cmd *Cmd) watchEvents(stop <-chan struct{}) {
+	h := cmd.Hooks
+	if h == nil || (h.OnPulling == nil && h.OnImagePull == nil && h.OnWarning == nil) || cmd.client == nil {
+		return
+	}
+
+	cmd.client.watchEvents(cmd.pod, stop, func(e *v1.Event) {
+		switch {
+		case e.Type == v1.EventTypeWarning:
+			if h.OnWarning != nil {
+				h.OnWarning(cmd.pod, e)
+			}
+		case e.Reason == "Pulling":
+			if h.OnPulling != nil {
+				h.OnPulling(cmd.pod, e.Message)
+			}
+		}
+		if pull, ok := eventPull(e); ok && h.OnImagePull != nil {
+			h.OnImagePull(cmd.pod, pull)
+		}
+	})
+}
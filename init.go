@@ -0,0 +1,176 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// InitResult is the result of an init container of the pod of a command.
+type InitResult struct {
+	// Name is the name of the init container.
+	Name string
+
+	// Code is the exit code of the init container, or -1 if it did not
+	// terminate, such as when it is waiting or an earlier one failed.
+	Code int
+
+	// Reason and Message describe the state of the init container: why it
+	// terminated, or why it is waiting.
+	Reason  string
+	Message string
+
+	// StartedAt and FinishedAt are the times the init container started and
+	// terminated, if it did.
+	StartedAt  time.Time
+	FinishedAt time.Time
+
+	// RestartCount is the number of times the init container was restarted.
+	RestartCount int32
+}
+
+// initResults returns the results of the init containers of the pod, in the
+// order they run
+func initResults(pod *v1.Pod) []InitResult {
+	if len(pod.Spec.InitContainers) == 0 {
+		return nil
+	}
+
+	results := make([]InitResult, len(pod.Spec.InitContainers))
+	for i, c := range pod.Spec.InitContainers {
+		r := InitResult{Name: c.Name, Code: -1}
+
+		if s := initStatus(pod, c.Name); s != nil {
+			r.RestartCount = s.RestartCount
+			switch {
+			case s.State.Terminated != nil:
+				t := s.State.Terminated
+				r.Code, r.Reason, r.Message = int(t.ExitCode), t.Reason, t.Message
+				r.StartedAt, r.FinishedAt = t.StartedAt.Time, t.FinishedAt.Time
+			case s.State.Running != nil:
+				r.StartedAt = s.State.Running.StartedAt.Time
+			case s.State.Waiting != nil:
+				r.Reason, r.Message = s.State.Waiting.Reason, s.State.Waiting.Message
+			}
+		}
+
+		results[i] = r
+	}
+
+	return results
+}
+
+// initStatus returns the status of the named init container of the pod, or nil
+// if it is not known yet
+func initStatus(pod *v1.Pod, name string) *v1.ContainerStatus {
+	for i := range pod.Status.InitContainerStatuses {
+		if pod.Status.InitContainerStatuses[i].Name == name {
+			return &pod.Status.InitContainerStatuses[i]
+		}
+	}
+
+	return nil
+}
+
+// isInitContainer reports whether the named container is an init container of
+// the pod
+func isInitContainer(pod *v1.Pod, name string) bool {
+	for _, c := range pod.Spec.InitContainers {
+		if c.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// initContainerStarted returns a condition satisfied once the named init
+// container runs or has run, or the pod completed
+func initContainerStarted(name string) func(*v1.Pod) bool {
+	return func(pod *v1.Pod) bool {
+		s := initStatus(pod, name)
+		return podCompleted(pod) || (s != nil && (s.State.Running != nil || s.State.Terminated != nil))
+	}
+}
+
+// initContainerTerminated returns a condition satisfied once the named init
+// container terminated at least once, or the pod completed
+func initContainerTerminated(name string) func(*v1.Pod) bool {
+	return func(pod *v1.Pod) bool {
+		s := initStatus(pod, name)
+		return podCompleted(pod) || (s != nil && (s.State.Terminated != nil || s.LastTerminationState.Terminated != nil))
+	}
+}
+
+// streamInitLogs writes the logs of the init containers of the pod to
+// InitOutput, in order, each once it terminated and with its lines prefixed
+// with its name, until one of them fails or the context is done
+func (cmd *Cmd) streamInitLogs(ctx context.Context) error {
+	client, err := cmd.kubernetes("InitOutput")
+	if err != nil {
+		return err
+	}
+
+	for _, c := range cmd.pod.Spec.InitContainers {
+		pod, err := cmd.exec.WaitReady(ctx, cmd.pod, initContainerTerminated(c.Name), nil)
+		if err != nil {
+			return err
+		}
+
+		s := initStatus(pod, c.Name)
+		if s == nil || (s.State.Terminated == nil && s.LastTerminationState.Terminated == nil) {
+			return nil
+		}
+
+		// the logs of a restarted init container are the ones of its failure
+		opts := &v1.PodLogOptions{Container: c.Name}
+		if s.State.Terminated == nil {
+			opts.Previous = true
+		}
+
+		w := &prefixWriter{w: cmd.InitOutput, prefix: "[" + c.Name + "] "}
+		err = client.streamLogs(ctx, pod, opts, w)
+		w.Flush()
+		if err != nil {
+			return fmt.Errorf("cannot get logs of init container %s: %w", c.Name, err)
+		}
+
+		if t := s.State.Terminated; t == nil || t.ExitCode != 0 {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// followInitLogs streams the logs of the init containers to InitOutput while
+// the pod starts, and returns a function called with the result of waiting
+// for the pod to start, returning once the logs were streamed
+func (cmd *Cmd) followInitLogs() func(startErr error) {
+	if cmd.InitOutput == nil || len(cmd.pod.Spec.InitContainers) == 0 {
+		return func(error) {}
+	}
+
+	ctx, cancel := context.WithCancel(cmd.ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := cmd.streamInitLogs(ctx); err != nil && ctx.Err() == nil {
+			cmd.log.Warn("cannot stream logs of init containers", "namespace", cmd.pod.Namespace, "pod", cmd.pod.Name, "error", err)
+		}
+	}()
+
+	return func(startErr error) {
+		// the init containers all terminated, or one of them failed, unless
+		// the pod did not start for another reason
+		var initErr *InitError
+		if startErr != nil && !(errors.As(startErr, &initErr) && initErr.Code >= 0) {
+			cancel()
+		}
+		<-done
+		cancel()
+	}
+}
@@ -0,0 +1,276 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/cache"
+)
+
+// newJob returns the job to create for a command
+func newJob(cmd *Cmd) (*batchv1.Job, error) {
+	// the sidecars would keep the pods of the job running
+	if cmd.hasSidecars() {
+		return nil, fmt.Errorf("sidecars cannot be used with RunAsJob")
+	}
+
+	pod, err := newPod(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	// nobody attaches to the pods of a job
+	c := &pod.Spec.Containers[0]
+	c.Stdin = false
+	c.StdinOnce = false
+	c.TTY = false
+
+	// the deadline bounds the whole job, not each of its pods
+	if cmd.Cfg.ActiveDeadlineSeconds != nil {
+		pod.Spec.ActiveDeadlineSeconds = nil
+	}
+
+	return &batchv1.Job{
+		ObjectMeta: pod.ObjectMeta,
+		Spec: batchv1.JobSpec{
+			ActiveDeadlineSeconds:   cmd.Cfg.ActiveDeadlineSeconds,
+			BackoffLimit:            cmd.Cfg.BackoffLimit,
+			Completions:             cmd.Cfg.Completions,
+			TTLSecondsAfterFinished: cmd.Cfg.TTLSecondsAfterFinished,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      pod.Labels,
+					Annotations: pod.Annotations,
+				},
+				Spec: pod.Spec,
+			},
+		},
+	}, nil
+}
+
+// waitJob streams the logs of the pods of the job, and waits for the job to finish
+func (cmd *Cmd) waitJob() error {
+	var wg sync.WaitGroup
+	stdout := &lockedWriter{w: cmd.Stdout}
+	seen := map[types.UID]bool{}
+
+	stop := newStopChan()
+	watched := make(chan struct{})
+	go func() {
+		defer close(watched)
+		cmd.client.watchJobPods(cmd.job, stop.c, func(pod *v1.Pod) {
+			if pod.Status.Phase == v1.PodPending || seen[pod.UID] {
+				return
+			}
+			seen[pod.UID] = true
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				cmd.client.streamLogs(cmd.ctx, pod, &v1.PodLogOptions{Follow: true}, stdout)
+			}()
+		})
+	}()
+
+	job, err := cmd.client.waitJob(cmd.ctx, cmd.job, jobFinished)
+	stop.closeOnce()
+	<-watched
+	if err != nil {
+		wg.Wait()
+		return err
+	}
+
+	// pods that terminated before they were observed running
+	pods, err := cmd.client.listJobPods(cmd.job)
+	if err == nil {
+		for i := range pods {
+			if !seen[pods[i].UID] {
+				cmd.client.streamLogs(cmd.ctx, &pods[i], nil, stdout)
+			}
+		}
+	}
+	wg.Wait()
+
+	for _, c := range job.Status.Conditions {
+		if c.Type == batchv1.JobFailed && c.Status == v1.ConditionTrue {
+			if err := deadlineExceeded(c.Reason, c.Message, job.Spec.ActiveDeadlineSeconds); err != nil {
+				return err
+			}
+			return &JobError{
+				Succeeded: job.Status.Succeeded,
+				Failed:    job.Status.Failed,
+				Reason:    c.Reason,
+				Message:   c.Message,
+			}
+		}
+	}
+
+	return nil
+}
+
+// createJob creates the given job within the namespace, as the given field
+// manager if not empty. If dryRun is set, the job is validated and defaulted by
+// the server, but not persisted.
+func (c *Client) createJob(ctx context.Context, namespace string, job *batchv1.Job, fieldManager string, dryRun bool) (*batchv1.Job, error) {
+	if c.config == nil {
+		if dryRun {
+			return nil, &Error{Kind: ErrPodCreate, Err: fmt.Errorf("dry run %v", errNoREST)}
+		}
+		result, err := c.clientset.BatchV1().Jobs(namespace).Create(job)
+		if err != nil {
+			return nil, &Error{Kind: ErrPodCreate, Err: err}
+		}
+		return result, nil
+	}
+
+	req := c.clientset.BatchV1().RESTClient().Post().
+		Context(ctx).
+		Namespace(namespace).
+		Resource("jobs").
+		VersionedParams(createOptions(dryRun), scheme.ParameterCodec).
+		Body(job)
+	if fieldManager != "" {
+		req = req.Param("fieldManager", fieldManager)
+	}
+
+	result := &batchv1.Job{}
+	err := req.Do().Into(result)
+	if err != nil {
+		return nil, &Error{Kind: ErrPodCreate, Err: err}
+	}
+
+	return result, nil
+}
+
+// deleteJob deletes the given job and its pods, with an optional grace period in seconds
+func (c *Client) deleteJob(job *batchv1.Job, gracePeriod *int64) error {
+	propagation := metav1.DeletePropagationBackground
+	opts := deleteOptions(job.UID, gracePeriod)
+	opts.PropagationPolicy = &propagation
+
+	err := c.clientset.BatchV1().Jobs(job.Namespace).Delete(job.Name, opts)
+	if apierrors.IsConflict(err) {
+		return apierrors.NewNotFound(batchv1.Resource("jobs"), job.Name)
+	}
+
+	return err
+}
+
+// waitJob waits until the created job satisfies the given condition and returns
+// the last observed state of the job, or returns the context error if the context
+// is done first
+func (c *Client) waitJob(ctx context.Context, job *batchv1.Job, cond func(*batchv1.Job) bool) (*batchv1.Job, error) {
+	stop := newStopChan()
+	last := job
+
+	jobs := c.clientset.BatchV1().Jobs(job.Namespace)
+	selector := fields.OneTermEqualSelector("metadata.name", job.Name).String()
+	watchlist := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = selector
+			return jobs.List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = selector
+			return jobs.Watch(options)
+		},
+	}
+	check := func(obj interface{}) {
+		newJob := obj.(*batchv1.Job)
+		if newJob.Name == job.Name && (job.UID == "" || newJob.UID == job.UID) && cond(newJob) {
+			last = newJob
+			stop.closeOnce()
+		}
+	}
+
+	_, controller := cache.NewInformer(watchlist, &batchv1.Job{}, time.Second*1, cache.ResourceEventHandlerFuncs{
+		AddFunc: check,
+		UpdateFunc: func(o, n interface{}) {
+			check(n)
+		},
+	})
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			stop.closeOnce()
+		case <-stop.c:
+		}
+	}()
+
+	controller.Run(stop.c)
+
+	return last, ctx.Err()
+}
+
+// listJobPods returns the pods created for the job
+func (c *Client) listJobPods(job *batchv1.Job) ([]v1.Pod, error) {
+	list, err := c.clientset.CoreV1().Pods(job.Namespace).List(metav1.ListOptions{
+		LabelSelector: jobPodSelector(job),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return list.Items, nil
+}
+
+// watchJobPods calls fn with the pods of the job every time they change, until
+// stop is closed
+func (c *Client) watchJobPods(job *batchv1.Job, stop <-chan struct{}, fn func(*v1.Pod)) {
+	selector := jobPodSelector(job)
+	watchlist := c.podListWatch(job.Namespace, func(options *metav1.ListOptions) {
+		options.LabelSelector = selector
+	})
+
+	_, controller := cache.NewInformer(watchlist, &v1.Pod{}, time.Second*1, cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			fn(obj.(*v1.Pod))
+		},
+		UpdateFunc: func(o, n interface{}) {
+			fn(n.(*v1.Pod))
+		},
+	})
+
+	controller.Run(stop)
+}
+
+// jobPodSelector returns the label selector of the pods created for the job
+func jobPodSelector(job *batchv1.Job) string {
+	return fmt.Sprintf("controller-uid=%s", job.UID)
+}
+
+// jobFinished reports whether the job completed or failed
+func jobFinished(job *batchv1.Job) bool {
+	for _, c := range job.Status.Conditions {
+		if (c.Type == batchv1.JobComplete || c.Type == batchv1.JobFailed) && c.Status == v1.ConditionTrue {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lockedWriter serializes writes to w, for writers shared by several streams
+type lockedWriter struct {
+	sync.Mutex
+	w io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.Lock()
+	defer l.Unlock()
+	return l.w.Write(p)
+}
@@ -1,104 +1,224 @@
 package exec
 
 import (
+	"context"
 	"fmt"
 	"io"
-	"log"
+	"io/ioutil"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
 	restclient "k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
 )
 
-// getKubeClient is a convenience method for creating kubernetes config and client
-// for a given kubeconfig
-func getKubeClient(kubeconfig string) (*kubernetes.Clientset, *restclient.Config, error) {
-	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
-	if err != nil {
-		return nil, nil, fmt.Errorf("could not get kubernetes config from kubeconfig '%s': %v", kubeconfig, err)
+// getKubeConfig returns the kubernetes config for a given kubeconfig path, with
+// the given overrides if not nil, and the namespace of its current context.
+// If the path is empty, the KUBECONFIG environment variable is used, and if that
+// is not set either, the in-cluster config is used, with the namespace of the
+// service account.
+func getKubeConfig(kubeconfig string, overrides *clientcmd.ConfigOverrides) (*restclient.Config, string, error) {
+	if kubeconfig == "" {
+		kubeconfig = os.Getenv(clientcmd.RecommendedConfigPathEnvVar)
 	}
 
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return nil, nil, fmt.Errorf("could not get kubernetes client: %s", err)
+	if kubeconfig == "" {
+		config, err := restclient.InClusterConfig()
+		if err != nil {
+			return nil, "", fmt.Errorf("could not get in-cluster kubernetes config: %w", err)
+		}
+		// the token read once by the config is rotated when projected
+		refreshToken(config, newFileToken(inClusterTokenPath))
+
+		namespace := v1.NamespaceDefault
+		if ns, err := ioutil.ReadFile(inClusterNamespacePath); err == nil && len(ns) > 0 {
+			namespace = strings.TrimSpace(string(ns))
+		}
+		return config, namespace, nil
 	}
-	return clientset, config, nil
-}
 
-// getPod returns a pod, given a namespace and pod name
-func getPod(kubeconfig, namespace, name string) (*v1.Pod, error) {
-	clientset, _, err := getKubeClient(kubeconfig)
+	// KUBECONFIG can hold a list of files, which are merged
+	rules := &clientcmd.ClientConfigLoadingRules{Precedence: filepath.SplitList(kubeconfig)}
+	if overrides == nil {
+		overrides = &clientcmd.ConfigOverrides{}
+	}
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+	config, err := clientConfig.ClientConfig()
 	if err != nil {
-		log.Fatalf("cannot get clientset: %v", err)
+		return nil, "", fmt.Errorf("could not get kubernetes config from kubeconfig '%s': %w", kubeconfig, err)
 	}
 
-	podsClient := clientset.CoreV1().Pods(namespace)
+	namespace, _, err := clientConfig.Namespace()
+	if err != nil || namespace == "" {
+		namespace = v1.NamespaceDefault
+	}
 
-	return podsClient.Get(name, metav1.GetOptions{})
+	// the token of a token file is read once by the config
+	if path := tokenFile(clientConfig, overrides); path != "" {
+		refreshToken(config, newFileToken(path))
+	}
+
+	return config, namespace, nil
 }
 
-// createPod creates a new pod within a namespaces, with specified image and command to run
-func createPod(cfg Config, command, args []string) (*v1.Pod, error) {
-	clientset, _, err := getKubeClient(cfg.Kubeconfig)
+// tokenFile returns the token file of the user of the kubeconfig, if it has one
+// and no static token
+func tokenFile(clientConfig clientcmd.ClientConfig, overrides *clientcmd.ConfigOverrides) string {
+	raw, err := clientConfig.RawConfig()
 	if err != nil {
-		log.Fatalf("cannot get clientset: %v", err)
+		return ""
+	}
+
+	name := overrides.CurrentContext
+	if name == "" {
+		name = raw.CurrentContext
+	}
+	user := overrides.Context.AuthInfo
+	if c, ok := raw.Contexts[name]; ok && user == "" {
+		user = c.AuthInfo
 	}
 
-	// convert to Kubernetes API env var from secret
-	// TODO - make this part generic and add volume mount secret support
-	env := []v1.EnvVar{}
-	for _, s := range cfg.Secrets {
-		env = append(env, v1.EnvVar{
-			Name: s.EnvVarName,
-			ValueFrom: &v1.EnvVarSource{
-				SecretKeyRef: &v1.SecretKeySelector{
-					LocalObjectReference: v1.LocalObjectReference{
-						Name: s.SecretName,
-					},
-					Key: s.SecretKey,
-				},
-			},
-		})
+	auth, ok := raw.AuthInfos[user]
+	if !ok || auth.Token != "" || overrides.AuthInfo.Token != "" {
+		return ""
 	}
 
-	podsClient := clientset.CoreV1().Pods(cfg.Namespace)
-	return podsClient.Create(&v1.Pod{
+	return auth.TokenFile
+}
+
+// inClusterNamespacePath and inClusterTokenPath are the files holding the
+// namespace and the token of the service account of a pod
+const (
+	inClusterNamespacePath = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+	inClusterTokenPath     = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+)
+
+// ensureNamespace checks that the namespace exists, and creates it with the given
+// labels if it does not and create is set. If the namespace cannot be read, for
+// lack of permissions, it is assumed to exist.
+func (c *Client) ensureNamespace(name string, create bool, labels map[string]string) error {
+	_, err := c.clientset.CoreV1().Namespaces().Get(name, metav1.GetOptions{})
+	if err == nil || apierrors.IsForbidden(err) {
+		return nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return fmt.Errorf("cannot get namespace %s: %w", name, err)
+	}
+	if !create {
+		return &Error{Kind: ErrNamespaceNotFound, Err: err}
+	}
 
+	_, err = c.clientset.CoreV1().Namespaces().Create(&v1.Namespace{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: cfg.Name,
-		},
-		Spec: v1.PodSpec{
-			Containers: []v1.Container{
-				{
-					TTY:   false,
-					Stdin: true,
-
-					Name:    cfg.Name,
-					Image:   cfg.Image,
-					Command: command,
-					Args:    args,
-					SecurityContext: &v1.SecurityContext{
-						Privileged: boolPtr(false),
-					},
-					ImagePullPolicy: v1.PullPolicy(v1.PullAlways),
-					Env:             env,
-					VolumeMounts:    []v1.VolumeMount{},
-				},
-			},
-			RestartPolicy:    v1.RestartPolicyOnFailure,
-			Volumes:          []v1.Volume{},
-			ImagePullSecrets: []v1.LocalObjectReference{},
+			Name:   name,
+			Labels: labels,
 		},
 	})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("cannot create namespace %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// getPod returns a pod, given a namespace and pod name
+func (c *Client) getPod(namespace, name string) (*v1.Pod, error) {
+	podsClient := c.clientset.CoreV1().Pods(namespace)
+
+	return podsClient.Get(name, metav1.GetOptions{})
+}
+
+// refreshPod returns the current state of the given pod, or a NotFound error if
+// it was deleted, even if another pod was created with its name since
+func (c *Client) refreshPod(pod *v1.Pod) (*v1.Pod, error) {
+	current, err := c.getPod(pod.Namespace, pod.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !samePod(pod, current) {
+		return nil, apierrors.NewNotFound(v1.Resource("pods"), pod.Name)
+	}
+
+	return current, nil
+}
+
+// samePod reports whether b is the same pod as a, and not another pod created
+// with its name, when the UIDs of both are known
+func samePod(a, b *v1.Pod) bool {
+	return a.Name == b.Name && (a.UID == "" || b.UID == "" || a.UID == b.UID)
+}
+
+// deleteOptions returns the options to delete the object with the given UID,
+// and not another one created with its name since, if the UID is known
+func deleteOptions(uid types.UID, gracePeriod *int64) *metav1.DeleteOptions {
+	opts := &metav1.DeleteOptions{GracePeriodSeconds: gracePeriod}
+	if uid != "" {
+		opts.Preconditions = metav1.NewUIDPreconditions(string(uid))
+	}
+
+	return opts
+}
+
+// createPod creates the given pod within the namespace, as the given field
+// manager if not empty. If dryRun is set, the pod is validated and defaulted by
+// the server, but not persisted.
+func (c *Client) createPod(ctx context.Context, namespace string, pod *v1.Pod, fieldManager string, dryRun bool) (*v1.Pod, error) {
+	// clientsets other than the REST one, such as fakes, only have typed clients
+	if c.config == nil {
+		if dryRun {
+			return nil, &Error{Kind: ErrPodCreate, Err: fmt.Errorf("dry run %v", errNoREST)}
+		}
+		result, err := c.clientset.CoreV1().Pods(namespace).Create(pod)
+		if err != nil {
+			return nil, &Error{Kind: ErrPodCreate, Err: err}
+		}
+		return result, nil
+	}
+
+	// the typed pods client does not take a context, so go through the REST client
+	req := c.podsREST().Post().
+		Context(ctx).
+		Namespace(namespace).
+		Resource("pods").
+		VersionedParams(createOptions(dryRun), c.parameterCodec()).
+		Body(pod)
+	if fieldManager != "" {
+		req = req.Param("fieldManager", fieldManager)
+	}
+
+	result := &v1.Pod{}
+	err := req.Do().Into(result)
+	if err != nil {
+		return nil, &Error{Kind: ErrPodCreate, Err: err}
+	}
+
+	return result, nil
+}
+
+// deletePod deletes the given pod, with an optional grace period in seconds. If
+// the pod was deleted, and another one created with its name, it returns a
+// NotFound error, and the other pod is not deleted.
+func (c *Client) deletePod(pod *v1.Pod, gracePeriod *int64) error {
+	err := c.clientset.CoreV1().Pods(pod.Namespace).Delete(pod.Name, deleteOptions(pod.UID, gracePeriod))
+	if apierrors.IsConflict(err) {
+		return apierrors.NewNotFound(v1.Resource("pods"), pod.Name)
+	}
+
+	return err
 }
 
 // containerToAttach returns a reference to the container to attach to, given
@@ -120,78 +240,584 @@ func containerToAttachTo(container string, pod *v1.Pod) (*v1.Container, error) {
 	return &pod.Spec.Containers[0], nil
 }
 
+// createOptions returns the options to create an object, with a server-side
+// dry run if dryRun is set
+func createOptions(dryRun bool) *metav1.CreateOptions {
+	if dryRun {
+		return &metav1.CreateOptions{DryRun: []string{metav1.DryRunAll}}
+	}
+	return &metav1.CreateOptions{}
+}
+
+// containerName returns the name of the container to attach to, given by name or
+// the first container if name is empty. Names that are not in the spec are
+// returned as is, as they may be ephemeral containers, which are not part of
+// the API types of the package and are validated by the server.
+func containerName(name string, pod *v1.Pod) (string, error) {
+	if name != "" {
+		return name, nil
+	}
+
+	c, err := containerToAttachTo("", pod)
+	if err != nil {
+		return "", err
+	}
+
+	return c.Name, nil
+}
+
 // attach attaches to a given pod, outputting to stdout and stderr
-func attach(kubeconfig string, pod *v1.Pod, attachOptions *v1.PodAttachOptions, stdin io.Reader, stdout, stderr io.Writer) error {
-	clientset, config, err := getKubeClient(kubeconfig)
+func (c *Client) attach(ctx context.Context, pod *v1.Pod, attachOptions *v1.PodAttachOptions, stdin io.Reader, stdout, stderr io.Writer, sizeQueue remotecommand.TerminalSizeQueue, keepalive time.Duration) error {
+	container, err := containerName(attachOptions.Container, pod)
 	if err != nil {
-		log.Fatalf("cannot get clientset: %v", err)
+		return &Error{Kind: ErrAttach, Err: err}
 	}
+	attachOptions.Container = container
+
+	streamOptions := getStreamOptions(attachOptions, stdin, stdout, stderr)
+	streamOptions.TerminalSizeQueue = sizeQueue
+
+	if c.stream != nil {
+		err = c.stream(ctx, streamRequest(pod, "attach", container, nil, streamOptions))
+	} else {
+		req := c.podsREST().Post().
+			Resource("pods").
+			Name(pod.Name).
+			Namespace(pod.Namespace).
+			SubResource("attach")
 
-	container, err := containerToAttachTo("", pod)
+		req.VersionedParams(attachOptions, c.parameterCodec())
+
+		err = c.startStream(ctx, "POST", req.URL(), streamOptions, keepalive)
+	}
 	if err != nil {
-		return fmt.Errorf("cannot get container to attach to: %v", err)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return &Error{Kind: ErrAttach, Err: err}
 	}
 
-	req := clientset.CoreV1().RESTClient().Post().
+	return nil
+}
+
+// execInPod executes a command in a given pod, outputting to stdout and stderr
+func (c *Client) execInPod(ctx context.Context, pod *v1.Pod, execOptions *v1.PodExecOptions, stdin io.Reader, stdout, stderr io.Writer, sizeQueue remotecommand.TerminalSizeQueue, keepalive time.Duration) error {
+	streamOptions := getExecStreamOptions(execOptions, stdin, stdout, stderr)
+	streamOptions.TerminalSizeQueue = sizeQueue
+
+	if c.stream != nil {
+		return c.stream(ctx, streamRequest(pod, "exec", execOptions.Container, execOptions.Command, streamOptions))
+	}
+
+	req := c.podsREST().Post().
 		Resource("pods").
 		Name(pod.Name).
 		Namespace(pod.Namespace).
-		SubResource("attach")
+		SubResource("exec")
 
-	attachOptions.Container = container.Name
-	req.VersionedParams(attachOptions, scheme.ParameterCodec)
+	req.VersionedParams(execOptions, c.parameterCodec())
 
-	streamOptions := getStreamOptions(attachOptions, stdin, stdout, stderr)
+	err := c.startStream(ctx, "POST", req.URL(), streamOptions, keepalive)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
 
-	err = startStream("POST", req.URL(), config, streamOptions)
-	if err != nil {
-		return fmt.Errorf("error executing: %v", err)
+	if exitErr, ok := err.(utilexec.ExitError); ok && exitErr.Exited() {
+		return &ExitError{Code: exitErr.ExitStatus()}
 	}
 
-	return nil
+	return err
 }
 
-func startStream(method string, url *url.URL, config *restclient.Config, streamOptions remotecommand.StreamOptions) error {
-	exec, err := remotecommand.NewSPDYExecutor(config, method, url)
+// streamLogs copies the logs of the pod to w, with the given options. If the
+// options are nil, the logs of the first container are copied.
+func (c *Client) streamLogs(ctx context.Context, pod *v1.Pod, opts *v1.PodLogOptions, w io.Writer) error {
+	if opts == nil {
+		opts = &v1.PodLogOptions{}
+	}
+
+	// fake clientsets have no REST client to stream from
+	if rc, ok := c.clientset.CoreV1().RESTClient().(*restclient.RESTClient); ok && rc == nil {
+		return errNoREST
+	}
+
+	logs, err := c.clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, opts).Context(ctx).Stream()
 	if err != nil {
 		return err
 	}
+	defer logs.Close()
 
-	return exec.Stream(streamOptions)
+	_, err = io.Copy(w, logs)
+	return err
 }
 
-// waitPod waits until the created pod is in running state
-func waitPod(kubeconfig string, pod *v1.Pod) {
-	clientset, _, err := getKubeClient(kubeconfig)
+// startStream starts streaming to and from the given URL, and returns when
+// the stream ends or the context is done.
+//
+// The executor cannot be interrupted, so when the context is done the stream is
+// abandoned and torn down once the remote end closes it (i.e. the pod is deleted).
+//
+// If keepalive is not zero, TCP keepalives are sent on the connection with that
+// period, and with a terminal size queue, the last size is sent again, so idle
+// streams are not closed by proxies and load balancers.
+func (c *Client) startStream(ctx context.Context, method string, url *url.URL, streamOptions remotecommand.StreamOptions, keepalive time.Duration) error {
+	// SPDY pings, which would keep the stream itself alive, are only available
+	// from apimachinery v0.20
+	transport, upgrader, err := c.roundTripperFor(keepalive)
 	if err != nil {
-		log.Fatalf("cannot get clientset: %v", err)
+		return err
 	}
 
-	stop := newStopChan()
+	exec, err := remotecommand.NewSPDYExecutorForTransports(transport, upgrader, method, url)
+	if err != nil {
+		return err
+	}
 
-	watchlist := cache.NewListWatchFromClient(clientset.CoreV1().RESTClient(), "pods", pod.Namespace, fields.Everything())
-	_, controller := cache.NewInformer(watchlist, &v1.Pod{}, time.Second*1, cache.ResourceEventHandlerFuncs{
-		UpdateFunc: func(o, n interface{}) {
-			newPod := n.(*v1.Pod)
+	if keepalive > 0 && streamOptions.TerminalSizeQueue != nil {
+		stop := make(chan struct{})
+		defer close(stop)
+		streamOptions.TerminalSizeQueue = repeatSizes(streamOptions.TerminalSizeQueue, keepalive, stop)
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- exec.Stream(streamOptions)
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
-			// not the pod we created
-			if newPod.Name != pod.Name {
+// repeatSizes returns a terminal size queue sending the sizes of q, and the last
+// size again every period, as traffic keeping the stream open
+func repeatSizes(q remotecommand.TerminalSizeQueue, period time.Duration, stop <-chan struct{}) remotecommand.TerminalSizeQueue {
+	sizes := make(chan *remotecommand.TerminalSize)
+	go func() {
+		defer close(sizes)
+		for size := q.Next(); size != nil; size = q.Next() {
+			select {
+			case sizes <- size:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	r := &sizeQueue{c: make(chan remotecommand.TerminalSize)}
+	go func() {
+		defer close(r.c)
+
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+
+		var last *remotecommand.TerminalSize
+		for {
+			select {
+			case size, ok := <-sizes:
+				if !ok {
+					return
+				}
+				last = size
+			case <-ticker.C:
+				if last == nil {
+					continue
+				}
+			case <-stop:
 				return
 			}
 
-			// if the pod is running, stop watching and continue with the cmd execution
-			if newPod.Status.Phase == v1.PodRunning {
+			select {
+			case r.c <- *last:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return r
+}
+
+// podListWatch returns a list watch of the pods of the namespace, with the list
+// options set by tweak. It goes through the typed client, which unlike the REST
+// client is also implemented by fake clientsets.
+func (c *Client) podListWatch(namespace string, tweak func(*metav1.ListOptions)) *cache.ListWatch {
+	pods := c.clientset.CoreV1().Pods(namespace)
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			tweak(&options)
+			return pods.List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			tweak(&options)
+			return pods.Watch(options)
+		},
+	}
+}
+
+// waitPod waits until the created pod satisfies the given condition and returns
+// the last observed state of the pod, or returns the context error if the context
+// is done first. If fail is not nil and returns an error for the pod before the
+// condition is met, waiting stops and the error is returned.
+func (c *Client) waitPod(ctx context.Context, pod *v1.Pod, cond func(*v1.Pod) bool, fail func(*v1.Pod) error) (*v1.Pod, error) {
+	stop := newStopChan()
+	last := pod
+	var failErr error
+
+	// only watch the pod we created
+	watchlist := c.podListWatch(pod.Namespace, func(options *metav1.ListOptions) {
+		options.FieldSelector = fields.OneTermEqualSelector("metadata.name", pod.Name).String()
+	})
+	var check func(obj interface{}) bool
+
+	// gone stops waiting for a deleted pod, unless it satisfies the condition
+	gone := func(deleted *v1.Pod) {
+		// the pod will not change anymore
+		if deleted.DeletionTimestamp == nil {
+			deleted = deleted.DeepCopy()
+			now := metav1.Now()
+			deleted.DeletionTimestamp = &now
+		}
+		if !check(deleted) {
+			last = deleted
+			failErr = fmt.Errorf("pod %s was deleted", pod.Name)
+			stop.closeOnce()
+		}
+	}
+
+	check = func(obj interface{}) bool {
+		newPod := obj.(*v1.Pod)
+
+		// fake clientsets ignore field selectors
+		if newPod.Name != pod.Name {
+			return false
+		}
+
+		// another pod created with the name of the deleted pod, observed
+		// without the deletion in between
+		if !samePod(pod, newPod) {
+			gone(last)
+			return true
+		}
+
+		// if the condition is met, stop watching and continue with the cmd execution
+		if cond(newPod) {
+			last = newPod
+			stop.closeOnce()
+			return true
+		}
+
+		if fail != nil {
+			if err := fail(newPod); err != nil {
+				last = newPod
+				failErr = err
 				stop.closeOnce()
+				return true
+			}
+		}
+
+		return false
+	}
+
+	_, controller := cache.NewInformer(watchlist, &v1.Pod{}, time.Second*1, cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			check(obj)
+		},
+		UpdateFunc: func(o, n interface{}) {
+			check(n)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if d, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = d.Obj
+			}
+			deleted, ok := obj.(*v1.Pod)
+			if !ok || !samePod(pod, deleted) {
 				return
 			}
+
+			gone(deleted)
 		},
 	})
 
+	go func() {
+		select {
+		case <-ctx.Done():
+			stop.closeOnce()
+		case <-stop.c:
+		}
+	}()
+
 	controller.Run(stop.c)
+
+	if failErr != nil {
+		return last, failErr
+	}
+
+	return last, ctx.Err()
+}
+
+// lastWarning returns the most recent warning event about the pod, or an empty
+// string if there is none or the events cannot be listed
+func (c *Client) lastWarning(pod *v1.Pod) string {
+	events, err := c.podEvents(pod)
+	if err != nil {
+		return ""
+	}
+
+	var last *v1.Event
+	for i := range events {
+		e := &events[i]
+		if e.Type != v1.EventTypeWarning {
+			continue
+		}
+		if last == nil || last.LastTimestamp.Before(&e.LastTimestamp) {
+			last = e
+		}
+	}
+	if last == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%s: %s", last.Reason, last.Message)
+}
+
+// podEvents returns the events about the pod
+func (c *Client) podEvents(pod *v1.Pod) ([]v1.Event, error) {
+	selector := fields.Set{
+		"involvedObject.name": pod.Name,
+		"involvedObject.uid":  string(pod.UID),
+	}.AsSelector().String()
+
+	events, err := c.clientset.CoreV1().Events(pod.Namespace).List(metav1.ListOptions{FieldSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+
+	// fake clientsets ignore field selectors
+	items := events.Items[:0]
+	for _, e := range events.Items {
+		if e.InvolvedObject.UID == pod.UID {
+			items = append(items, e)
+		}
+	}
+
+	return items, nil
+}
+
+// fatalWaitingReasons are the reasons of a waiting container that will not
+// start without intervention: its image cannot be pulled, or it cannot be
+// created or keeps crashing
+var fatalWaitingReasons = unionReasons(pullReasons, map[string]bool{
+	"CrashLoopBackOff":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+})
+
+// unionReasons returns the reasons in any of the given sets
+func unionReasons(sets ...map[string]bool) map[string]bool {
+	union := map[string]bool{}
+	for _, set := range sets {
+		for reason := range set {
+			union[reason] = true
+		}
+	}
+	return union
+}
+
+// podStartFailure returns an error if the pod failed, cannot be scheduled, or
+// has a container that cannot start
+func podStartFailure(pod *v1.Pod) error {
+	if err := deadlineExceeded(pod.Status.Reason, pod.Status.Message, pod.Spec.ActiveDeadlineSeconds); err != nil {
+		return err
+	}
+	if err := podEvicted(pod); err != nil {
+		return err
+	}
+
+	switch pod.Status.Phase {
+	case v1.PodFailed, v1.PodUnknown:
+		return fmt.Errorf("pod is %s: %s", pod.Status.Phase, pod.Status.Message)
+	}
+
+	for _, c := range pod.Status.Conditions {
+		if c.Type == v1.PodScheduled && c.Status == v1.ConditionFalse && c.Reason == v1.PodReasonUnschedulable {
+			return fmt.Errorf("pod is unschedulable: %s", c.Message)
+		}
+	}
+
+	if err := initFailure(pod); err != nil {
+		return err
+	}
+	if err := imagePullFailure(pod); err != nil {
+		return err
+	}
+
+	for _, s := range pod.Status.ContainerStatuses {
+		if w := s.State.Waiting; w != nil && fatalWaitingReasons[w.Reason] {
+			return fmt.Errorf("container %s cannot start (%s): %s", s.Name, w.Reason, w.Message)
+		}
+	}
+
+	return nil
+}
+
+// initFailure returns an *InitError if an init container of the pod failed, or
+// cannot start
+func initFailure(pod *v1.Pod) error {
+	for _, s := range pod.Status.InitContainerStatuses {
+		if w := s.State.Waiting; w != nil && fatalWaitingReasons[w.Reason] {
+			// a crashing init container is reported with its last exit code
+			if t := s.LastTerminationState.Terminated; t != nil && t.ExitCode != 0 {
+				return &InitError{Container: s.Name, Code: int(t.ExitCode), Reason: t.Reason, Message: t.Message, Results: initResults(pod)}
+			}
+			return &InitError{Container: s.Name, Code: -1, Reason: w.Reason, Message: w.Message, Results: initResults(pod)}
+		}
+
+		t := s.State.Terminated
+		if t == nil {
+			t = s.LastTerminationState.Terminated
+		}
+		if t != nil && t.ExitCode != 0 {
+			return &InitError{Container: s.Name, Code: int(t.ExitCode), Reason: t.Reason, Message: t.Message, Results: initResults(pod)}
+		}
+	}
+
+	return nil
+}
+
+// podInitialized reports whether all the init containers of the pod completed
+func podInitialized(pod *v1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == v1.PodInitialized {
+			return c.Status == v1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+// podScheduled reports whether the pod is scheduled on a node, or completed
+func podScheduled(pod *v1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == v1.PodScheduled && c.Status == v1.ConditionTrue {
+			return true
+		}
+	}
+
+	return podCompleted(pod)
+}
+
+// podRunning reports whether the pod is in running state
+func podRunning(pod *v1.Pod) bool {
+	return pod.Status.Phase == v1.PodRunning
+}
+
+// containerRunning returns a condition satisfied when the named container, or
+// the container of the command if empty, runs, or once the pod completed
+func containerRunning(name string) func(*v1.Pod) bool {
+	return func(pod *v1.Pod) bool {
+		s := containerStatus(pod, name)
+		return podCompleted(pod) || (s != nil && s.State.Running != nil)
+	}
+}
+
+// containerReady returns a condition satisfied when the named container, or
+// the container of the command if empty, is ready, or once the pod completed
+func containerReady(name string) func(*v1.Pod) bool {
+	return func(pod *v1.Pod) bool {
+		s := containerStatus(pod, name)
+		return podCompleted(pod) || (s != nil && s.State.Running != nil && s.Ready)
+	}
+}
+
+// containerStatus returns the status of the named container of the pod, or of
+// the container of the command if empty, or nil if it is not known yet
+func containerStatus(pod *v1.Pod, name string) *v1.ContainerStatus {
+	if name == "" {
+		return commandStatus(pod)
+	}
+
+	for i := range pod.Status.ContainerStatuses {
+		if pod.Status.ContainerStatuses[i].Name == name {
+			return &pod.Status.ContainerStatuses[i]
+		}
+	}
+	for i := range pod.Status.InitContainerStatuses {
+		if pod.Status.InitContainerStatuses[i].Name == name {
+			return &pod.Status.InitContainerStatuses[i]
+		}
+	}
+
+	return nil
+}
+
+// podStarted reports whether the pod is running, or has already run
+func podStarted(pod *v1.Pod) bool {
+	return podRunning(pod) || podCompleted(pod)
+}
+
+// podCompleted reports whether the pod finished, or the container running the
+// command terminated at least once, even if sidecars are still running
+func podCompleted(pod *v1.Pod) bool {
+	if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+		return true
+	}
+
+	return terminatedState(pod) != nil
+}
+
+// terminatedState returns the most recent terminated state of the first container
+// of the pod, or nil if the container has not terminated yet
+func terminatedState(pod *v1.Pod) *v1.ContainerStateTerminated {
+	s := commandStatus(pod)
+	if s == nil {
+		return nil
+	}
+	if s.State.Terminated != nil {
+		return s.State.Terminated
+	}
+
+	return s.LastTerminationState.Terminated
+}
+
+// commandStatus returns the status of the first container of the pod, which
+// runs the command, or nil if it is not known yet
+func commandStatus(pod *v1.Pod) *v1.ContainerStatus {
+	if len(pod.Spec.Containers) == 0 {
+		return nil
+	}
+
+	for i := range pod.Status.ContainerStatuses {
+		if pod.Status.ContainerStatuses[i].Name == pod.Spec.Containers[0].Name {
+			return &pod.Status.ContainerStatuses[i]
+		}
+	}
+
+	return nil
+}
+
+// streamRequest returns the request of a stream to the stream func of a client
+func streamRequest(pod *v1.Pod, subresource, container string, command []string, opts remotecommand.StreamOptions) StreamRequest {
+	return StreamRequest{
+		Pod:         pod,
+		Container:   container,
+		Subresource: subresource,
+		Command:     command,
+		Stdin:       opts.Stdin,
+		Stdout:      opts.Stdout,
+		Stderr:      opts.Stderr,
+		TTY:         opts.Tty,
+		SizeQueue:   opts.TerminalSizeQueue,
+	}
 }
 
 func getStreamOptions(attachOptions *v1.PodAttachOptions, stdin io.Reader, stdout, stderr io.Writer) remotecommand.StreamOptions {
-	var streamOptions remotecommand.StreamOptions
+	streamOptions := remotecommand.StreamOptions{
+		Tty: attachOptions.TTY,
+	}
 	if attachOptions.Stdin {
 		streamOptions.Stdin = stdin
 	}
@@ -207,6 +833,26 @@ func getStreamOptions(attachOptions *v1.PodAttachOptions, stdin io.Reader, stdou
 	return streamOptions
 }
 
+func getExecStreamOptions(execOptions *v1.PodExecOptions, stdin io.Reader, stdout, stderr io.Writer) remotecommand.StreamOptions {
+	streamOptions := remotecommand.StreamOptions{
+		Tty: execOptions.TTY,
+	}
+
+	if execOptions.Stdin {
+		streamOptions.Stdin = stdin
+	}
+
+	if execOptions.Stdout {
+		streamOptions.Stdout = stdout
+	}
+
+	if execOptions.Stderr {
+		streamOptions.Stderr = stderr
+	}
+
+	return streamOptions
+}
+
 type stopChan struct {
 	c chan struct{}
 	sync.Once
@@ -0,0 +1,399 @@
+// Package kubeexectest provides a fake backend for kube-exec, to test the code
+// running commands without a cluster.
+package kubeexectest
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	exec "github.com/engineerd/kube-exec"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// fakeNode is the node the pods of the backend are scheduled on
+const fakeNode = "kubeexectest"
+
+var (
+	podsResource       = v1.SchemeGroupVersion.WithResource("pods")
+	namespacesResource = v1.SchemeGroupVersion.WithResource("namespaces")
+)
+
+// Result is the scripted result of a command run by the backend.
+type Result struct {
+	Stdout []byte
+	Stderr []byte
+
+	// ExitCode is the exit code of the command. Attached containers terminate
+	// with it, and executions return an *exec.ExitError if it is not zero.
+	ExitCode int
+
+	// Err, if not nil, is returned by the stream instead, as if it failed, and
+	// the container keeps running.
+	Err error
+
+	// Evicted evicts the pod of an attached container once the output is
+	// written, instead of terminating the container: the pod fails with the
+	// reason Evicted, as when its node runs out of resources.
+	Evicted bool
+}
+
+// Run is a command run by the backend, either by attaching to a container or
+// by executing a command in it.
+type Run struct {
+	Pod       *v1.Pod
+	Container string
+
+	// Command is the command and arguments of the attached container, or the
+	// command executed.
+	Command []string
+
+	// Stdin is the input of the command, read until EOF.
+	Stdin []byte
+}
+
+// Backend is a fake cluster for kube-exec. Pods are created in a fake clientset,
+// in which every namespace exists, and marked running right away, and attaching
+// to them or executing commands in them returns scripted results. Jobs, logs, port forwarding and debug containers
+// are not supported. A Backend is safe for concurrent use.
+type Backend struct {
+	// Clientset is the fake clientset of the backend, to add objects such as
+	// secrets to, or to check the actions made.
+	Clientset *fake.Clientset
+
+	// Client is the client of the backend, to set in Config.Client or in
+	// ExecOptions.Client.
+	Client *exec.Client
+
+	// tracker holds the objects of the clientset, which does not expose its own
+	tracker k8stesting.ObjectTracker
+
+	mu      sync.Mutex
+	handler func(Run) Result
+	results map[string]Result
+	pods    []*v1.Pod
+	runs    []Run
+}
+
+// New returns a new backend, whose fake clientset holds the given objects.
+func New(objects ...runtime.Object) *Backend {
+	tracker := k8stesting.NewObjectTracker(scheme.Scheme, scheme.Codecs.UniversalDecoder())
+	for _, obj := range objects {
+		if err := tracker.Add(obj); err != nil {
+			panic(err)
+		}
+	}
+
+	b := &Backend{
+		Clientset: fake.NewSimpleClientset(),
+		tracker:   tracker,
+		results:   map[string]Result{},
+	}
+	b.Clientset.PrependReactor("*", "*", k8stesting.ObjectReaction(tracker))
+	b.Clientset.PrependWatchReactor("*", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		w, err := tracker.Watch(action.GetResource(), action.GetNamespace())
+		if err != nil {
+			return false, nil, err
+		}
+		return true, w, nil
+	})
+	b.Clientset.PrependReactor("get", "namespaces", b.getNamespace)
+	b.Clientset.PrependReactor("create", "pods", b.createPod)
+	b.Client = exec.NewClientForClientset(b.Clientset, b.stream)
+
+	return b
+}
+
+// SetResult sets the result of the commands whose command and arguments, joined
+// with spaces, are command. The commands without a result exit with code 0 and
+// write no output.
+func (b *Backend) SetResult(command string, r Result) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.results[command] = r
+}
+
+// Handle sets a func returning the result of every command, instead of the
+// results set with SetResult.
+func (b *Backend) Handle(h func(run Run) Result) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.handler = h
+}
+
+// Pods returns the pods created, in order, as they were sent to the backend.
+func (b *Backend) Pods() []*v1.Pod {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]*v1.Pod(nil), b.pods...)
+}
+
+// Runs returns the commands run, in order.
+func (b *Backend) Runs() []Run {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]Run(nil), b.runs...)
+}
+
+// getNamespace returns the namespace from the tracker, or a new one if it was
+// not added, as every namespace exists in the backend
+func (b *Backend) getNamespace(action k8stesting.Action) (bool, runtime.Object, error) {
+	name := action.(k8stesting.GetAction).GetName()
+	obj, err := b.tracker.Get(namespacesResource, "", name)
+	if apierrors.IsNotFound(err) {
+		return true, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}, nil
+	}
+
+	return true, obj, err
+}
+
+// createPod records the created pod, and stores it scheduled and running, with
+// its init containers completed. As with the API server, the pod returned is
+// not scheduled yet.
+func (b *Backend) createPod(action k8stesting.Action) (bool, runtime.Object, error) {
+	pod := action.(k8stesting.CreateAction).GetObject().(*v1.Pod).DeepCopy()
+	pod.Namespace = action.GetNamespace()
+	if pod.Name == "" && pod.GenerateName != "" {
+		pod.Name = pod.GenerateName + utilrand.String(5)
+	}
+	pod.UID = uuid.NewUUID()
+	pod.CreationTimestamp = metav1.Now()
+
+	b.mu.Lock()
+	b.pods = append(b.pods, pod.DeepCopy())
+	b.mu.Unlock()
+
+	running := pod.DeepCopy()
+	if running.Spec.NodeName == "" {
+		running.Spec.NodeName = fakeNode
+	}
+	running.Status = runningStatus(running)
+
+	if err := b.tracker.Create(podsResource, running, running.Namespace); err != nil {
+		return true, nil, err
+	}
+
+	pod.Status = v1.PodStatus{Phase: v1.PodPending}
+	return true, pod, nil
+}
+
+// runningStatus returns the status of the pod once its init containers completed
+// and its containers are running
+func runningStatus(pod *v1.Pod) v1.PodStatus {
+	now := metav1.Now()
+	status := v1.PodStatus{
+		Phase:     v1.PodRunning,
+		StartTime: &now,
+		Conditions: []v1.PodCondition{
+			{Type: v1.PodScheduled, Status: v1.ConditionTrue},
+			{Type: v1.PodInitialized, Status: v1.ConditionTrue},
+			{Type: v1.PodReady, Status: v1.ConditionTrue},
+		},
+	}
+
+	for _, c := range pod.Spec.InitContainers {
+		status.InitContainerStatuses = append(status.InitContainerStatuses, v1.ContainerStatus{
+			Name:  c.Name,
+			Image: c.Image,
+			Ready: true,
+			State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{
+				Reason:     "Completed",
+				StartedAt:  now,
+				FinishedAt: now,
+			}},
+		})
+	}
+	for _, c := range pod.Spec.Containers {
+		status.ContainerStatuses = append(status.ContainerStatuses, v1.ContainerStatus{
+			Name:  c.Name,
+			Image: c.Image,
+			Ready: true,
+			State: v1.ContainerState{Running: &v1.ContainerStateRunning{StartedAt: now}},
+		})
+	}
+
+	return status
+}
+
+// stream runs the command of the request, writing its scripted output, and
+// terminates the container attached to with its exit code
+func (b *Backend) stream(ctx context.Context, req exec.StreamRequest) error {
+	run := Run{Pod: req.Pod, Container: req.Container, Command: req.Command}
+	if run.Container == "" && len(req.Pod.Spec.Containers) > 0 {
+		run.Container = req.Pod.Spec.Containers[0].Name
+	}
+	if req.Subresource == "attach" {
+		run.Command = containerCommand(req.Pod, run.Container)
+	}
+
+	if req.Stdin != nil {
+		stdin, err := readAll(ctx, req.Stdin)
+		if err != nil {
+			return err
+		}
+		run.Stdin = stdin
+	}
+
+	result := b.record(run)
+	if result.Err != nil {
+		return result.Err
+	}
+
+	// with a TTY, both outputs go to stdout
+	stderr := req.Stderr
+	if req.TTY {
+		stderr = req.Stdout
+	}
+	if req.Stdout != nil {
+		req.Stdout.Write(result.Stdout)
+	}
+	if stderr != nil {
+		stderr.Write(result.Stderr)
+	}
+
+	if req.Subresource == "exec" {
+		if result.ExitCode != 0 {
+			return &exec.ExitError{Code: result.ExitCode}
+		}
+		return nil
+	}
+
+	if result.Evicted {
+		return b.evict(req.Pod)
+	}
+	return b.terminate(req.Pod, run.Container, result.ExitCode)
+}
+
+// record records the run and returns its result
+func (b *Backend) record(run Run) Result {
+	b.mu.Lock()
+	b.runs = append(b.runs, run)
+	handler := b.handler
+	result := b.results[strings.Join(run.Command, " ")]
+	b.mu.Unlock()
+
+	if handler != nil {
+		return handler(run)
+	}
+
+	return result
+}
+
+// terminate terminates the container of the pod with the exit code, and the pod
+// once none of its containers runs anymore
+func (b *Backend) terminate(pod *v1.Pod, container string, code int) error {
+	obj, err := b.tracker.Get(podsResource, pod.Namespace, pod.Name)
+	if err != nil {
+		return err
+	}
+	current := obj.(*v1.Pod).DeepCopy()
+
+	reason := "Completed"
+	if code != 0 {
+		reason = "Error"
+	}
+
+	running, failed := false, false
+	for i := range current.Status.ContainerStatuses {
+		s := &current.Status.ContainerStatuses[i]
+		if s.Name == container && s.State.Running != nil {
+			s.Ready = false
+			s.State = v1.ContainerState{Terminated: &v1.ContainerStateTerminated{
+				ExitCode:   int32(code),
+				Reason:     reason,
+				StartedAt:  s.State.Running.StartedAt,
+				FinishedAt: metav1.Now(),
+			}}
+		}
+		if s.State.Running != nil {
+			running = true
+		}
+		if t := s.State.Terminated; t != nil && t.ExitCode != 0 {
+			failed = true
+		}
+	}
+
+	if !running {
+		current.Status.Phase = v1.PodSucceeded
+		if failed {
+			current.Status.Phase = v1.PodFailed
+		}
+	}
+
+	return b.tracker.Update(podsResource, current, current.Namespace)
+}
+
+// evict fails the pod as evicted, with its containers killed
+func (b *Backend) evict(pod *v1.Pod) error {
+	obj, err := b.tracker.Get(podsResource, pod.Namespace, pod.Name)
+	if err != nil {
+		return err
+	}
+	current := obj.(*v1.Pod).DeepCopy()
+
+	for i := range current.Status.ContainerStatuses {
+		s := &current.Status.ContainerStatuses[i]
+		if s.State.Running != nil {
+			s.Ready = false
+			s.State = v1.ContainerState{Terminated: &v1.ContainerStateTerminated{
+				ExitCode:   137,
+				Reason:     "Error",
+				StartedAt:  s.State.Running.StartedAt,
+				FinishedAt: metav1.Now(),
+			}}
+		}
+	}
+	current.Status.Phase = v1.PodFailed
+	current.Status.Reason = "Evicted"
+	current.Status.Message = "The node was low on resource: memory."
+
+	return b.tracker.Update(podsResource, current, current.Namespace)
+}
+
+// containerCommand returns the command and arguments of the named container
+func containerCommand(pod *v1.Pod, name string) []string {
+	containers := append(append([]v1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, c := range containers {
+		if c.Name == name {
+			return append(append([]string{}, c.Command...), c.Args...)
+		}
+	}
+
+	return nil
+}
+
+// readAll reads r until EOF, or until the context is done
+func readAll(ctx context.Context, r io.Reader) ([]byte, error) {
+	type result struct {
+		b   []byte
+		err error
+	}
+
+	c := make(chan result, 1)
+	go func() {
+		b, err := ioutil.ReadAll(r)
+		c <- result{b: b, err: err}
+	}()
+
+	select {
+	case res := <-c:
+		return res.b, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
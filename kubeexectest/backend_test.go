@@ -0,0 +1,129 @@
+package kubeexectest
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	exec "github.com/engineerd/kube-exec"
+)
+
+func TestBackendRun(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		result     Result
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{
+			name:       "success",
+			args:       []string{"echo", "hello"},
+			result:     Result{Stdout: []byte("hello\n")},
+			wantStdout: "hello\n",
+		},
+		{
+			name:       "failure",
+			args:       []string{"make", "test"},
+			result:     Result{Stdout: []byte("running\n"), Stderr: []byte("FAIL\n"), ExitCode: 2},
+			wantCode:   2,
+			wantStdout: "running\n",
+			wantStderr: "FAIL\n",
+		},
+		{
+			name: "no result",
+			args: []string{"true"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := New()
+			if tt.result.Stdout != nil || tt.result.Stderr != nil || tt.result.ExitCode != 0 {
+				backend.SetResult(strings.Join(tt.args, " "), tt.result)
+			}
+
+			cfg := exec.Config{Client: backend.Client, Namespace: "test", Name: "run", Image: "busybox"}
+			cmd := exec.Command(cfg, tt.args[0], tt.args[1:]...)
+			var stdout, stderr bytes.Buffer
+			cmd.Stdout = &stdout
+			cmd.Stderr = &stderr
+
+			err := cmd.Run()
+			code := 0
+			var exitErr *exec.ExitError
+			switch {
+			case err == nil:
+			case errors.As(err, &exitErr):
+				code = exitErr.Code
+			default:
+				t.Fatalf("Run() error = %v", err)
+			}
+
+			if code != tt.wantCode {
+				t.Errorf("exit code = %d, want %d", code, tt.wantCode)
+			}
+			if got := stdout.String(); got != tt.wantStdout {
+				t.Errorf("stdout = %q, want %q", got, tt.wantStdout)
+			}
+			if got := stderr.String(); got != tt.wantStderr {
+				t.Errorf("stderr = %q, want %q", got, tt.wantStderr)
+			}
+
+			pods := backend.Pods()
+			if len(pods) != 1 {
+				t.Fatalf("%d pods created, want 1", len(pods))
+			}
+			if pods[0].Namespace != "test" || pods[0].Name != "run" {
+				t.Errorf("pod = %s/%s, want test/run", pods[0].Namespace, pods[0].Name)
+			}
+			c := pods[0].Spec.Containers[0]
+			if c.Image != "busybox" {
+				t.Errorf("image = %q, want busybox", c.Image)
+			}
+			if got := append(append([]string{}, c.Command...), c.Args...); !reflect.DeepEqual(got, tt.args) {
+				t.Errorf("container command = %q, want %q", got, tt.args)
+			}
+
+			runs := backend.Runs()
+			if len(runs) != 1 || !reflect.DeepEqual(runs[0].Command, tt.args) {
+				t.Errorf("runs = %+v, want one run of %q", runs, tt.args)
+			}
+		})
+	}
+}
+
+func TestBackendStdin(t *testing.T) {
+	backend := New()
+	cfg := exec.Config{Client: backend.Client, Namespace: "test", Image: "busybox"}
+	cmd := exec.Command(cfg, "cat")
+	cmd.Stdin = bytes.NewBufferString("input")
+
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	runs := backend.Runs()
+	if len(runs) != 1 || string(runs[0].Stdin) != "input" {
+		t.Errorf("runs = %+v, want one run reading %q", runs, "input")
+	}
+}
+
+func TestBackendHandle(t *testing.T) {
+	backend := New()
+	backend.Handle(func(run Run) Result {
+		return Result{Stdout: []byte(run.Container)}
+	})
+
+	cfg := exec.Config{Client: backend.Client, Namespace: "test", Name: "main", Image: "busybox"}
+	out, err := exec.Command(cfg, "hostname").Output()
+	if err != nil {
+		t.Fatalf("Output() error = %v", err)
+	}
+	if string(out) != "main" {
+		t.Errorf("output = %q, want %q", out, "main")
+	}
+}
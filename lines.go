@@ -0,0 +1,176 @@
+package exec
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultLineBuffer is the number of lines buffered for the line callback
+	// of a command, if not set
+	defaultLineBuffer = 1000
+
+	// maxLineLength is the length a line is split at, so the lines of
+	// commands writing no newline do not grow without bound
+	maxLineLength = 64 * 1024
+)
+
+// Streams of the lines of the output of a command.
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// Line is a line of the output of a command, without its newline.
+type Line struct {
+	// Stream is StreamStdout or StreamStderr.
+	Stream string
+
+	// Time is the time the line was received.
+	Time time.Time
+
+	Text string
+
+	// Restart is the number of times the container of the command was
+	// restarted before the line was written, with a RestartPolicy.
+	Restart int
+}
+
+// lineSink calls a callback with the lines written to its writers, from a
+// single goroutine, through a bounded buffer: writes block while it is full
+type lineSink struct {
+	c       chan Line
+	done    chan struct{}
+	restart int32
+
+	mu      sync.Mutex
+	writers []*lineWriter
+}
+
+func newLineSink(fn func(Line), size int) *lineSink {
+	if size <= 0 {
+		size = defaultLineBuffer
+	}
+
+	s := &lineSink{c: make(chan Line, size), done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		for line := range s.c {
+			fn(line)
+		}
+	}()
+
+	return s
+}
+
+// writer returns a writer sending the lines written to it on the given stream
+func (s *lineSink) writer(stream string) *lineWriter {
+	w := &lineWriter{sink: s, stream: stream}
+
+	s.mu.Lock()
+	s.writers = append(s.writers, w)
+	s.mu.Unlock()
+
+	return w
+}
+
+// Close sends the last incomplete lines, and waits for the callback to be
+// called with all the lines
+func (s *lineSink) Close() error {
+	s.mu.Lock()
+	for _, w := range s.writers {
+		w.flush()
+	}
+	s.mu.Unlock()
+
+	close(s.c)
+	<-s.done
+	return nil
+}
+
+// segment sends the last incomplete lines of the previous container of the
+// command, and marks the next lines with the given number of restarts
+func (s *lineSink) segment(restart int) {
+	s.mu.Lock()
+	for _, w := range s.writers {
+		w.flush()
+	}
+	s.mu.Unlock()
+
+	atomic.StoreInt32(&s.restart, int32(restart))
+}
+
+// lineWriter splits the output of a stream into lines
+type lineWriter struct {
+	sink   *lineSink
+	stream string
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf.Write(p)
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i < 0 {
+			if w.buf.Len() >= maxLineLength {
+				w.send(w.buf.Next(maxLineLength))
+				continue
+			}
+			return len(p), nil
+		}
+
+		line := bytes.TrimSuffix(w.buf.Next(i+1), []byte("\n"))
+		w.send(bytes.TrimSuffix(line, []byte("\r")))
+	}
+}
+
+// flush sends the last incomplete line, if any
+func (w *lineWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.buf.Len() > 0 {
+		w.send(w.buf.Next(w.buf.Len()))
+	}
+}
+
+// send sends a line to the sink, blocking while its buffer is full
+func (w *lineWriter) send(text []byte) {
+	restart := int(atomic.LoadInt32(&w.sink.restart))
+	w.sink.c <- Line{Stream: w.stream, Time: time.Now(), Text: string(text), Restart: restart}
+}
+
+// setupLines sends the output of the command to its line callback, next to
+// Stdout and Stderr if set
+func (cmd *Cmd) setupLines() {
+	lines := newLineSink(cmd.OnLine, cmd.LineBuffer)
+	cmd.lines = lines
+	cmd.closeAfterStream = append(cmd.closeAfterStream, lines)
+
+	stdout, stderr := cmd.Stdout, cmd.Stderr
+	if stdout != nil && sameWriter(stdout, stderr) {
+		// the streams are copied from different goroutines
+		stdout = &lockedWriter{w: stdout}
+		stderr = stdout
+	}
+
+	cmd.Stdout = teeLines(stdout, lines.writer(StreamStdout))
+	cmd.Stderr = teeLines(stderr, lines.writer(StreamStderr))
+}
+
+// teeLines returns a writer writing to w, if not nil, and to the line writer
+func teeLines(w io.Writer, lines *lineWriter) io.Writer {
+	if w == nil {
+		return lines
+	}
+
+	return io.MultiWriter(w, lines)
+}
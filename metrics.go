@@ -0,0 +1,53 @@
+package exec
+
+import (
+	"io"
+	"time"
+)
+
+// Metrics records measurements of command runs, for instance in Prometheus
+// collectors (see the metrics package). All methods must be safe for
+// concurrent use.
+type Metrics interface {
+	// PodCreated is called when the pod, or the job, of a command is created.
+	PodCreated(namespace string)
+
+	// AttachFailed is called when attaching to the pod of a command fails.
+	AttachFailed(namespace string)
+
+	// PodRunning is called when the pod of a command is running, with the
+	// time since it was created.
+	PodRunning(namespace string, d time.Duration)
+
+	// CommandCompleted is called when a command terminates, with its duration
+	// since it was started and its exit code.
+	CommandCompleted(namespace string, d time.Duration, exitCode int)
+
+	// BytesStreamed is called with the number of bytes copied from the stdout
+	// or stderr stream of a command.
+	BytesStreamed(namespace, stream string, n int)
+}
+
+// countingWriter reports the number of bytes written to w to the metrics
+type countingWriter struct {
+	w         io.Writer
+	metrics   Metrics
+	namespace string
+	stream    string
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.metrics.BytesStreamed(c.namespace, c.stream, n)
+	return n, err
+}
+
+// countBytes returns w, reporting the bytes written to it to the metrics of the
+// command, if any
+func (cmd *Cmd) countBytes(stream string, w io.Writer) io.Writer {
+	if cmd.Cfg.Metrics == nil {
+		return w
+	}
+
+	return &countingWriter{w: w, metrics: cmd.Cfg.Metrics, namespace: cmd.Cfg.Namespace, stream: stream}
+}
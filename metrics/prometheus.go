@@ -0,0 +1,94 @@
+// Package metrics records the measurements of kube-exec command runs in
+// Prometheus collectors.
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Prometheus implements exec.Metrics with Prometheus collectors.
+type Prometheus struct {
+	podsCreated      *prometheus.CounterVec
+	attachFailures   *prometheus.CounterVec
+	timeToRunning    *prometheus.HistogramVec
+	commandDuration  *prometheus.HistogramVec
+	commandExitCodes *prometheus.CounterVec
+	bytesStreamed    *prometheus.CounterVec
+}
+
+// NewPrometheus returns the Prometheus collectors for command runs, registered
+// with the given registerer.
+func NewPrometheus(reg prometheus.Registerer) (*Prometheus, error) {
+	p := &Prometheus{
+		podsCreated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kube_exec_pods_created_total",
+			Help: "Number of pods created to run commands.",
+		}, []string{"namespace"}),
+		attachFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kube_exec_attach_failures_total",
+			Help: "Number of failures to attach to the pod of a command.",
+		}, []string{"namespace"}),
+		timeToRunning: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "kube_exec_pod_time_to_running_seconds",
+			Help:    "Time from creating the pod of a command until it is running.",
+			Buckets: prometheus.ExponentialBuckets(0.5, 2, 10),
+		}, []string{"namespace"}),
+		commandDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "kube_exec_command_duration_seconds",
+			Help:    "Time from starting a command until it terminates.",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+		}, []string{"namespace"}),
+		commandExitCodes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kube_exec_commands_completed_total",
+			Help: "Number of commands that terminated, by exit code.",
+		}, []string{"namespace", "exit_code"}),
+		bytesStreamed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kube_exec_streamed_bytes_total",
+			Help: "Number of bytes streamed from the output of commands.",
+		}, []string{"namespace", "stream"}),
+	}
+
+	for _, c := range []prometheus.Collector{
+		p.podsCreated,
+		p.attachFailures,
+		p.timeToRunning,
+		p.commandDuration,
+		p.commandExitCodes,
+		p.bytesStreamed,
+	} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// PodCreated implements exec.Metrics.
+func (p *Prometheus) PodCreated(namespace string) {
+	p.podsCreated.WithLabelValues(namespace).Inc()
+}
+
+// AttachFailed implements exec.Metrics.
+func (p *Prometheus) AttachFailed(namespace string) {
+	p.attachFailures.WithLabelValues(namespace).Inc()
+}
+
+// PodRunning implements exec.Metrics.
+func (p *Prometheus) PodRunning(namespace string, d time.Duration) {
+	p.timeToRunning.WithLabelValues(namespace).Observe(d.Seconds())
+}
+
+// CommandCompleted implements exec.Metrics.
+func (p *Prometheus) CommandCompleted(namespace string, d time.Duration, exitCode int) {
+	p.commandDuration.WithLabelValues(namespace).Observe(d.Seconds())
+	p.commandExitCodes.WithLabelValues(namespace, strconv.Itoa(exitCode)).Inc()
+}
+
+// BytesStreamed implements exec.Metrics.
+func (p *Prometheus) BytesStreamed(namespace, stream string, n int) {
+	p.bytesStreamed.WithLabelValues(namespace, stream).Add(float64(n))
+}
@@ -0,0 +1,109 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Session is a command executed in the pod of a running command, next to it,
+// such as a debug shell next to a long test run, opened by OpenSession
+type Session struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// OpenSession executes a new command in the container of the pod of the
+// command, next to the running command, like Exec, but returns once the
+// session is started rather than once it exits. It waits for the pod to be
+// started, as selected by the WaitFor of the config. Any number of sessions
+// can be open at the same time, each with its own streams and TTY.
+//
+// The sessions are managed by the command: those still open when it
+// terminates are closed before Wait returns, and before its pod is deleted.
+//
+// The command must have been started by Start, and must not run as a job.
+func (cmd *Cmd) OpenSession(command []string, opts ExecOptions) (*Session, error) {
+	if cmd.pod == nil {
+		return nil, errors.New("exec: OpenSession before command started")
+	}
+
+	_, err := cmd.exec.WaitReady(cmd.ctx, cmd.pod, cmd.startCondition(), cmd.startFailure)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(cmd.ctx)
+	s := &Session{cancel: cancel, done: make(chan struct{})}
+
+	cmd.sessionsMu.Lock()
+	if cmd.sessionsClosed {
+		cmd.sessionsMu.Unlock()
+		cancel()
+		return nil, errors.New("exec: OpenSession after command terminated")
+	}
+	if cmd.sessions == nil {
+		cmd.sessions = map[*Session]struct{}{}
+	}
+	cmd.sessions[s] = struct{}{}
+	cmd.sessionsMu.Unlock()
+
+	cmd.log.Info("session opened", "namespace", cmd.pod.Namespace, "pod", cmd.pod.Name, "command", command)
+	go func() {
+		defer close(s.done)
+		defer cancel()
+
+		s.err = cmd.executeContext(ctx, cmd.Container, command, opts)
+		cmd.log.Info("session closed", "namespace", cmd.pod.Namespace, "pod", cmd.pod.Name, "command", command, "error", s.err)
+
+		cmd.sessionsMu.Lock()
+		delete(cmd.sessions, s)
+		cmd.sessionsMu.Unlock()
+	}()
+
+	return s, nil
+}
+
+// Wait waits for the command of the session to exit, and returns its error. If
+// it exits with a non-zero code, the error is an *ExitError.
+func (s *Session) Wait() error {
+	<-s.done
+	return s.err
+}
+
+// Done returns a channel that is closed when the session is over.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close closes the streams of the session and waits for it to be over. The
+// command of the session is not signaled: unless it exits once its input is
+// closed, as shells do, it keeps running in the container.
+func (s *Session) Close() error {
+	s.cancel()
+	<-s.done
+	return nil
+}
+
+// closeSessions closes the sessions still open once the command terminated,
+// and prevents new ones from being opened
+func (cmd *Cmd) closeSessions() {
+	cmd.sessionsMu.Lock()
+	cmd.sessionsClosed = true
+	sessions := make([]*Session, 0, len(cmd.sessions))
+	for s := range cmd.sessions {
+		sessions = append(sessions, s)
+	}
+	cmd.sessionsMu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, s := range sessions {
+		wg.Add(1)
+		go func(s *Session) {
+			defer wg.Done()
+			s.Close()
+		}(s)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,164 @@
+package exec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Pipeline runs commands with the standard output of each one piped to the
+// standard input of the next, like a shell pipeline, across pods which may be
+// in different namespaces or clusters. The standard input of the first command
+// and the standard output of the last one are theirs, and the standard error of
+// every command is its own.
+//
+// Once a command terminates, the standard input of the next one is closed. A
+// command terminating before the previous one does not stop it: the output of
+// the previous command is then discarded, so it must terminate on its own.
+type Pipeline struct {
+	Cmds []*Cmd
+
+	pipes   []*io.PipeReader
+	started bool
+}
+
+// NewPipeline returns a pipeline of the given commands, which must not be
+// started, and must not have their piped Stdin and Stdout set.
+func NewPipeline(cmds ...*Cmd) *Pipeline {
+	return &Pipeline{Cmds: cmds}
+}
+
+// PipelineError reports a pipeline in which some of the commands failed, like
+// the pipefail option of shells.
+type PipelineError struct {
+	// Errors are the errors of the commands, by index, nil for those that
+	// succeeded.
+	Errors []error
+}
+
+func (e *PipelineError) Error() string {
+	var failed []string
+	for i, err := range e.Errors {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("command %d: %v", i, err))
+		}
+	}
+	return fmt.Sprintf("pipeline failed: %s", strings.Join(failed, "; "))
+}
+
+// Start pipes the commands, and starts them. If a command cannot start, those
+// already started are killed, and waited for.
+func (p *Pipeline) Start() error {
+	if p.started {
+		return errors.New("exec: pipeline already started")
+	}
+	if len(p.Cmds) == 0 {
+		return errors.New("exec: empty pipeline")
+	}
+	p.started = true
+
+	for i, cmd := range p.Cmds[1:] {
+		if p.Cmds[i].Stdout != nil {
+			return fmt.Errorf("exec: Stdout of command %d already set", i)
+		}
+		if cmd.Stdin != nil {
+			return fmt.Errorf("exec: Stdin of command %d already set", i+1)
+		}
+	}
+
+	// the pipe to a command is closed once the output of the previous one
+	// is copied
+	for i, cmd := range p.Cmds[1:] {
+		prev := p.Cmds[i]
+		pr, pw := io.Pipe()
+		prev.Stdout = &pipeOutput{pw: pw}
+		prev.closeAfterStream = append(prev.closeAfterStream, pw)
+		cmd.Stdin = pr
+		p.pipes = append(p.pipes, pr)
+	}
+
+	for i, cmd := range p.Cmds {
+		if err := cmd.Start(); err != nil {
+			// nothing reads the output of the last command started
+			if i > 0 {
+				for _, pr := range p.pipes[i-1:] {
+					pr.Close()
+				}
+			}
+			for _, started := range p.Cmds[:i] {
+				started.Signal(syscall.SIGKILL)
+			}
+			p.wait(i)
+			return fmt.Errorf("cannot start command %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// Wait waits for all the commands of the pipeline to terminate. It returns a
+// *PipelineError if any of them failed.
+func (p *Pipeline) Wait() error {
+	if !p.started {
+		return errors.New("exec: pipeline not started")
+	}
+
+	return p.wait(len(p.Cmds))
+}
+
+// Run starts the pipeline and waits for it to complete.
+func (p *Pipeline) Run() error {
+	if err := p.Start(); err != nil {
+		return err
+	}
+
+	return p.Wait()
+}
+
+// wait waits for the first n commands of the pipeline, closing the standard
+// input of the next command once one terminates
+func (p *Pipeline) wait(n int) error {
+	errs := make([]error, len(p.Cmds))
+	var wg sync.WaitGroup
+
+	for i, cmd := range p.Cmds[:n] {
+		wg.Add(1)
+		go func(i int, cmd *Cmd) {
+			defer wg.Done()
+
+			errs[i] = cmd.Wait()
+
+			// the output of the previous command is discarded from now on
+			if i > 0 {
+				p.pipes[i-1].Close()
+			}
+		}(i, cmd)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return &PipelineError{Errors: errs}
+		}
+	}
+
+	return nil
+}
+
+// pipeOutput writes the output of a command to the pipe to the standard input
+// of the next command, and discards it once the next command terminated
+type pipeOutput struct {
+	pw *io.PipeWriter
+}
+
+func (o *pipeOutput) Write(p []byte) (int, error) {
+	n, err := o.pw.Write(p)
+	if err == io.ErrClosedPipe {
+		return len(p), nil
+	}
+	return n, err
+}
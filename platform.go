@@ -0,0 +1,91 @@
+package exec
+
+import (
+	"path"
+	"strings"
+)
+
+const (
+	// OSLinux and OSWindows are the operating systems of the nodes a command
+	// can target with Config.OS.
+	OSLinux   = "linux"
+	OSWindows = "windows"
+
+	// labelOS and labelArch are the labels the kubelet sets on nodes with their
+	// operating system and architecture
+	labelOS   = "kubernetes.io/os"
+	labelArch = "kubernetes.io/arch"
+)
+
+// shellCommand returns the command line running the arguments with the given
+// shell, quoted for cmd.exe and PowerShell on Windows, and for a POSIX shell
+// otherwise
+func shellCommand(shell []string, args []string) string {
+	name := strings.ToLower(path.Base(strings.Replace(shell[0], `\`, "/", -1)))
+	name = strings.TrimSuffix(name, ".exe")
+
+	switch name {
+	case "powershell", "pwsh":
+		return PowerShellQuote(args...)
+	case "cmd":
+		return WindowsQuote(args...)
+	}
+
+	return ShellQuote(args...)
+}
+
+// WindowsQuote returns the arguments as a single command line for a Windows
+// program, each quoted so it is parsed as a single argument by programs using
+// the conventions of the Microsoft C runtime, including cmd.exe /S /C.
+func WindowsQuote(args ...string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = windowsQuote(a)
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// windowsQuote quotes a single argument, unless it has no spaces or quotes,
+// doubling the backslashes that precede a quote
+func windowsQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"") {
+		return s
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+
+	slashes := 0
+	for _, r := range s {
+		switch r {
+		case '\\':
+			slashes++
+			continue
+		case '"':
+			b.WriteString(strings.Repeat(`\`, 2*slashes+1))
+		default:
+			b.WriteString(strings.Repeat(`\`, slashes))
+		}
+		slashes = 0
+		b.WriteRune(r)
+	}
+
+	// the closing quote must not be escaped
+	b.WriteString(strings.Repeat(`\`, 2*slashes))
+	b.WriteByte('"')
+
+	return b.String()
+}
+
+// PowerShellQuote returns the arguments as a single PowerShell command, calling
+// the first argument with the others, each quoted so it is passed as a single
+// argument and without expansions.
+func PowerShellQuote(args ...string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = "'" + strings.Replace(a, "'", "''", -1) + "'"
+	}
+
+	return "& " + strings.Join(quoted, " ")
+}
@@ -0,0 +1,385 @@
+package exec
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// defaultContainerName is the name of the container running the command when
+// neither the pod template nor the config name one
+const defaultContainerName = "exec"
+
+// newPod returns the pod to create for a command
+func newPod(cmd *Cmd) (*v1.Pod, error) {
+	cfg := cmd.Cfg
+	env := envVars(cmd.Env)
+
+	spec := v1.PodSpec{}
+	if cfg.PodTemplate != nil {
+		spec = *cfg.PodTemplate.DeepCopy()
+	}
+
+	if len(spec.Containers) == 0 {
+		spec.Containers = []v1.Container{{}}
+	}
+
+	// the command runs in the first container
+	if cfg.MainContainer != "" {
+		i := containerIndex(spec.Containers, cfg.MainContainer)
+		if i < 0 {
+			return nil, fmt.Errorf("main container %s not found in pod template", cfg.MainContainer)
+		}
+		spec.Containers[0], spec.Containers[i] = spec.Containers[i], spec.Containers[0]
+	}
+	for _, c := range cfg.InitContainers {
+		spec.InitContainers = append(spec.InitContainers, *c.DeepCopy())
+	}
+	for _, s := range cfg.Sidecars {
+		spec.Containers = append(spec.Containers, *s.DeepCopy())
+	}
+
+	// convert to Kubernetes API env var from secret
+	// TODO - make this part generic
+	for _, s := range cfg.Secrets {
+		env = append(env, v1.EnvVar{
+			Name: s.EnvVarName,
+			ValueFrom: &v1.EnvVarSource{
+				SecretKeyRef: &v1.SecretKeySelector{
+					LocalObjectReference: v1.LocalObjectReference{
+						Name: s.SecretName,
+					},
+					Key: s.SecretKey,
+				},
+			},
+		})
+	}
+
+	for _, cm := range cfg.ConfigMaps {
+		env = append(env, v1.EnvVar{
+			Name: cm.EnvVarName,
+			ValueFrom: &v1.EnvVarSource{
+				ConfigMapKeyRef: &v1.ConfigMapKeySelector{
+					LocalObjectReference: v1.LocalObjectReference{
+						Name: cm.ConfigMapName,
+					},
+					Key: cm.ConfigMapKey,
+				},
+			},
+		})
+	}
+
+	env = append(env, cfg.EnvVars...)
+
+	c := &spec.Containers[0]
+	c.TTY = cmd.TTY
+	c.Stdin = true
+
+	// the command reads EOF once the stdin of the first attach is closed
+	c.StdinOnce = cmd.Stdin != nil
+	c.Command = []string{cmd.Path}
+	c.Args = cmd.scriptArgs()
+	if len(cmd.Shell) > 0 {
+		c.Command = cmd.Shell
+		c.Args = []string{shellCommand(cmd.Shell, append([]string{cmd.Path}, cmd.scriptArgs()...))}
+		if cmd.MergeStderr && !cmd.TTY {
+			c.Args[0] += " 2>&1"
+		}
+	}
+	if cmd.Compression != nil {
+		c.Command, c.Args = cmd.Compression.wrap(c.Command, c.Args)
+	}
+	if len(cfg.Artifacts) > 0 {
+		c.Command, c.Args = wrapArtifacts(c.Command, c.Args, cfg.Artifacts)
+	}
+	if cfg.CaptureEnvironment {
+		c.Command, c.Args = wrapCapture(c.Command, c.Args)
+	}
+	if cfg.Helper != nil {
+		c.Command, c.Args = wrapHelper(c.Command, c.Args)
+	}
+	c.Env = append(c.Env, env...)
+	c.EnvFrom = append(c.EnvFrom, cfg.EnvFrom...)
+
+	if c.Name == "" {
+		c.Name = cfg.Name
+	}
+	if c.Name == "" {
+		c.Name = defaultContainerName
+	}
+	if cfg.Image != "" {
+		c.Image = cfg.Image
+	}
+	if cfg.ImageDigest != "" {
+		c.Image = pinImage(c.Image, cfg.ImageDigest)
+	}
+	if cmd.Dir != "" {
+		c.WorkingDir = cmd.Dir
+	}
+	if cfg.SecurityContext != nil {
+		c.SecurityContext = cfg.SecurityContext.DeepCopy()
+	}
+	windows := cfg.OS == OSWindows
+	if c.SecurityContext == nil && !windows {
+		c.SecurityContext = &v1.SecurityContext{
+			Privileged: boolPtr(false),
+		}
+	}
+	if cfg.PodSecurityContext != nil {
+		spec.SecurityContext = cfg.PodSecurityContext.DeepCopy()
+	}
+
+	var annotations map[string]string
+	switch {
+	case cfg.Restricted && windows:
+		// capabilities, privilege escalation and seccomp are Linux only
+		if spec.SecurityContext == nil {
+			spec.SecurityContext = &v1.PodSecurityContext{}
+		}
+		if spec.SecurityContext.RunAsNonRoot == nil {
+			spec.SecurityContext.RunAsNonRoot = boolPtr(true)
+		}
+	case cfg.Restricted:
+		restrict(&spec, c)
+
+		// the seccompProfile field of security contexts is not part of the
+		// API types of the package
+		annotations = map[string]string{
+			v1.SeccompPodAnnotationKey: v1.SeccompProfileRuntimeDefault,
+		}
+	}
+	if cfg.ImagePullPolicy != "" {
+		c.ImagePullPolicy = cfg.ImagePullPolicy
+	}
+	if c.ImagePullPolicy == "" {
+		// an image pinned by digest cannot change
+		c.ImagePullPolicy = v1.PullAlways
+		if strings.Contains(c.Image, "@") {
+			c.ImagePullPolicy = v1.PullIfNotPresent
+		}
+	}
+
+	requests, err := cfg.Requests.resourceList()
+	if err != nil {
+		return nil, err
+	}
+	limits, err := cfg.Limits.resourceList()
+	if err != nil {
+		return nil, err
+	}
+	c.Resources.Requests = mergeResources(c.Resources.Requests, requests)
+	c.Resources.Limits = mergeResources(c.Resources.Limits, limits)
+	for name, q := range c.Resources.Requests {
+		if _, ok := c.Resources.Limits[name]; !ok && !overcommittable(name) {
+			c.Resources.Limits = mergeResources(c.Resources.Limits, v1.ResourceList{name: q})
+		}
+	}
+
+	if cfg.ReadinessProbe != nil {
+		c.ReadinessProbe = cfg.ReadinessProbe.DeepCopy()
+	}
+	if cfg.LivenessProbe != nil {
+		c.LivenessProbe = cfg.LivenessProbe.DeepCopy()
+	}
+
+	addEphemeralSecrets(cmd, &spec, c)
+	addScript(cmd, &spec, c)
+
+	for _, vol := range cfg.Volumes {
+		spec.Volumes = append(spec.Volumes, v1.Volume{
+			Name:         vol.Name,
+			VolumeSource: vol.Source,
+		})
+		c.VolumeMounts = append(c.VolumeMounts, v1.VolumeMount{
+			Name:      vol.Name,
+			MountPath: vol.MountPath,
+			SubPath:   vol.SubPath,
+			ReadOnly:  vol.ReadOnly,
+		})
+	}
+
+	if cfg.ServiceAccountName != "" {
+		spec.ServiceAccountName = cfg.ServiceAccountName
+	}
+	if cfg.AutomountServiceAccountToken != nil {
+		spec.AutomountServiceAccountToken = cfg.AutomountServiceAccountToken
+	}
+	for _, name := range cfg.ImagePullSecrets {
+		spec.ImagePullSecrets = append(spec.ImagePullSecrets, v1.LocalObjectReference{Name: name})
+	}
+
+	if len(cfg.NodeSelector) > 0 && spec.NodeSelector == nil {
+		spec.NodeSelector = map[string]string{}
+	}
+	for k, v := range cfg.NodeSelector {
+		spec.NodeSelector[k] = v
+	}
+	if cfg.OS != "" {
+		spec.NodeSelector = setLabel(spec.NodeSelector, labelOS, cfg.OS)
+	}
+	if cfg.Arch != "" {
+		spec.NodeSelector = setLabel(spec.NodeSelector, labelArch, cfg.Arch)
+	}
+	spec.Tolerations = append(spec.Tolerations, cfg.Tolerations...)
+	if cfg.Affinity != nil {
+		spec.Affinity = cfg.Affinity.DeepCopy()
+	}
+	if cfg.NodeName != "" {
+		spec.NodeName = cfg.NodeName
+	}
+	if h := cfg.Host; h != nil {
+		spec.HostNetwork = h.Network
+		spec.HostPID = h.PID
+		spec.HostIPC = h.IPC
+		if h.Network {
+			// resolve cluster names from the host network too
+			spec.DNSPolicy = v1.DNSClusterFirstWithHostNet
+		}
+
+		for i, p := range h.Paths {
+			name := fmt.Sprintf("host-path-%d", i)
+			spec.Volumes = append(spec.Volumes, v1.Volume{
+				Name: name,
+				VolumeSource: v1.VolumeSource{
+					HostPath: &v1.HostPathVolumeSource{Path: p.Path},
+				},
+			})
+			c.VolumeMounts = append(c.VolumeMounts, v1.VolumeMount{
+				Name:      name,
+				MountPath: p.MountPath,
+				ReadOnly:  p.ReadOnly,
+			})
+		}
+	}
+	if cfg.DNSPolicy != "" {
+		spec.DNSPolicy = cfg.DNSPolicy
+	}
+	if cfg.DNSConfig != nil {
+		spec.DNSConfig = cfg.DNSConfig.DeepCopy()
+	}
+	spec.HostAliases = append(spec.HostAliases, cfg.HostAliases...)
+	if cfg.RuntimeClassName != "" {
+		runtimeClass := cfg.RuntimeClassName
+		spec.RuntimeClassName = &runtimeClass
+	}
+	if cfg.PriorityClassName != "" {
+		spec.PriorityClassName = cfg.PriorityClassName
+	}
+
+	if cfg.ActiveDeadlineSeconds != nil {
+		spec.ActiveDeadlineSeconds = cfg.ActiveDeadlineSeconds
+	}
+
+	// last, as the containers of the spec may be reallocated
+	if needsArtifactsVolume(&cfg) {
+		addArtifacts(&spec, c)
+		c = &spec.Containers[0]
+	}
+	if cfg.Helper != nil {
+		addHelper(&spec, c, cfg.Helper)
+	}
+	if cfg.Workspace != nil {
+		if err := addWorkspace(&spec, c, cfg.Workspace); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.RestartPolicy != "" {
+		spec.RestartPolicy = cfg.RestartPolicy
+	}
+	if spec.RestartPolicy == "" {
+		spec.RestartPolicy = v1.RestartPolicyNever
+	}
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            cfg.Name,
+			GenerateName:    cfg.GenerateName,
+			Labels:          runLabels(cmd.runID),
+			Annotations:     annotations,
+			OwnerReferences: cfg.OwnerReferences,
+		},
+		Spec: spec,
+	}
+
+	for _, mutate := range cfg.Mutators {
+		if err := mutate(pod); err != nil {
+			return nil, fmt.Errorf("cannot mutate pod: %w", err)
+		}
+	}
+
+	return pod, nil
+}
+
+// restrict sets the fields of the security contexts of the pod and of the
+// container required by the "restricted" Pod Security Standard, on top of
+// the fields already set
+func restrict(spec *v1.PodSpec, c *v1.Container) {
+	if spec.SecurityContext == nil {
+		spec.SecurityContext = &v1.PodSecurityContext{}
+	}
+	if spec.SecurityContext.RunAsNonRoot == nil {
+		spec.SecurityContext.RunAsNonRoot = boolPtr(true)
+	}
+
+	sc := c.SecurityContext
+	sc.Privileged = boolPtr(false)
+	sc.AllowPrivilegeEscalation = boolPtr(false)
+	if sc.Capabilities == nil {
+		sc.Capabilities = &v1.Capabilities{}
+	}
+	sc.Capabilities.Drop = []v1.Capability{"ALL"}
+}
+
+// containerIndex returns the index of the named container, or -1
+func containerIndex(containers []v1.Container, name string) int {
+	for i := range containers {
+		if containers[i].Name == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// setLabel sets a label in the given labels, allocating them if needed
+func setLabel(labels map[string]string, key, value string) map[string]string {
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[key] = value
+
+	return labels
+}
+
+// pinImage returns the image reference pinned to the given digest, replacing
+// its tag or digest, if any
+func pinImage(image, digest string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+
+	// a colon after the last slash starts the tag, others are a registry port
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+
+	return image + "@" + digest
+}
+
+// mergeResources sets the resources of src in dst, allocating dst if needed
+func mergeResources(dst, src v1.ResourceList) v1.ResourceList {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = v1.ResourceList{}
+	}
+	for name, q := range src {
+		dst[name] = q
+	}
+
+	return dst
+}
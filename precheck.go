@@ -0,0 +1,256 @@
+package exec
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// podSecurityEnforceLabel is the label of namespaces with the Pod Security
+// Standard enforced by the PodSecurity admission controller
+const podSecurityEnforceLabel = "pod-security.kubernetes.io/enforce"
+
+// precheck checks that a pod with the given spec would be admitted in the
+// namespace: that it fits in the resource quotas and in the limit ranges, and
+// satisfies the Pod Security Standard enforced, if any. The objects it cannot
+// read, for lack of permissions, are not checked.
+func (c *Client) precheck(namespace string, spec *v1.PodSpec) error {
+	spec = spec.DeepCopy()
+
+	var problems []string
+
+	limitRanges, err := c.clientset.CoreV1().LimitRanges(namespace).List(metav1.ListOptions{})
+	if err == nil {
+		for _, lr := range limitRanges.Items {
+			problems = append(problems, checkLimitRange(&lr, spec)...)
+		}
+	}
+
+	quotas, err := c.clientset.CoreV1().ResourceQuotas(namespace).List(metav1.ListOptions{})
+	if err == nil {
+		requests, limits := podResources(spec)
+		for _, q := range quotas.Items {
+			problems = append(problems, checkQuota(&q, requests, limits)...)
+		}
+	}
+
+	ns, err := c.clientset.CoreV1().Namespaces().Get(namespace, metav1.GetOptions{})
+	if err == nil {
+		problems = append(problems, checkPodSecurity(ns.Labels[podSecurityEnforceLabel], spec)...)
+	}
+
+	if len(problems) > 0 {
+		return &Error{Kind: ErrAdmission, Err: fmt.Errorf("%s", strings.Join(problems, "; "))}
+	}
+
+	return nil
+}
+
+// checkLimitRange applies the defaults of the limit range to the containers of
+// the spec, as the LimitRanger admission controller does, and returns the
+// constraints of the limit range they do not satisfy
+func checkLimitRange(lr *v1.LimitRange, spec *v1.PodSpec) []string {
+	var problems []string
+
+	for _, item := range lr.Spec.Limits {
+		switch item.Type {
+		case v1.LimitTypeContainer:
+			for i := range spec.Containers {
+				c := &spec.Containers[i]
+				for name, q := range item.Default {
+					if _, ok := c.Resources.Limits[name]; !ok {
+						c.Resources.Limits = mergeResources(c.Resources.Limits, v1.ResourceList{name: q})
+					}
+				}
+				for name, q := range item.DefaultRequest {
+					if _, ok := c.Resources.Requests[name]; !ok {
+						c.Resources.Requests = mergeResources(c.Resources.Requests, v1.ResourceList{name: q})
+					}
+				}
+
+				for name, max := range item.Max {
+					if q, ok := c.Resources.Limits[name]; ok && q.Cmp(max) > 0 {
+						problems = append(problems, fmt.Sprintf("limit range %s: container %s %s limit %s above maximum %s", lr.Name, c.Name, name, q.String(), max.String()))
+					}
+				}
+				for name, min := range item.Min {
+					if q, ok := c.Resources.Requests[name]; ok && q.Cmp(min) < 0 {
+						problems = append(problems, fmt.Sprintf("limit range %s: container %s %s request %s below minimum %s", lr.Name, c.Name, name, q.String(), min.String()))
+					}
+				}
+			}
+		case v1.LimitTypePod:
+			_, limits := podResources(spec)
+			for name, max := range item.Max {
+				if q, ok := limits[name]; ok && q.Cmp(max) > 0 {
+					problems = append(problems, fmt.Sprintf("limit range %s: pod %s limit %s above maximum %s", lr.Name, name, q.String(), max.String()))
+				}
+			}
+		}
+	}
+
+	return problems
+}
+
+// quotaResources maps the resources of quotas to the requests, or the limits,
+// of pods they account for
+var quotaResources = map[v1.ResourceName]struct {
+	name  v1.ResourceName
+	limit bool
+}{
+	v1.ResourceCPU:                      {name: v1.ResourceCPU},
+	v1.ResourceMemory:                   {name: v1.ResourceMemory},
+	v1.ResourceEphemeralStorage:         {name: v1.ResourceEphemeralStorage},
+	v1.ResourceRequestsCPU:              {name: v1.ResourceCPU},
+	v1.ResourceRequestsMemory:           {name: v1.ResourceMemory},
+	v1.ResourceRequestsEphemeralStorage: {name: v1.ResourceEphemeralStorage},
+	v1.ResourceLimitsCPU:                {name: v1.ResourceCPU, limit: true},
+	v1.ResourceLimitsMemory:             {name: v1.ResourceMemory, limit: true},
+	v1.ResourceLimitsEphemeralStorage:   {name: v1.ResourceEphemeralStorage, limit: true},
+}
+
+// checkQuota returns the resources of the quota the pod would exceed, or that
+// the pod must set to be accounted for
+func checkQuota(q *v1.ResourceQuota, requests, limits v1.ResourceList) []string {
+	// the quotas restricted to some pods may not apply
+	if len(q.Spec.Scopes) > 0 || q.Spec.ScopeSelector != nil {
+		return nil
+	}
+
+	var problems []string
+	for name, hard := range q.Status.Hard {
+		used := q.Status.Used[name]
+		remaining := hard.DeepCopy()
+		remaining.Sub(used)
+
+		if name == v1.ResourcePods {
+			if remaining.Sign() <= 0 {
+				problems = append(problems, fmt.Sprintf("quota %s pods exhausted: 0/%s remaining", q.Name, hard.String()))
+			}
+			continue
+		}
+
+		r, ok := quotaResources[name]
+		if !ok {
+			continue
+		}
+
+		amounts := requests
+		if r.limit {
+			amounts = limits
+		}
+		amount, ok := amounts[r.name]
+		if !ok {
+			kind := "requests"
+			if r.limit {
+				kind = "limits"
+			}
+			problems = append(problems, fmt.Sprintf("quota %s on %s requires %s.%s to be set", q.Name, name, kind, r.name))
+			continue
+		}
+
+		if amount.Cmp(remaining) > 0 {
+			if remaining.Sign() < 0 {
+				remaining = resource.Quantity{}
+			}
+			problems = append(problems, fmt.Sprintf("quota %s %s exhausted: %s/%s remaining, %s needed", q.Name, name, remaining.String(), hard.String(), amount.String()))
+		}
+	}
+
+	return problems
+}
+
+// podResources returns the requests and limits of a pod: those of its containers
+// summed, or those of its largest init container if higher
+func podResources(spec *v1.PodSpec) (requests, limits v1.ResourceList) {
+	requests, limits = v1.ResourceList{}, v1.ResourceList{}
+
+	for _, c := range spec.Containers {
+		addResources(requests, c.Resources.Requests)
+		addResources(limits, c.Resources.Limits)
+	}
+
+	for _, c := range spec.InitContainers {
+		maxResources(requests, c.Resources.Requests)
+		maxResources(limits, c.Resources.Limits)
+	}
+
+	return requests, limits
+}
+
+// addResources adds the resources of src to dst
+func addResources(dst, src v1.ResourceList) {
+	for name, q := range src {
+		sum := dst[name]
+		sum.Add(q)
+		dst[name] = sum
+	}
+}
+
+// maxResources sets the resources of src in dst where they are higher
+func maxResources(dst, src v1.ResourceList) {
+	for name, q := range src {
+		if cur, ok := dst[name]; !ok || q.Cmp(cur) > 0 {
+			dst[name] = q.DeepCopy()
+		}
+	}
+}
+
+// checkPodSecurity returns the fields of the spec that break the Pod Security
+// Standard of the given level, checking the most common controls only
+func checkPodSecurity(level string, spec *v1.PodSpec) []string {
+	if level != "baseline" && level != "restricted" {
+		return nil
+	}
+
+	var problems []string
+	if spec.HostNetwork || spec.HostPID || spec.HostIPC {
+		problems = append(problems, fmt.Sprintf("pod security %s: host namespaces are forbidden", level))
+	}
+	for _, vol := range spec.Volumes {
+		if vol.HostPath != nil {
+			problems = append(problems, fmt.Sprintf("pod security %s: hostPath volume %s is forbidden", level, vol.Name))
+		}
+	}
+
+	containers := append(append([]v1.Container{}, spec.InitContainers...), spec.Containers...)
+	for _, c := range containers {
+		sc := c.SecurityContext
+		if sc != nil && sc.Privileged != nil && *sc.Privileged {
+			problems = append(problems, fmt.Sprintf("pod security %s: container %s must not be privileged", level, c.Name))
+		}
+		if level != "restricted" {
+			continue
+		}
+
+		if sc == nil || sc.AllowPrivilegeEscalation == nil || *sc.AllowPrivilegeEscalation {
+			problems = append(problems, fmt.Sprintf("pod security restricted: container %s must set allowPrivilegeEscalation to false (set Restricted)", c.Name))
+		}
+		if sc == nil || sc.Capabilities == nil || !dropsAll(sc.Capabilities.Drop) {
+			problems = append(problems, fmt.Sprintf("pod security restricted: container %s must drop all capabilities (set Restricted)", c.Name))
+		}
+		runAsNonRoot := spec.SecurityContext != nil && spec.SecurityContext.RunAsNonRoot != nil && *spec.SecurityContext.RunAsNonRoot
+		if sc != nil && sc.RunAsNonRoot != nil {
+			runAsNonRoot = *sc.RunAsNonRoot
+		}
+		if !runAsNonRoot {
+			problems = append(problems, fmt.Sprintf("pod security restricted: container %s must run as non-root (set Restricted)", c.Name))
+		}
+	}
+
+	return problems
+}
+
+// dropsAll reports whether the capabilities dropped include ALL
+func dropsAll(drop []v1.Capability) bool {
+	for _, c := range drop {
+		if c == "ALL" {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,159 @@
+package exec
+
+import (
+	"fmt"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Permission is an action on a resource of the Kubernetes API, checked by Ping.
+type Permission struct {
+	// Verb is the API verb, such as create, get or watch.
+	Verb string
+
+	// Group is the API group of the resource, empty for the core group.
+	Group string
+
+	// Resource is the resource, such as pods, and Subresource its subresource,
+	// such as attach, if any.
+	Resource    string
+	Subresource string
+
+	// Name, if not empty, restricts the permission to the object of that name.
+	Name string
+}
+
+func (p Permission) String() string {
+	r := p.Resource
+	if p.Group != "" {
+		r += "." + p.Group
+	}
+	if p.Subresource != "" {
+		r += "/" + p.Subresource
+	}
+	if p.Name != "" {
+		r += " " + p.Name
+	}
+	return p.Verb + " " + r
+}
+
+// PodPermissions are the permissions needed to run commands in bare pods,
+// checked by Ping when none are given.
+var PodPermissions = []Permission{
+	{Verb: "create", Resource: "pods"},
+	{Verb: "get", Resource: "pods"},
+	{Verb: "watch", Resource: "pods"},
+	{Verb: "delete", Resource: "pods"},
+	{Verb: "create", Resource: "pods", Subresource: "attach"},
+}
+
+// Ping checks that the API server can be reached, that the client is
+// authenticated, and that it is granted the given permissions in the
+// namespace, or PodPermissions if none, with SelfSubjectAccessReviews. It
+// returns an error of kind ErrPreflight telling which of them failed, such
+// as "missing RBAC: create pods/attach in namespace ci", so problems are
+// found before anything is created.
+func (c *Client) Ping(namespace string, perms ...Permission) error {
+	if namespace == "" {
+		namespace = c.namespace
+	}
+	if len(perms) == 0 {
+		perms = PodPermissions
+	}
+
+	if _, err := c.clientset.Discovery().ServerVersion(); err != nil {
+		if apierrors.IsUnauthorized(err) {
+			return &Error{Kind: ErrPreflight, Err: fmt.Errorf("not authenticated: %w", err)}
+		}
+		if c.config != nil {
+			return &Error{Kind: ErrPreflight, Err: fmt.Errorf("cannot reach API server %s: %w", c.config.Host, err)}
+		}
+		return &Error{Kind: ErrPreflight, Err: fmt.Errorf("cannot reach API server: %w", err)}
+	}
+
+	var missing []string
+	for _, p := range perms {
+		review, err := c.clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(&authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace:   namespace,
+					Verb:        p.Verb,
+					Group:       p.Group,
+					Resource:    p.Resource,
+					Subresource: p.Subresource,
+					Name:        p.Name,
+				},
+			},
+		})
+		if err != nil {
+			return &Error{Kind: ErrPreflight, Err: fmt.Errorf("cannot review access to %s: %w", p, err)}
+		}
+		if !review.Status.Allowed {
+			missing = append(missing, p.String())
+		}
+	}
+
+	if len(missing) > 0 {
+		return &Error{Kind: ErrPreflight, Err: fmt.Errorf("missing RBAC: %s in namespace %s", strings.Join(missing, ", "), namespace)}
+	}
+
+	return nil
+}
+
+// permissions returns the permissions needed to run the command with its
+// config and options
+func (cmd *Cmd) permissions() []Permission {
+	cfg := cmd.Cfg
+
+	var perms []Permission
+	if cfg.RunAsJob {
+		perms = append(perms,
+			Permission{Verb: "create", Group: "batch", Resource: "jobs"},
+			Permission{Verb: "watch", Group: "batch", Resource: "jobs"},
+			Permission{Verb: "list", Resource: "pods"},
+			Permission{Verb: "watch", Resource: "pods"},
+			Permission{Verb: "get", Resource: "pods", Subresource: "log"},
+		)
+		if cfg.Cleanup {
+			perms = append(perms, Permission{Verb: "delete", Group: "batch", Resource: "jobs"})
+		}
+	} else {
+		perms = append(perms, PodPermissions...)
+		if cmd.Logs != LogsAttach || cmd.InitOutput != nil || cfg.RestartPolicy == v1.RestartPolicyOnFailure {
+			perms = append(perms, Permission{Verb: "get", Resource: "pods", Subresource: "log"})
+		}
+		if w := cfg.Workspace; needsArtifactsVolume(&cfg) || (w != nil && (len(w.Inputs) > 0 || len(w.Outputs) > 0)) {
+			perms = append(perms, Permission{Verb: "create", Resource: "pods", Subresource: "exec"})
+		}
+		if cfg.HealthCheck != nil {
+			perms = append(perms, Permission{Verb: "create", Resource: "pods", Subresource: "portforward"})
+		}
+		perms = append(perms,
+			Permission{Verb: "list", Resource: "events"},
+			Permission{Verb: "watch", Resource: "events"},
+		)
+	}
+
+	if len(cfg.EphemeralSecrets) > 0 {
+		perms = append(perms,
+			Permission{Verb: "create", Resource: "secrets"},
+			Permission{Verb: "delete", Resource: "secrets"},
+			Permission{Verb: "update", Resource: "secrets"},
+		)
+	}
+	for _, s := range cfg.Secrets {
+		perms = append(perms, Permission{Verb: "get", Resource: "secrets", Name: s.SecretName})
+	}
+	if cmd.Script != "" {
+		perms = append(perms,
+			Permission{Verb: "create", Resource: "configmaps"},
+			Permission{Verb: "delete", Resource: "configmaps"},
+			Permission{Verb: "update", Resource: "configmaps"},
+		)
+	}
+
+	return perms
+}
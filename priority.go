@@ -0,0 +1,59 @@
+package exec
+
+import (
+	"fmt"
+
+	schedulingv1beta1 "k8s.io/api/scheduling/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// checkPreemption returns an error of kind ErrPreemption if the pod, with the
+// given priority class or the default one if empty, could preempt other pods:
+// the version of the Kubernetes API of the package has no PreemptionPolicy,
+// added in Kubernetes 1.15, so any pod with a higher priority than others can
+// preempt them
+func (c *Client) checkPreemption(className string) error {
+	list, err := c.clientset.SchedulingV1beta1().PriorityClasses().List(metav1.ListOptions{})
+	if err != nil {
+		return &Error{Kind: ErrPreemption, Err: fmt.Errorf("cannot list priority classes: %w", err)}
+	}
+
+	// pods without a priority class have the priority of the global default
+	// class, or zero
+	var class, global *schedulingv1beta1.PriorityClass
+	for i, pc := range list.Items {
+		if pc.Name == className {
+			class = &list.Items[i]
+		}
+		if pc.GlobalDefault {
+			global = &list.Items[i]
+		}
+	}
+	if className == "" {
+		class = global
+	}
+	if className != "" && class == nil {
+		return &Error{Kind: ErrPreemption, Err: apierrors.NewNotFound(schedulingv1beta1.Resource("priorityclasses"), className)}
+	}
+
+	name, value := "default", int32(0)
+	if class != nil {
+		name, value = class.Name, class.Value
+	}
+	lowest, lowestValue := "default", int32(0)
+	if global != nil {
+		lowest, lowestValue = global.Name, global.Value
+	}
+	for _, pc := range list.Items {
+		if pc.Value < lowestValue {
+			lowest, lowestValue = pc.Name, pc.Value
+		}
+	}
+
+	if value > lowestValue {
+		return &Error{Kind: ErrPreemption, Err: fmt.Errorf("priority class %s (%d) could preempt pods of priority class %s (%d)", name, value, lowest, lowestValue)}
+	}
+
+	return nil
+}
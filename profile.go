@@ -0,0 +1,197 @@
+package exec
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// defaultProfile is the name of the profile applied to the configs of the
+// namespaces without a profile of their own
+const defaultProfile = "default"
+
+// Profile is a named preset of the fields of a config, so the policy of the
+// pods of commands, such as their image, resources and security context, is
+// standardized across call sites.
+type Profile struct {
+	Image              string        `json:"image,omitempty"`
+	ImagePullPolicy    v1.PullPolicy `json:"imagePullPolicy,omitempty"`
+	Namespace          string        `json:"namespace,omitempty"`
+	ServiceAccountName string        `json:"serviceAccountName,omitempty"`
+	PriorityClassName  string        `json:"priorityClassName,omitempty"`
+	RuntimeClassName   string        `json:"runtimeClassName,omitempty"`
+
+	// Requests and Limits are the compute resources of the container, with
+	// the cpu, memory and ephemeralStorage keys, and the extended resources
+	// and hugepages by name in extended.
+	Requests Resources `json:"requests,omitempty"`
+	Limits   Resources `json:"limits,omitempty"`
+
+	PodSecurityContext *v1.PodSecurityContext `json:"podSecurityContext,omitempty"`
+	SecurityContext    *v1.SecurityContext    `json:"securityContext,omitempty"`
+	Restricted         bool                   `json:"restricted,omitempty"`
+
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	Tolerations  []v1.Toleration   `json:"tolerations,omitempty"`
+
+	// Labels are added to the pods.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Profiles are the profiles of a file, by name, with the profile applied by
+// default to the configs of each namespace.
+type Profiles struct {
+	Profiles map[string]Profile `json:"profiles"`
+
+	// Namespaces are the names of the profiles applied to the configs of the
+	// namespaces, by namespace. The configs of the other namespaces get the
+	// profile named "default", if any.
+	Namespaces map[string]string `json:"namespaces,omitempty"`
+}
+
+// LoadProfiles loads the profiles of a YAML or JSON file, such as:
+//
+//	profiles:
+//	  default:
+//	    image: alpine:3.9
+//	    requests: {cpu: 100m, memory: 64Mi}
+//	    restricted: true
+//	  builds:
+//	    image: golang:1.12
+//	    namespace: ci
+//	    limits: {cpu: "4", memory: 8Gi}
+//	    labels: {team: platform}
+//	namespaces:
+//	  ci: builds
+//
+// Unknown fields are an error, so typos in policies are not ignored.
+func LoadProfiles(path string) (*Profiles, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseProfiles(b)
+}
+
+// ParseProfiles parses profiles in YAML or JSON, like LoadProfiles.
+func ParseProfiles(data []byte) (*Profiles, error) {
+	p := &Profiles{}
+	if err := yaml.UnmarshalStrict(data, p); err != nil {
+		return nil, fmt.Errorf("invalid profiles: %w", err)
+	}
+
+	for ns, name := range p.Namespaces {
+		if _, ok := p.Profiles[name]; !ok {
+			return nil, fmt.Errorf("exec: unknown profile %q of namespace %s", name, ns)
+		}
+	}
+
+	return p, nil
+}
+
+// Config returns a config with the fields of the profile with the given name.
+func (p *Profiles) Config(name string) (Config, error) {
+	cfg := Config{}
+	if err := p.Apply(&cfg, name); err != nil {
+		return Config{}, err
+	}
+
+	return cfg, nil
+}
+
+// Apply sets the fields of the config not set yet to those of the profile with
+// the given name, or if empty to those of the profile of the namespace of the
+// config, or of the default profile, if any. The labels of the profile are
+// added by a mutator run before the mutators of the config.
+func (p *Profiles) Apply(cfg *Config, name string) error {
+	if name == "" {
+		name = p.Namespaces[cfg.Namespace]
+		if name == "" {
+			if _, ok := p.Profiles[defaultProfile]; !ok {
+				return nil
+			}
+			name = defaultProfile
+		}
+	}
+
+	profile, ok := p.Profiles[name]
+	if !ok {
+		return fmt.Errorf("exec: unknown profile %q", name)
+	}
+	profile.apply(cfg)
+
+	return nil
+}
+
+// apply sets the fields of the config not set yet to those of the profile
+func (p *Profile) apply(cfg *Config) {
+	setString := func(field *string, value string) {
+		if *field == "" {
+			*field = value
+		}
+	}
+	setString(&cfg.Image, p.Image)
+	setString(&cfg.Namespace, p.Namespace)
+	setString(&cfg.ServiceAccountName, p.ServiceAccountName)
+	setString(&cfg.PriorityClassName, p.PriorityClassName)
+	setString(&cfg.RuntimeClassName, p.RuntimeClassName)
+	setString(&cfg.Requests.CPU, p.Requests.CPU)
+	setString(&cfg.Requests.Memory, p.Requests.Memory)
+	setString(&cfg.Requests.EphemeralStorage, p.Requests.EphemeralStorage)
+	setString(&cfg.Limits.CPU, p.Limits.CPU)
+	setString(&cfg.Limits.Memory, p.Limits.Memory)
+	setString(&cfg.Limits.EphemeralStorage, p.Limits.EphemeralStorage)
+	cfg.Requests.Extended = mergeAmounts(cfg.Requests.Extended, p.Requests.Extended)
+	cfg.Limits.Extended = mergeAmounts(cfg.Limits.Extended, p.Limits.Extended)
+
+	if cfg.ImagePullPolicy == "" {
+		cfg.ImagePullPolicy = p.ImagePullPolicy
+	}
+	if cfg.PodSecurityContext == nil && p.PodSecurityContext != nil {
+		cfg.PodSecurityContext = p.PodSecurityContext.DeepCopy()
+	}
+	if cfg.SecurityContext == nil && p.SecurityContext != nil {
+		cfg.SecurityContext = p.SecurityContext.DeepCopy()
+	}
+	cfg.Restricted = cfg.Restricted || p.Restricted
+
+	if cfg.NodeSelector == nil && len(p.NodeSelector) > 0 {
+		cfg.NodeSelector = map[string]string{}
+		for k, v := range p.NodeSelector {
+			cfg.NodeSelector[k] = v
+		}
+	}
+	if cfg.Tolerations == nil && len(p.Tolerations) > 0 {
+		cfg.Tolerations = append([]v1.Toleration{}, p.Tolerations...)
+	}
+
+	if len(p.Labels) > 0 {
+		labels := p.Labels
+		cfg.Mutators = append([]PodMutator{func(pod *v1.Pod) error {
+			for k, v := range labels {
+				pod.Labels = setLabel(pod.Labels, k, v)
+			}
+			return nil
+		}}, cfg.Mutators...)
+	}
+}
+
+// mergeAmounts returns the amounts of dst, with those of src it does not have
+func mergeAmounts(dst, src map[string]string) map[string]string {
+	if len(src) == 0 {
+		return dst
+	}
+
+	merged := map[string]string{}
+	for name, amount := range src {
+		merged[name] = amount
+	}
+	for name, amount := range dst {
+		merged[name] = amount
+	}
+
+	return merged
+}
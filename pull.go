@@ -0,0 +1,180 @@
+package exec
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// retriedPullReasons are the reasons of a waiting container whose image pull
+// failed, and is retried by the kubelet with backoff
+var retriedPullReasons = map[string]bool{
+	"ErrImagePull":     true,
+	"ImagePullBackOff": true,
+}
+
+// pullReasons are the reasons of a waiting container whose image cannot be
+// pulled, retried or not
+var pullReasons = unionReasons(retriedPullReasons, map[string]bool{
+	"InvalidImageName":  true,
+	"ErrImageNeverPull": true,
+})
+
+// ImagePull is the progress of pulling the image of a container of the pod of
+// a command.
+type ImagePull struct {
+	Container string
+	Image     string
+
+	// Reason is Pulling and Pulled, from the events about the pod, or the
+	// reason of the waiting container: ErrImagePull, ImagePullBackOff,
+	// InvalidImageName or ErrImageNeverPull.
+	Reason  string
+	Message string
+
+	// Failures is the number of times the pull failed so far.
+	Failures int
+}
+
+// ImagePullError reports the image of a container of the pod of a command that
+// cannot be pulled, with the error of the registry.
+type ImagePullError struct {
+	Container string
+	Image     string
+
+	// Reason is the reason of the waiting container (i.e. ErrImagePull), and
+	// Message the error of the last failed pull.
+	Reason  string
+	Message string
+
+	// Failures is the number of times the pull failed.
+	Failures int
+}
+
+func (e *ImagePullError) Error() string {
+	return fmt.Sprintf("cannot pull image %s of container %s after %d failures (%s): %s", e.Image, e.Container, e.Failures, e.Reason, e.Message)
+}
+
+// imagePullFailure returns an *ImagePullError if the image of a container of
+// the pod cannot be pulled
+func imagePullFailure(pod *v1.Pod) error {
+	for _, s := range pod.Status.ContainerStatuses {
+		if w := s.State.Waiting; w != nil && pullReasons[w.Reason] {
+			return &ImagePullError{Container: s.Name, Image: s.Image, Reason: w.Reason, Message: w.Message, Failures: 1}
+		}
+	}
+
+	return nil
+}
+
+// pullTracker follows the image pulls of the containers of a pod, counting
+// their failures
+type pullTracker struct {
+	mu       sync.Mutex
+	reasons  map[string]string
+	failures map[string]int
+	messages map[string]string
+}
+
+// update returns the progress of the pulls that changed in the pod since the
+// last update
+func (t *pullTracker) update(pod *v1.Pod) []ImagePull {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.reasons == nil {
+		t.reasons = map[string]string{}
+		t.failures = map[string]int{}
+		t.messages = map[string]string{}
+	}
+
+	var changed []ImagePull
+	for _, s := range pod.Status.ContainerStatuses {
+		reason, message := "", ""
+		if w := s.State.Waiting; w != nil && pullReasons[w.Reason] {
+			reason, message = w.Reason, w.Message
+		}
+		if reason == t.reasons[s.Name] {
+			continue
+		}
+		t.reasons[s.Name] = reason
+		if reason == "" {
+			continue
+		}
+
+		// the backoff keeps the message of the failed pull for itself
+		if reason != "ImagePullBackOff" {
+			t.failures[s.Name]++
+			t.messages[s.Name] = message
+		}
+		changed = append(changed, ImagePull{
+			Container: s.Name,
+			Image:     s.Image,
+			Reason:    reason,
+			Message:   message,
+			Failures:  t.failures[s.Name],
+		})
+	}
+
+	return changed
+}
+
+// failure returns the error of the last failed pull of the image of the
+// container
+func (t *pullTracker) failure(container string) (int, string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.failures[container], t.messages[container]
+}
+
+// startFailure returns an error if the pod of the command cannot start, like
+// podStartFailure, but tolerates the failed pulls of images retried by the
+// kubelet up to ImagePullRetries, and reports their progress to the hooks
+func (cmd *Cmd) startFailure(pod *v1.Pod) error {
+	for _, p := range cmd.pulls.update(pod) {
+		if cmd.Hooks != nil && cmd.Hooks.OnImagePull != nil {
+			cmd.Hooks.OnImagePull(pod, p)
+		}
+	}
+
+	err := podStartFailure(pod)
+
+	var pullErr *ImagePullError
+	if !errors.As(err, &pullErr) {
+		return err
+	}
+
+	failures, message := cmd.pulls.failure(pullErr.Container)
+	if failures > 0 {
+		pullErr.Failures = failures
+	}
+	if message != "" {
+		pullErr.Message = message
+	}
+	if retriedPullReasons[pullErr.Reason] && pullErr.Failures <= cmd.Cfg.ImagePullRetries {
+		return nil
+	}
+
+	cmd.log.Warn("image cannot be pulled", "namespace", pod.Namespace, "pod", pod.Name, "container", pullErr.Container, "image", pullErr.Image, "failures", pullErr.Failures)
+	return err
+}
+
+// eventPull returns the progress of an image pull reported by an event about
+// the pod, if any
+func eventPull(e *v1.Event) (ImagePull, bool) {
+	if e.Reason != "Pulling" && e.Reason != "Pulled" {
+		return ImagePull{}, false
+	}
+
+	// the field path of the container is spec.containers{name}
+	container := e.InvolvedObject.FieldPath
+	if i := strings.IndexByte(container, '{'); i >= 0 && strings.HasSuffix(container, "}") {
+		container = container[i+1 : len(container)-1]
+	}
+
+	return ImagePull{Container: container, Reason: e.Reason, Message: e.Message}, true
+}
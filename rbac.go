@@ -0,0 +1,100 @@
+package exec
+
+import (
+	"bytes"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// RBAC returns the Role granting the permissions needed to run the command,
+// with its config and options, and the RoleBinding binding it to the given
+// subjects, both with the given name in the namespace of the config, so they
+// can be granted exactly what the command needs.
+func (cmd *Cmd) RBAC(name string, subjects ...rbacv1.Subject) (*rbacv1.Role, *rbacv1.RoleBinding) {
+	meta := metav1.ObjectMeta{
+		Name:      name,
+		Namespace: cmd.Cfg.Namespace,
+	}
+
+	role := &rbacv1.Role{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role"},
+		ObjectMeta: meta,
+		Rules:      policyRules(cmd.permissions()),
+	}
+
+	binding := &rbacv1.RoleBinding{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "RoleBinding"},
+		ObjectMeta: *meta.DeepCopy(),
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: name},
+		Subjects:   subjects,
+	}
+
+	return role, binding
+}
+
+// RBACManifest returns the Role and the RoleBinding returned by RBAC as a YAML
+// manifest, to be applied with kubectl.
+func (cmd *Cmd) RBACManifest(name string, subjects ...rbacv1.Subject) ([]byte, error) {
+	role, binding := cmd.RBAC(name, subjects...)
+
+	var b bytes.Buffer
+	for i, obj := range []interface{}{role, binding} {
+		out, err := yaml.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			b.WriteString("---\n")
+		}
+		b.Write(out)
+	}
+
+	return b.Bytes(), nil
+}
+
+// policyRules returns the rules granting the permissions, with the verbs on
+// the same resources grouped in a rule, in the order of the permissions
+func policyRules(perms []Permission) []rbacv1.PolicyRule {
+	var rules []rbacv1.PolicyRule
+	index := map[Permission]int{}
+
+	for _, p := range perms {
+		key := p
+		key.Verb = ""
+
+		resource := p.Resource
+		if p.Subresource != "" {
+			resource += "/" + p.Subresource
+		}
+
+		i, ok := index[key]
+		if !ok {
+			rule := rbacv1.PolicyRule{APIGroups: []string{p.Group}, Resources: []string{resource}}
+			if p.Name != "" {
+				rule.ResourceNames = []string{p.Name}
+			}
+			i = len(rules)
+			index[key] = i
+			rules = append(rules, rule)
+		}
+
+		if !containsString(rules[i].Verbs, p.Verb) {
+			rules[i].Verbs = append(rules[i].Verbs, p.Verb)
+		}
+	}
+
+	return rules
+}
+
+// containsString reports whether the strings contain s
+func containsString(strs []string, s string) bool {
+	for _, str := range strs {
+		if str == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,447 @@
+package exec
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// defaultRecordOutputLimit is the number of last bytes of each output stream
+// kept in the run record, if not set
+const defaultRecordOutputLimit = 16 * 1024
+
+// RunRecord is the record of a run of a command, serializable as JSON, so
+// every remote execution can be archived for audit.
+type RunRecord struct {
+	RunID     string   `json:"runID"`
+	Command   []string `json:"command"`
+	Namespace string   `json:"namespace"`
+	Pod       string   `json:"pod,omitempty"`
+	Job       string   `json:"job,omitempty"`
+	Node      string   `json:"node,omitempty"`
+
+	// ConfigHash is the SHA-256 of the config and the command, without the
+	// values of the ephemeral secrets and the fields that are not data, such
+	// as the logger, to tell the runs with the same config apart.
+	ConfigHash string `json:"configHash"`
+
+	// Manifest is the pod, or the job, created for the command.
+	Manifest json.RawMessage `json:"manifest,omitempty"`
+
+	// CreatedAt is the time the pod was created, StartedAt and FinishedAt the
+	// times the container of the command started and terminated, and EndedAt
+	// the time the command returned.
+	CreatedAt  time.Time  `json:"createdAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	EndedAt    time.Time  `json:"endedAt"`
+
+	// ExitCode is the exit code of the command, if it terminated.
+	ExitCode     *int   `json:"exitCode,omitempty"`
+	Reason       string `json:"reason,omitempty"`
+	Attempts     int    `json:"attempts,omitempty"`
+	RestartCount int32  `json:"restartCount,omitempty"`
+
+	// Error is the error the command failed with, if any.
+	Error string `json:"error,omitempty"`
+
+	// Stdout and Stderr are the last bytes of the output of the command, up to
+	// RecordOutputLimit, with the Truncated flags set if some were dropped.
+	Stdout          string `json:"stdout,omitempty"`
+	Stderr          string `json:"stderr,omitempty"`
+	StdoutTruncated bool   `json:"stdoutTruncated,omitempty"`
+	StderrTruncated bool   `json:"stderrTruncated,omitempty"`
+
+	// Events are the events about the pod, oldest first.
+	Events []RecordEvent `json:"events,omitempty"`
+
+	// CacheKey is the key of the result of the command in the Cache of the
+	// config, and Cached is set if the result was replayed from it, in which
+	// case the record is that of the run cached but for its RunID.
+	CacheKey string `json:"cacheKey,omitempty"`
+	Cached   bool   `json:"cached,omitempty"`
+}
+
+// RecordEvent is an event about the pod of a command, in its run record.
+type RecordEvent struct {
+	Type      string    `json:"type"`
+	Reason    string    `json:"reason"`
+	Message   string    `json:"message"`
+	Count     int32     `json:"count,omitempty"`
+	FirstTime time.Time `json:"firstTime"`
+	LastTime  time.Time `json:"lastTime"`
+}
+
+// Record returns the record of the run of the command once it terminated, or
+// nil before. The output of the command is only in the record with a
+// RecordWriter in the config.
+func (cmd *Cmd) Record() *RunRecord {
+	return cmd.record
+}
+
+// tailWriter keeps the last bytes written to it, up to a limit
+type tailWriter struct {
+	mu        sync.Mutex
+	limit     int
+	buf       []byte
+	truncated bool
+}
+
+func (t *tailWriter) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.buf = append(t.buf, p...)
+	if over := len(t.buf) - t.limit; over > 0 {
+		t.buf = append(t.buf[:0], t.buf[over:]...)
+		t.truncated = true
+	}
+
+	return len(p), nil
+}
+
+// tail returns the bytes kept, and whether some were dropped
+func (t *tailWriter) tail() (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return string(t.buf), t.truncated
+}
+
+// setupRecord keeps the last bytes of the output of the command for its run
+// record, next to Stdout and Stderr if set
+func (cmd *Cmd) setupRecord() {
+	limit := cmd.Cfg.RecordOutputLimit
+	if limit == 0 {
+		limit = defaultRecordOutputLimit
+	}
+	if limit < 0 {
+		return
+	}
+
+	cmd.stdoutTail = &tailWriter{limit: limit}
+	cmd.stderrTail = &tailWriter{limit: limit}
+
+	stdout, stderr := cmd.Stdout, cmd.Stderr
+	if stdout != nil && sameWriter(stdout, stderr) {
+		// the streams are copied from different goroutines
+		stdout = &lockedWriter{w: stdout}
+		stderr = stdout
+	}
+
+	cmd.Stdout = teeWriter(stdout, cmd.stdoutTail)
+	cmd.Stderr = teeWriter(stderr, cmd.stderrTail)
+}
+
+// teeWriter returns a writer writing to w, if not nil, and to tee
+func teeWriter(w, tee io.Writer) io.Writer {
+	if w == nil {
+		return tee
+	}
+
+	return io.MultiWriter(w, tee)
+}
+
+// writeRecord assembles the record of the command terminated with err, and
+// writes it to the RecordWriter of the config, if any
+func (cmd *Cmd) writeRecord(err error) {
+	r := &RunRecord{
+		RunID:     cmd.runID,
+		Command:   append([]string{cmd.Path}, cmd.Args...),
+		Namespace: cmd.Cfg.Namespace,
+		CreatedAt: cmd.startTime,
+		EndedAt:   time.Now(),
+		Attempts:  cmd.attempt,
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	hash, herr := configHash(cmd)
+	if herr != nil {
+		cmd.log.Warn("cannot hash config", "error", herr)
+	}
+	r.ConfigHash = hash
+
+	if obj, merr := cmd.Manifest(); merr == nil {
+		r.Manifest, _ = json.Marshal(obj)
+	}
+	switch {
+	case cmd.job != nil:
+		r.Job = cmd.job.Name
+	case cmd.pod != nil:
+		r.Pod = cmd.pod.Name
+		if p := cmd.Placement(); p != nil {
+			r.Node = p.NodeName
+		}
+	}
+
+	if s := cmd.ProcessState; s != nil {
+		code := s.ExitCode
+		r.ExitCode = &code
+		r.Reason = s.Reason
+		r.RestartCount = s.RestartCount
+		r.StartedAt, r.FinishedAt = &s.StartedAt, &s.FinishedAt
+	}
+	if cmd.stdoutTail != nil {
+		r.Stdout, r.StdoutTruncated = cmd.stdoutTail.tail()
+		r.Stderr, r.StderrTruncated = cmd.stderrTail.tail()
+	}
+
+	if cmd.pod != nil && cmd.client != nil && !cmd.Cfg.DryRun {
+		if events, eerr := cmd.client.podEvents(cmd.pod); eerr == nil {
+			r.Events = recordEvents(events)
+		}
+	}
+
+	cmd.emitRecord(r)
+}
+
+// emitRecord sets the record of the command, and writes it to the RecordWriter
+// of the config, if any
+func (cmd *Cmd) emitRecord(r *RunRecord) {
+	cmd.record = r
+	if cmd.Cfg.RecordWriter == nil {
+		return
+	}
+
+	out, merr := json.Marshal(r)
+	if merr != nil {
+		cmd.log.Warn("cannot marshal run record", "error", merr)
+		return
+	}
+	if _, werr := cmd.Cfg.RecordWriter.Write(append(out, '\n')); werr != nil {
+		cmd.log.Warn("cannot write run record", "error", werr)
+	}
+}
+
+// hashedConfig is the data hashed by configHash: the fields of the config but
+// those that are not data, such as the logger, with the ephemeral secrets
+// without their values, and the fields of the command that change its result.
+// The data fields added to Config or Cmd must be added here.
+type hashedConfig struct {
+	Kubeconfig                   string
+	Context                      string
+	Cluster                      string
+	User                         string
+	QPS                          float32
+	Burst                        int
+	Namespace                    string
+	Name                         string
+	Image                        string
+	ImageDigest                  string
+	ImagePullPolicy              v1.PullPolicy
+	GenerateName                 string
+	Precheck                     bool
+	Preflight                    bool
+	CreateNamespace              bool
+	NamespaceLabels              map[string]string
+	Secrets                      []Secret
+	ConfigMaps                   []ConfigMapKey
+	Volumes                      []Volume
+	EphemeralSecrets             []EphemeralSecret
+	EnvVars                      []v1.EnvVar
+	EnvFrom                      []v1.EnvFromSource
+	ServiceAccountName           string
+	ImagePullSecrets             []string
+	AutomountServiceAccountToken *bool
+	PodSecurityContext           *v1.PodSecurityContext
+	SecurityContext              *v1.SecurityContext
+	Restricted                   bool
+	OS                           string
+	Arch                         string
+	NodeSelector                 map[string]string
+	Tolerations                  []v1.Toleration
+	Affinity                     *v1.Affinity
+	NodeName                     string
+	Host                         *HostAccess
+	DNSPolicy                    v1.DNSPolicy
+	DNSConfig                    *v1.PodDNSConfig
+	HostAliases                  []v1.HostAlias
+	RuntimeClassName             string
+	PriorityClassName            string
+	NoPreemption                 bool
+	Requests                     Resources
+	Limits                       Resources
+	FieldManager                 string
+	ServerSideApply              bool
+	DryRun                       bool
+	Cleanup                      bool
+	CleanupGracePeriod           *int64
+	StartTimeout                 time.Duration
+	ImagePullRetries             int
+	TimeToSchedule               time.Duration
+	TimeToStart                  time.Duration
+	ExecutionTimeout             time.Duration
+	StreamIdleTimeout            time.Duration
+	FlushTimeout                 time.Duration
+	WaitFor                      WaitMode
+	ReadinessProbe               *v1.Probe
+	LivenessProbe                *v1.Probe
+	HealthCheck                  *HealthCheck
+	ActiveDeadlineSeconds        *int64
+	RestartPolicy                v1.RestartPolicy
+	MaxRestarts                  int
+	Retry                        *RetryPolicy
+	DiagnosticLogLines           int64
+	KeepFailed                   bool
+	OwnerReferences              []metav1.OwnerReference
+	RecordOutputLimit            int
+	RunAsJob                     bool
+	BackoffLimit                 *int32
+	Completions                  *int32
+	TTLSecondsAfterFinished      *int32
+	PodTemplate                  *v1.PodSpec
+	MainContainer                string
+	InitContainers               []v1.Container
+	Workspace                    *Workspace
+	Artifacts                    []string
+	ArtifactsDir                 string
+	CaptureEnvironment           bool
+	Helper                       *Helper
+	Sidecars                     []v1.Container
+
+	Command     []string
+	Env         []string
+	Dir         string
+	Shell       []string
+	Script      string
+	MergeStderr bool
+	Container   string
+	TTY         bool
+}
+
+// configHash returns the SHA-256 of the config and the command, as hex
+func configHash(cmd *Cmd) (string, error) {
+	cfg := &cmd.Cfg
+	secrets := make([]EphemeralSecret, len(cfg.EphemeralSecrets))
+	for i, s := range cfg.EphemeralSecrets {
+		secrets[i] = EphemeralSecret{Name: s.Name, MountPath: s.MountPath}
+	}
+
+	b, err := json.Marshal(hashedConfig{
+		Kubeconfig:                   cfg.Kubeconfig,
+		Context:                      cfg.Context,
+		Cluster:                      cfg.Cluster,
+		User:                         cfg.User,
+		QPS:                          cfg.QPS,
+		Burst:                        cfg.Burst,
+		Namespace:                    cfg.Namespace,
+		Name:                         cfg.Name,
+		Image:                        cfg.Image,
+		ImageDigest:                  cfg.ImageDigest,
+		ImagePullPolicy:              cfg.ImagePullPolicy,
+		GenerateName:                 cfg.GenerateName,
+		Precheck:                     cfg.Precheck,
+		Preflight:                    cfg.Preflight,
+		CreateNamespace:              cfg.CreateNamespace,
+		NamespaceLabels:              cfg.NamespaceLabels,
+		Secrets:                      cfg.Secrets,
+		ConfigMaps:                   cfg.ConfigMaps,
+		Volumes:                      cfg.Volumes,
+		EphemeralSecrets:             secrets,
+		EnvVars:                      cfg.EnvVars,
+		EnvFrom:                      cfg.EnvFrom,
+		ServiceAccountName:           cfg.ServiceAccountName,
+		ImagePullSecrets:             cfg.ImagePullSecrets,
+		AutomountServiceAccountToken: cfg.AutomountServiceAccountToken,
+		PodSecurityContext:           cfg.PodSecurityContext,
+		SecurityContext:              cfg.SecurityContext,
+		Restricted:                   cfg.Restricted,
+		OS:                           cfg.OS,
+		Arch:                         cfg.Arch,
+		NodeSelector:                 cfg.NodeSelector,
+		Tolerations:                  cfg.Tolerations,
+		Affinity:                     cfg.Affinity,
+		NodeName:                     cfg.NodeName,
+		Host:                         cfg.Host,
+		DNSPolicy:                    cfg.DNSPolicy,
+		DNSConfig:                    cfg.DNSConfig,
+		HostAliases:                  cfg.HostAliases,
+		RuntimeClassName:             cfg.RuntimeClassName,
+		PriorityClassName:            cfg.PriorityClassName,
+		NoPreemption:                 cfg.NoPreemption,
+		Requests:                     cfg.Requests,
+		Limits:                       cfg.Limits,
+		FieldManager:                 cfg.FieldManager,
+		ServerSideApply:              cfg.ServerSideApply,
+		DryRun:                       cfg.DryRun,
+		Cleanup:                      cfg.Cleanup,
+		CleanupGracePeriod:           cfg.CleanupGracePeriod,
+		StartTimeout:                 cfg.StartTimeout,
+		ImagePullRetries:             cfg.ImagePullRetries,
+		TimeToSchedule:               cfg.TimeToSchedule,
+		TimeToStart:                  cfg.TimeToStart,
+		ExecutionTimeout:             cfg.ExecutionTimeout,
+		StreamIdleTimeout:            cfg.StreamIdleTimeout,
+		FlushTimeout:                 cfg.FlushTimeout,
+		WaitFor:                      cfg.WaitFor,
+		ReadinessProbe:               cfg.ReadinessProbe,
+		LivenessProbe:                cfg.LivenessProbe,
+		HealthCheck:                  cfg.HealthCheck,
+		ActiveDeadlineSeconds:        cfg.ActiveDeadlineSeconds,
+		RestartPolicy:                cfg.RestartPolicy,
+		MaxRestarts:                  cfg.MaxRestarts,
+		Retry:                        cfg.Retry,
+		DiagnosticLogLines:           cfg.DiagnosticLogLines,
+		KeepFailed:                   cfg.KeepFailed,
+		OwnerReferences:              cfg.OwnerReferences,
+		RecordOutputLimit:            cfg.RecordOutputLimit,
+		RunAsJob:                     cfg.RunAsJob,
+		BackoffLimit:                 cfg.BackoffLimit,
+		Completions:                  cfg.Completions,
+		TTLSecondsAfterFinished:      cfg.TTLSecondsAfterFinished,
+		PodTemplate:                  cfg.PodTemplate,
+		MainContainer:                cfg.MainContainer,
+		InitContainers:               cfg.InitContainers,
+		Workspace:                    cfg.Workspace,
+		Artifacts:                    cfg.Artifacts,
+		ArtifactsDir:                 cfg.ArtifactsDir,
+		CaptureEnvironment:           cfg.CaptureEnvironment,
+		Helper:                       cfg.Helper,
+		Sidecars:                     cfg.Sidecars,
+
+		Command:     append([]string{cmd.Path}, cmd.Args...),
+		Env:         cmd.Env,
+		Dir:         cmd.Dir,
+		Shell:       cmd.Shell,
+		Script:      cmd.Script,
+		MergeStderr: cmd.MergeStderr,
+		Container:   cmd.Container,
+		TTY:         cmd.TTY,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// recordEvents returns the events of the record, oldest first
+func recordEvents(events []v1.Event) []RecordEvent {
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].LastTimestamp.Before(&events[j].LastTimestamp)
+	})
+
+	records := make([]RecordEvent, len(events))
+	for i, e := range events {
+		records[i] = RecordEvent{
+			Type:      e.Type,
+			Reason:    e.Reason,
+			Message:   e.Message,
+			Count:     e.Count,
+			FirstTime: e.FirstTimestamp.Time,
+			LastTime:  e.LastTimestamp.Time,
+		}
+	}
+
+	return records
+}
@@ -0,0 +1,57 @@
+package exec_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	exec "github.com/engineerd/kube-exec"
+	"github.com/engineerd/kube-exec/kubeexectest"
+)
+
+func TestRunRecordConfigHash(t *testing.T) {
+	backend := kubeexectest.New()
+
+	var records bytes.Buffer
+	cfg := exec.Config{
+		Client:       backend.Client,
+		Namespace:    "test",
+		GenerateName: "run-",
+		Image:        "busybox",
+		RecordWriter: &records,
+		// the fields that are not data are not hashed
+		ResolveDigest: func(context.Context, string) (string, error) {
+			return "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", nil
+		},
+	}
+
+	for _, args := range [][]string{{"echo", "a"}, {"echo", "a"}, {"echo", "b"}} {
+		if err := exec.Command(cfg, args[0], args[1:]...).Run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var hashes []string
+	dec := json.NewDecoder(&records)
+	for dec.More() {
+		var r exec.RunRecord
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, r.ConfigHash)
+	}
+	if len(hashes) != 3 {
+		t.Fatalf("%d records, want 3", len(hashes))
+	}
+
+	if len(hashes[0]) != 64 {
+		t.Errorf("config hash = %q, want a SHA-256 as hex", hashes[0])
+	}
+	if hashes[1] != hashes[0] {
+		t.Errorf("config hash of the same command = %q, want %q", hashes[1], hashes[0])
+	}
+	if hashes[2] == hashes[0] {
+		t.Errorf("config hash of another command = %q, want a different hash", hashes[2])
+	}
+}
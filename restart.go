@@ -0,0 +1,100 @@
+package exec
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// willRestart reports whether the container of the command terminated in the
+// pod is restarted by the kubelet, and followed by the command
+func (cmd *Cmd) willRestart(pod *v1.Pod) bool {
+	if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+		return false
+	}
+	if cmd.Cfg.MaxRestarts > 0 && cmd.restarts >= cmd.Cfg.MaxRestarts {
+		return false
+	}
+
+	state := terminatedState(pod)
+	if state == nil {
+		return false
+	}
+
+	switch pod.Spec.RestartPolicy {
+	case v1.RestartPolicyAlways:
+		return true
+	case v1.RestartPolicyOnFailure:
+		return state.ExitCode != 0
+	}
+
+	return false
+}
+
+// followRestarts streams the output of the container of the command every time
+// it is restarted, and returns the pod once the command terminated without
+// being restarted
+func (cmd *Cmd) followRestarts(pod *v1.Pod) (*v1.Pod, error) {
+	for cmd.willRestart(pod) {
+		if _, err := cmd.kubernetes("RestartPolicy"); err != nil {
+			return nil, err
+		}
+
+		state := terminatedState(pod)
+		cmd.restarts++
+		cmd.log.Warn("command restarted", "namespace", pod.Namespace, "pod", pod.Name, "restarts", cmd.restarts, "exitCode", state.ExitCode, "reason", state.Reason)
+		if cmd.lines != nil {
+			cmd.lines.segment(cmd.restarts)
+		}
+		if cmd.Hooks != nil && cmd.Hooks.OnRestart != nil {
+			cmd.Hooks.OnRestart(pod, newProcessState(commandStatus(pod), state))
+		}
+
+		// the new container may run before a stream connects, so its output is
+		// read from its logs from the start
+		started, err := cmd.exec.WaitReady(cmd.ctx, pod, cmd.observe(containerReplaced(state.ContainerID)), cmd.podDisrupted)
+		if err != nil {
+			return nil, err
+		}
+		err = cmd.client.streamLogs(cmd.ctx, started, &v1.PodLogOptions{Container: cmd.Container, Follow: true}, cmd.countBytes("stdout", cmd.Stdout))
+		if err != nil {
+			if cmd.ctx.Err() != nil {
+				return nil, cmd.ctx.Err()
+			}
+			return nil, fmt.Errorf("cannot stream logs of restarted command: %w", err)
+		}
+
+		pod, err = cmd.exec.WaitReady(cmd.ctx, started, cmd.observe(terminatedSince(state.ContainerID)), cmd.podDisrupted)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return pod, nil
+}
+
+// containerReplaced returns a condition satisfied once the container of the
+// command with the given ID was replaced by a new one, which runs or has run
+func containerReplaced(id string) func(*v1.Pod) bool {
+	return func(pod *v1.Pod) bool {
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			return true
+		}
+
+		s := commandStatus(pod)
+		return s != nil && s.ContainerID != id && (s.State.Running != nil || s.State.Terminated != nil)
+	}
+}
+
+// terminatedSince returns a condition satisfied once a container of the
+// command other than the one with the given ID terminated
+func terminatedSince(id string) func(*v1.Pod) bool {
+	return func(pod *v1.Pod) bool {
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			return true
+		}
+
+		state := terminatedState(pod)
+		return state != nil && state.ContainerID != id
+	}
+}
@@ -0,0 +1,203 @@
+package exec
+
+import (
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+const (
+	// labelIdempotencyKey and labelAttempt are the labels of the pods of the
+	// commands with a retry policy: the key shared by all attempts, and the
+	// number of the attempt
+	labelIdempotencyKey = "kube-exec/idempotency-key"
+	labelAttempt        = "kube-exec/attempt"
+
+	// defaultMaxAttempts and defaultAttemptBackoff are the defaults of the
+	// retry policies
+	defaultMaxAttempts    = 3
+	defaultAttemptBackoff = time.Second
+)
+
+// RetryPolicy runs a command again in a new pod when its pod is evicted, or is
+// lost with its node, or when the command exits with some exit codes.
+//
+// The output of every attempt is written to Stdout and Stderr, and Stdin is
+// only sent to the first attempt. With a Name, the pods of the next attempts
+// are named after it with the attempt number as suffix. The pod of a failed
+// attempt is deleted if Cleanup is set, unless KeepFailed is set too.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times the command runs. If zero,
+	// it runs up to 3 times.
+	MaxAttempts int
+
+	// ExitCodes are the exit codes the command runs again on.
+	ExitCodes []int
+
+	// Backoff is the time to wait before running the command again, doubled
+	// after every attempt. If zero, it is 1 second.
+	Backoff time.Duration
+
+	// OtherNodes runs the attempts following the disruption of a pod, such
+	// as an eviction, on other nodes than the disrupted pods.
+	OtherNodes bool
+
+	// IdempotencyKey is the value of the kube-exec/idempotency-key label of
+	// the pods of all attempts, so they can be correlated. If empty, a random
+	// key is generated. The pods are also labeled with kube-exec/attempt.
+	IdempotencyKey string
+}
+
+// maxAttempts returns the maximum number of times the command runs
+func (p *RetryPolicy) maxAttempts() int {
+	if p.MaxAttempts <= 0 {
+		return defaultMaxAttempts
+	}
+	return p.MaxAttempts
+}
+
+// backoff returns the time to wait before the second attempt
+func (p *RetryPolicy) backoff() time.Duration {
+	if p.Backoff <= 0 {
+		return defaultAttemptBackoff
+	}
+	return p.Backoff
+}
+
+// retryable reports whether a command failing with err runs again
+func (p *RetryPolicy) retryable(err error) bool {
+	var evictionErr *EvictionError
+	if errors.As(err, &evictionErr) {
+		return true
+	}
+
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		for _, code := range p.ExitCodes {
+			if exitErr.Code == code {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Attempts returns the number of times the command ran, or started to, which
+// is more than one only with a RetryPolicy.
+func (cmd *Cmd) Attempts() int {
+	return cmd.attempt
+}
+
+// labelPod labels the pod with the idempotency key of the command and the
+// number of the attempt, if the command has a retry policy
+func (cmd *Cmd) labelPod(pod *v1.Pod) {
+	p := cmd.Cfg.Retry
+	if p == nil {
+		return
+	}
+
+	if cmd.idempotencyKey == "" {
+		cmd.idempotencyKey = p.IdempotencyKey
+		if cmd.idempotencyKey == "" {
+			cmd.idempotencyKey = string(uuid.NewUUID())
+		}
+	}
+
+	pod.Labels = setLabel(pod.Labels, labelIdempotencyKey, cmd.idempotencyKey)
+	pod.Labels = setLabel(pod.Labels, labelAttempt, strconv.Itoa(cmd.attempt))
+	if cmd.attempt > 1 && pod.Name != "" {
+		pod.Name += "-" + strconv.Itoa(cmd.attempt)
+	}
+}
+
+// avoidNodes keeps the pod off the nodes of the disrupted pods of the previous
+// attempts, by requiring a node not named after them in each node selector
+// term of its affinity
+func (cmd *Cmd) avoidNodes(pod *v1.Pod) {
+	if len(cmd.avoidedNodes) == 0 {
+		return
+	}
+
+	req := v1.NodeSelectorRequirement{
+		Key:      "metadata.name",
+		Operator: v1.NodeSelectorOpNotIn,
+		Values:   cmd.avoidedNodes,
+	}
+
+	if pod.Spec.Affinity == nil {
+		pod.Spec.Affinity = &v1.Affinity{}
+	}
+	a := pod.Spec.Affinity
+	if a.NodeAffinity == nil {
+		a.NodeAffinity = &v1.NodeAffinity{}
+	}
+	if a.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		a.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = &v1.NodeSelector{}
+	}
+	selector := a.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if len(selector.NodeSelectorTerms) == 0 {
+		selector.NodeSelectorTerms = []v1.NodeSelectorTerm{{}}
+	}
+	for i := range selector.NodeSelectorTerms {
+		term := &selector.NodeSelectorTerms[i]
+		term.MatchFields = append(term.MatchFields, req)
+	}
+}
+
+// run waits for the command, and runs it again in a new pod as allowed by the
+// retry policy of the config
+func (cmd *Cmd) run() error {
+	err := cmd.wait()
+
+	p := cmd.Cfg.Retry
+	if p == nil || cmd.job != nil {
+		return err
+	}
+
+	backoff := p.backoff()
+	for err != nil && cmd.attempt < p.maxAttempts() && p.retryable(err) && cmd.ctx.Err() == nil && atomic.LoadInt32(&cmd.killed) == 0 {
+		cmd.log.Warn("command failed, running it again", "namespace", cmd.pod.Namespace, "pod", cmd.pod.Name, "attempt", cmd.attempt, "error", err)
+
+		if cmd.Cfg.Cleanup && !cmd.Cfg.KeepFailed {
+			if derr := cmd.deletePod(cmd.ctx, cmd.Cfg.CleanupGracePeriod); derr != nil {
+				cmd.log.Warn("cannot delete pod of failed attempt", "namespace", cmd.pod.Namespace, "pod", cmd.pod.Name, "error", derr)
+			}
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-cmd.ctx.Done():
+			return cmd.ctx.Err()
+		}
+		backoff *= 2
+
+		// the pod created is not scheduled yet, its node is the one observed
+		// once it started
+		var evictionErr *EvictionError
+		if placement := cmd.Placement(); p.OtherNodes && errors.As(err, &evictionErr) && placement != nil && placement.NodeName != "" {
+			cmd.avoidedNodes = append(cmd.avoidedNodes, placement.NodeName)
+		}
+		cmd.placementMu.Lock()
+		cmd.placement = nil
+		cmd.placementMu.Unlock()
+
+		cmd.attempt++
+		cmd.restarts = 0
+		cmd.pulls = &pullTracker{}
+		if cmd.Hooks != nil {
+			cmd.hooks = &hookTracker{hooks: cmd.Hooks}
+		}
+		if err := cmd.create(); err != nil {
+			return err
+		}
+		atomic.StoreInt32(&cmd.deleting, 0)
+		err = cmd.wait()
+	}
+
+	return err
+}
@@ -0,0 +1,138 @@
+package exec_test
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	exec "github.com/engineerd/kube-exec"
+	"github.com/engineerd/kube-exec/kubeexectest"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name         string
+		codes        []int
+		wantAttempts int
+		wantCode     int
+	}{
+		{name: "success after a retried exit code", codes: []int{3, 0}, wantAttempts: 2},
+		{name: "exit code not retried", codes: []int{1}, wantAttempts: 1, wantCode: 1},
+		{name: "all attempts failed", codes: []int{3, 3, 3}, wantAttempts: 3, wantCode: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := kubeexectest.New()
+
+			var mu sync.Mutex
+			attempt := 0
+			backend.Handle(func(kubeexectest.Run) kubeexectest.Result {
+				mu.Lock()
+				defer mu.Unlock()
+
+				code := tt.codes[attempt]
+				attempt++
+				return kubeexectest.Result{Stdout: []byte("attempt\n"), ExitCode: code}
+			})
+
+			cfg := exec.Config{
+				Client:       backend.Client,
+				Namespace:    "test",
+				GenerateName: "run-",
+				Image:        "busybox",
+				Retry:        &exec.RetryPolicy{ExitCodes: []int{3}, Backoff: time.Millisecond},
+			}
+			cmd := exec.Command(cfg, "make", "test")
+			var stdout bytes.Buffer
+			cmd.Stdout = &stdout
+
+			err := cmd.Run()
+			var exitErr *exec.ExitError
+			switch {
+			case tt.wantCode == 0 && err != nil:
+				t.Fatalf("Run() = %v, want nil", err)
+			case tt.wantCode != 0 && (!errors.As(err, &exitErr) || exitErr.Code != tt.wantCode):
+				t.Fatalf("Run() = %v, want exit code %d", err, tt.wantCode)
+			}
+
+			if cmd.Attempts() != tt.wantAttempts {
+				t.Errorf("Attempts() = %d, want %d", cmd.Attempts(), tt.wantAttempts)
+			}
+			// the output of every attempt is written
+			if got := bytes.Count(stdout.Bytes(), []byte("attempt\n")); got != tt.wantAttempts {
+				t.Errorf("output of %d attempts, want %d", got, tt.wantAttempts)
+			}
+
+			pods := backend.Pods()
+			if len(pods) != tt.wantAttempts {
+				t.Fatalf("%d pods, want %d", len(pods), tt.wantAttempts)
+			}
+			key := pods[0].Labels["kube-exec/idempotency-key"]
+			if key == "" {
+				t.Error("pod not labeled with an idempotency key")
+			}
+			for i, pod := range pods {
+				if got := pod.Labels["kube-exec/idempotency-key"]; got != key {
+					t.Errorf("idempotency key of attempt %d = %q, want %q", i+1, got, key)
+				}
+				if got, want := pod.Labels["kube-exec/attempt"], string(rune('1'+i)); got != want {
+					t.Errorf("attempt label of attempt %d = %q, want %q", i+1, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryPolicyOtherNodes(t *testing.T) {
+	backend := kubeexectest.New()
+
+	var mu sync.Mutex
+	attempt := 0
+	backend.Handle(func(kubeexectest.Run) kubeexectest.Result {
+		mu.Lock()
+		defer mu.Unlock()
+
+		attempt++
+		return kubeexectest.Result{Evicted: attempt == 1}
+	})
+
+	cfg := exec.Config{
+		Client:       backend.Client,
+		Namespace:    "test",
+		GenerateName: "run-",
+		Image:        "busybox",
+		Retry:        &exec.RetryPolicy{OtherNodes: true, Backoff: time.Millisecond},
+	}
+	cmd := exec.Command(cfg, "make", "test")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Run() = %v, want nil", err)
+	}
+	if cmd.Attempts() != 2 {
+		t.Errorf("Attempts() = %d, want 2", cmd.Attempts())
+	}
+
+	pods := backend.Pods()
+	if len(pods) != 2 {
+		t.Fatalf("%d pods, want 2", len(pods))
+	}
+	if pods[0].Spec.Affinity != nil {
+		t.Errorf("affinity of attempt 1 = %+v, want none", pods[0].Spec.Affinity)
+	}
+
+	// the node of the evicted pod is avoided by the next attempt
+	a := pods[1].Spec.Affinity
+	if a == nil || a.NodeAffinity == nil || a.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		t.Fatalf("affinity of attempt 2 = %+v, want a required node affinity", a)
+	}
+	want := []v1.NodeSelectorRequirement{{Key: "metadata.name", Operator: v1.NodeSelectorOpNotIn, Values: []string{"kubeexectest"}}}
+	for _, term := range a.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		if !reflect.DeepEqual(term.MatchFields, want) {
+			t.Errorf("fields of attempt 2 = %+v, want %+v", term.MatchFields, want)
+		}
+	}
+}
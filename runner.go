@@ -0,0 +1,255 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// defaultRetryBackoff is the time to wait before retrying a command, if not
+// set in the runner options
+const defaultRetryBackoff = 5 * time.Second
+
+// defaultRunnerQPS and defaultRunnerBurst are the rate limits of the clients
+// of the commands of a runner, if not set in the options or in the commands,
+// above the client-go defaults as every command makes many requests
+const (
+	defaultRunnerQPS   = 50
+	defaultRunnerBurst = 100
+)
+
+// RunnerOptions contains the options of a Runner
+type RunnerOptions struct {
+	// Concurrency bounds the number of commands running at the same time, and
+	// so the number of pods in flight. If zero, up to 10 commands run at the
+	// same time.
+	Concurrency int
+
+	// Retries is the number of times a failed command is run again.
+	Retries int
+
+	// RetryBackoff is the time to wait before running a failed command again,
+	// doubled after every attempt. If zero, it is 5 seconds.
+	RetryBackoff time.Duration
+
+	// Retry reports whether a command failing with err should be run again.
+	// If nil, commands are run again unless they ran and exited with a
+	// non-zero code, their context is done, or they were killed. Errors that
+	// a new pod would fail with again are not retried either: those of kinds
+	// ErrInvalidConfig, ErrClientInit, ErrNamespaceNotFound, ErrAdmission,
+	// ErrPreflight and ErrPreemption, and those of kind ErrPodCreate unless
+	// the API failed with a transient error, such as a server timeout or too
+	// many requests, rather than refusing the pod.
+	Retry func(err error) bool
+
+	// QPS and Burst are the rate limits of the clients of the commands that
+	// set neither a Client nor rate limits. If zero, they are 50 and 100.
+	QPS   float32
+	Burst int
+}
+
+// RunResult is the result of a command run by a Runner
+type RunResult struct {
+	// ID is the ID the command was submitted with.
+	ID string
+
+	// Cmd is the command of the last attempt, holding its ProcessState.
+	Cmd *Cmd
+
+	// Attempts is the number of times the command was run.
+	Attempts int
+
+	// Err is the error returned by the last attempt, if any.
+	Err error
+}
+
+// runnerItem is a command waiting in the queue of a runner
+type runnerItem struct {
+	id  string
+	cmd *Cmd
+}
+
+// Runner runs the commands submitted to it with a pool of workers, queueing
+// the commands that exceed its concurrency, and sends their results on a
+// channel. A Runner is safe for concurrent use.
+type Runner struct {
+	opts    RunnerOptions
+	results chan RunResult
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []runnerItem
+	closed bool
+}
+
+// NewRunner returns a new runner and starts its workers.
+func NewRunner(opts RunnerOptions) *Runner {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = defaultConcurrency
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = defaultRetryBackoff
+	}
+	if opts.Retry == nil {
+		opts.Retry = retryable
+	}
+	if opts.QPS <= 0 {
+		opts.QPS = defaultRunnerQPS
+	}
+	if opts.Burst <= 0 {
+		opts.Burst = defaultRunnerBurst
+	}
+
+	r := &Runner{
+		opts:    opts,
+		results: make(chan RunResult, opts.Concurrency),
+	}
+	r.cond = sync.NewCond(&r.mu)
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.work()
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(r.results)
+	}()
+
+	return r
+}
+
+// Submit queues a command, which must not be started, to be run with the given
+// ID. Every attempt runs a copy of the command: its Stdout and Stderr are shared
+// by all attempts, the input set with StdinBytes, StdinString or StdinFile is
+// sent again to each of them, while another Stdin is only read once, and its
+// pipes must not be used. The command itself is never started.
+func (r *Runner) Submit(id string, cmd *Cmd) error {
+	if cmd.done != nil {
+		return errors.New("exec: Submit of a started command")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return errors.New("exec: Submit after Close")
+	}
+
+	cfg := &cmd.Cfg
+	if cfg.Client == nil && cfg.QPS == 0 && cfg.Burst == 0 && cfg.RateLimiter == nil {
+		cfg.QPS, cfg.Burst = r.opts.QPS, r.opts.Burst
+	}
+
+	r.queue = append(r.queue, runnerItem{id: id, cmd: cmd})
+	r.cond.Signal()
+
+	return nil
+}
+
+// Results returns the channel the results of the commands are sent on, in the
+// order they complete. It is closed once the runner is closed and all queued
+// commands completed. The results must be received for the workers to proceed.
+func (r *Runner) Results() <-chan RunResult {
+	return r.results
+}
+
+// Close stops accepting commands. The commands already queued are still run.
+func (r *Runner) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closed = true
+	r.cond.Broadcast()
+}
+
+// Len returns the number of commands queued and not running yet.
+func (r *Runner) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.queue)
+}
+
+// work runs the queued commands until the runner is closed and the queue is
+// empty
+func (r *Runner) work() {
+	for {
+		r.mu.Lock()
+		for len(r.queue) == 0 && !r.closed {
+			r.cond.Wait()
+		}
+		if len(r.queue) == 0 {
+			r.mu.Unlock()
+			return
+		}
+		item := r.queue[0]
+		r.queue = r.queue[1:]
+		r.mu.Unlock()
+
+		r.results <- r.run(item)
+	}
+}
+
+// run runs a command, and runs it again on failure as allowed by the options
+func (r *Runner) run(item runnerItem) RunResult {
+	backoff := r.opts.RetryBackoff
+
+	// the file set by StdinFile is opened again by every attempt
+	defer closeAll(item.cmd.closeAfterWait)
+
+	for attempt := 1; ; attempt++ {
+		cmd, err := item.cmd.clone()
+		if err != nil {
+			return RunResult{ID: item.id, Cmd: item.cmd, Attempts: attempt - 1, Err: err}
+		}
+		err = cmd.Run()
+
+		result := RunResult{ID: item.id, Cmd: cmd, Attempts: attempt, Err: err}
+		if err == nil || attempt > r.opts.Retries || !r.opts.Retry(err) {
+			return result
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-cmd.ctx.Done():
+			return result
+		}
+		backoff *= 2
+	}
+}
+
+// permanentKinds are the kinds of errors that a new attempt would fail with
+// again, as they do not depend on the pod
+var permanentKinds = []error{ErrInvalidConfig, ErrClientInit, ErrNamespaceNotFound, ErrAdmission, ErrPreflight, ErrPreemption}
+
+// retryable reports whether a command failing with err can be run again: it
+// did not exit with a non-zero code, its context is not done, it was not
+// killed, its error is not of a permanent kind, and its pod could not be
+// created only because of a transient error of the API
+func retryable(err error) bool {
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrKilled) {
+		return false
+	}
+	for _, kind := range permanentKinds {
+		if errors.Is(err, kind) {
+			return false
+		}
+	}
+
+	var kubeErr *Error
+	if errors.As(err, &kubeErr) && kubeErr.Kind == ErrPodCreate {
+		return transient(kubeErr.Err)
+	}
+
+	return true
+}
@@ -0,0 +1,111 @@
+package exec_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	exec "github.com/engineerd/kube-exec"
+	"github.com/engineerd/kube-exec/kubeexectest"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestRunnerRetrySendsStdinAgain(t *testing.T) {
+	backend := kubeexectest.New()
+
+	var mu sync.Mutex
+	attempts := 0
+	backend.Handle(func(kubeexectest.Run) kubeexectest.Result {
+		mu.Lock()
+		defer mu.Unlock()
+
+		attempts++
+		if attempts == 1 {
+			return kubeexectest.Result{Err: errors.New("stream reset")}
+		}
+		return kubeexectest.Result{}
+	})
+
+	cfg := exec.Config{Client: backend.Client, Namespace: "test", GenerateName: "run-", Image: "busybox"}
+	cmd := exec.Command(cfg, "cat")
+	cmd.DisableReconnect = true
+	if err := cmd.StdinString("input"); err != nil {
+		t.Fatal(err)
+	}
+
+	r := exec.NewRunner(exec.RunnerOptions{Retries: 1, RetryBackoff: time.Millisecond})
+	if err := r.Submit("cat", cmd); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	result := <-r.Results()
+	if result.Err != nil {
+		t.Fatalf("result error = %v", result.Err)
+	}
+	if result.Attempts != 2 {
+		t.Errorf("attempts = %d, want 2", result.Attempts)
+	}
+
+	runs := backend.Runs()
+	if len(runs) != 2 {
+		t.Fatalf("%d runs, want 2", len(runs))
+	}
+	for i, run := range runs {
+		if string(run.Stdin) != "input" {
+			t.Errorf("stdin of attempt %d = %q, want %q", i+1, run.Stdin, "input")
+		}
+	}
+}
+
+func TestRunnerDefaultRetry(t *testing.T) {
+	podsResource := schema.GroupResource{Resource: "pods"}
+
+	tests := []struct {
+		name         string
+		image        string
+		result       kubeexectest.Result
+		createErr    error
+		wantAttempts int
+	}{
+		{name: "stream failure", image: "busybox", result: kubeexectest.Result{Err: errors.New("stream reset")}, wantAttempts: 2},
+		{name: "non-zero exit code", image: "busybox", result: kubeexectest.Result{ExitCode: 1}, wantAttempts: 1},
+		{name: "invalid config", wantAttempts: 1},
+		{name: "pod refused", image: "busybox", createErr: apierrors.NewForbidden(podsResource, "", errors.New("quota exceeded")), wantAttempts: 1},
+		{name: "pod not created in time", image: "busybox", createErr: apierrors.NewServerTimeout(podsResource, "create", 1), wantAttempts: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := kubeexectest.New()
+			backend.SetResult("make", tt.result)
+			if tt.createErr != nil {
+				backend.Clientset.PrependReactor("create", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, tt.createErr
+				})
+			}
+
+			cfg := exec.Config{Client: backend.Client, Namespace: "test", GenerateName: "run-", Image: tt.image}
+			cmd := exec.Command(cfg, "make")
+			cmd.DisableReconnect = true
+
+			r := exec.NewRunner(exec.RunnerOptions{Retries: 1, RetryBackoff: time.Millisecond})
+			if err := r.Submit("make", cmd); err != nil {
+				t.Fatal(err)
+			}
+			r.Close()
+
+			result := <-r.Results()
+			if result.Err == nil {
+				t.Fatal("result error = nil, want an error")
+			}
+			if result.Attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d (error: %v)", result.Attempts, tt.wantAttempts, result.Err)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package exec
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+const (
+	// labelManagedBy is the label identifying the pods and jobs created by the
+	// package, with the value managedBy
+	labelManagedBy = "app.kubernetes.io/managed-by"
+	managedBy      = "kube-exec"
+
+	// labelRunID is the label of the ID of the run of a command, shared by the
+	// pods of all its attempts, or of its job
+	labelRunID = "kube-exec/run-id"
+
+	// labelJobName is the label set by the job controller on the pods of a job
+	labelJobName = "job-name"
+)
+
+// RunStatus describes a run of a command, found from the labels of its pods,
+// so it can be tracked from another process than the one that started it.
+type RunStatus struct {
+	// ID is the run ID of the command.
+	ID        string
+	Namespace string
+
+	// Pod is the name of the latest pod of the run, and Job the name of its
+	// job if it runs as a job.
+	Pod string
+	Job string
+
+	// Pods is the number of pods of the run: one per attempt, or per pod of
+	// the job.
+	Pods int
+
+	// Phase is the phase of the latest pod of the run.
+	Phase v1.PodPhase
+
+	// Created is the time the first pod of the run was created.
+	Created time.Time
+
+	// State is the state of the command in the latest pod, or nil if it did
+	// not terminate.
+	State *ProcessState
+}
+
+// RunID returns the run ID of the command, which labels its pods and job, or
+// an empty string if the command is not started.
+func (cmd *Cmd) RunID() string {
+	return cmd.runID
+}
+
+// runLabels returns the labels of the pods and jobs of a run, with only the
+// label of the package if the run ID is not known yet
+func runLabels(runID string) map[string]string {
+	labels := map[string]string{labelManagedBy: managedBy}
+	if runID != "" {
+		labels[labelRunID] = runID
+	}
+
+	return labels
+}
+
+// newRunID returns a new unique run ID
+func newRunID() string {
+	return string(uuid.NewUUID())
+}
+
+// ListRuns returns the runs of commands with pods in the namespace, or in all
+// namespaces if it is empty, oldest first. The pods that are deleted, once
+// cleaned up, are not listed anymore.
+func (c *Client) ListRuns(namespace string) ([]RunStatus, error) {
+	return c.listRuns(namespace, labelManagedBy+"="+managedBy)
+}
+
+// Status returns the status of the run with the given ID, in any namespace.
+func (c *Client) Status(runID string) (*RunStatus, error) {
+	runs, err := c.listRuns(metav1.NamespaceAll, labelRunID+"="+runID)
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		return nil, fmt.Errorf("run %s not found", runID)
+	}
+
+	return &runs[0], nil
+}
+
+// DeleteRun deletes the pods of the run with the given ID, and its job if it
+// runs as a job.
+func (c *Client) DeleteRun(runID string) error {
+	pods, err := c.clientset.CoreV1().Pods(metav1.NamespaceAll).List(metav1.ListOptions{LabelSelector: labelRunID + "=" + runID})
+	if err != nil {
+		return err
+	}
+	if len(pods.Items) == 0 {
+		return fmt.Errorf("run %s not found", runID)
+	}
+
+	propagation := metav1.DeletePropagationBackground
+	deletedJobs := map[string]bool{}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+
+		job := pod.Labels[labelJobName]
+		if job == "" {
+			err = c.deletePod(pod, nil)
+		} else if !deletedJobs[job] {
+			deletedJobs[job] = true
+			err = c.clientset.BatchV1().Jobs(pod.Namespace).Delete(job, &metav1.DeleteOptions{PropagationPolicy: &propagation})
+		}
+		if err != nil {
+			return fmt.Errorf("cannot delete run %s: %w", runID, err)
+		}
+	}
+
+	return nil
+}
+
+// listRuns returns the runs of the pods matching the label selector
+func (c *Client) listRuns(namespace, selector string) ([]RunStatus, error) {
+	pods, err := c.clientset.CoreV1().Pods(namespace).List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(pods.Items, func(i, j int) bool {
+		return pods.Items[i].CreationTimestamp.Before(&pods.Items[j].CreationTimestamp)
+	})
+
+	var runs []RunStatus
+	index := map[string]int{}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+
+		id := pod.Labels[labelRunID]
+		if id == "" {
+			continue
+		}
+
+		key := pod.Namespace + "/" + id
+		j, ok := index[key]
+		if !ok {
+			j = len(runs)
+			index[key] = j
+			runs = append(runs, RunStatus{ID: id, Namespace: pod.Namespace, Created: pod.CreationTimestamp.Time})
+		}
+
+		r := &runs[j]
+		r.Pod = pod.Name
+		r.Job = pod.Labels[labelJobName]
+		r.Pods++
+		r.Phase = pod.Status.Phase
+		r.State = nil
+		if state := terminatedState(pod); state != nil {
+			r.State = newProcessState(commandStatus(pod), state)
+		}
+	}
+
+	return runs, nil
+}
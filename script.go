@@ -0,0 +1,150 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// scriptVolume is the name of the volume of the config map of the script,
+	// mounted at scriptDir, with the script as scriptKey
+	scriptVolume = "kube-exec-script"
+	scriptDir    = "/kube-exec"
+	scriptKey    = "script"
+
+	// scriptConfigMapPrefix is the prefix of the names of the config maps of
+	// the scripts
+	scriptConfigMapPrefix = "kube-exec-script-"
+)
+
+// ScriptPath is the path of the script of a command in its container.
+const ScriptPath = scriptDir + "/" + scriptKey
+
+// Script returns the Cmd struct to execute the script with the interpreter,
+// such as "/bin/sh" or "python3", and the given arguments. The script is stored
+// in a config map mounted in the pod, instead of being passed as an argument,
+// so long scripts are not bound by the limits on the length of arguments nor
+// need quoting. The config map is deleted once the command terminates.
+func Script(cfg Config, interpreter, script string, arg ...string) *Cmd {
+	cmd := Command(cfg, interpreter, arg...)
+	cmd.Script = script
+
+	return cmd
+}
+
+// ScriptContext is like Script but includes a context.
+func ScriptContext(ctx context.Context, cfg Config, interpreter, script string, arg ...string) *Cmd {
+	cmd := CommandContext(ctx, cfg, interpreter, arg...)
+	cmd.Script = script
+
+	return cmd
+}
+
+// scriptArgs returns the arguments of the command, after the path of its
+// script if it has one
+func (cmd *Cmd) scriptArgs() []string {
+	if cmd.Script == "" {
+		return cmd.Args
+	}
+
+	return append([]string{ScriptPath}, cmd.Args...)
+}
+
+// addScript mounts the config map of the script of the command in c
+func addScript(cmd *Cmd, spec *v1.PodSpec, c *v1.Container) {
+	if cmd.Script == "" {
+		return
+	}
+
+	name := scriptConfigMapPrefix
+	if cmd.script != nil {
+		name = cmd.script.Name
+	}
+	mode := int32(0555)
+	spec.Volumes = append(spec.Volumes, v1.Volume{
+		Name: scriptVolume,
+		VolumeSource: v1.VolumeSource{
+			ConfigMap: &v1.ConfigMapVolumeSource{
+				LocalObjectReference: v1.LocalObjectReference{Name: name},
+				DefaultMode:          &mode,
+			},
+		},
+	})
+	c.VolumeMounts = append(c.VolumeMounts, v1.VolumeMount{
+		Name:      scriptVolume,
+		MountPath: path.Dir(ScriptPath),
+		ReadOnly:  true,
+	})
+}
+
+// createScript creates the config map of the script of the command, before
+// its pod
+func (cmd *Cmd) createScript() error {
+	if cmd.Script == "" || cmd.script != nil || cmd.Cfg.DryRun {
+		return nil
+	}
+
+	client, err := cmd.kubernetes("Script")
+	if err != nil {
+		return err
+	}
+
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: scriptConfigMapPrefix,
+			Namespace:    cmd.Cfg.Namespace,
+			Labels:       runLabels(cmd.runID),
+		},
+		Data: map[string]string{scriptKey: cmd.Script},
+	}
+	// the request is kept on failure, the client returns an empty object
+	var created *v1.ConfigMap
+	err = retryNameCollision(&cm.ObjectMeta, func() (err error) {
+		created, err = client.clientset.CoreV1().ConfigMaps(cmd.Cfg.Namespace).Create(cm)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("cannot create config map of script: %w", err)
+	}
+	cmd.script = created
+	cmd.log.Debug("script config map created", "namespace", created.Namespace, "configMap", created.Name)
+
+	return nil
+}
+
+// ownScript adds the given owner to the config map of the script, so it is
+// garbage collected once all its owners are deleted
+func (cmd *Cmd) ownScript(owner metav1.OwnerReference) {
+	if cmd.script == nil {
+		return
+	}
+
+	cm := cmd.script
+	cm.OwnerReferences = append(cm.OwnerReferences, owner)
+	updated, err := cmd.client.clientset.CoreV1().ConfigMaps(cm.Namespace).Update(cm)
+	if err != nil {
+		cmd.log.Warn("cannot set owner of script config map", "namespace", cm.Namespace, "configMap", cm.Name, "error", err)
+		return
+	}
+	cmd.script = updated
+}
+
+// deleteScript deletes the config map of the script of the command
+func (cmd *Cmd) deleteScript() {
+	if cmd.script == nil {
+		return
+	}
+
+	cm := cmd.script
+	cmd.script = nil
+	err := cmd.client.clientset.CoreV1().ConfigMaps(cm.Namespace).Delete(cm.Name, &metav1.DeleteOptions{})
+	if err != nil {
+		cmd.log.Warn("cannot delete script config map", "namespace", cm.Namespace, "configMap", cm.Name, "error", err)
+		return
+	}
+	cmd.log.Debug("script config map deleted", "namespace", cm.Namespace, "configMap", cm.Name)
+}
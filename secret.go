@@ -0,0 +1,138 @@
+package exec
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EphemeralSecret is a secret created for a single command, such as an API
+// token or an SSH key, and deleted once the command terminates. It is owned by
+// the pods of the command, or by its job, so it is garbage collected with them
+// even if the program never gets to delete it.
+type EphemeralSecret struct {
+	// Name is the prefix of the name of the secret, which is generated so
+	// concurrent commands do not collide.
+	Name string
+
+	// Data are the keys and values of the secret.
+	Data map[string][]byte
+
+	// MountPath, if not empty, is the directory the keys are mounted at, as
+	// read-only files. Otherwise, the keys are set as env variables.
+	MountPath string
+}
+
+// WithEphemeralSecret adds a secret created for the command with the given
+// data, whose keys are set as env variables. To mount the keys as files
+// instead, set a MountPath in EphemeralSecrets.
+func (cfg *Config) WithEphemeralSecret(name string, data map[string][]byte) {
+	cfg.EphemeralSecrets = append(cfg.EphemeralSecrets, EphemeralSecret{Name: name, Data: data})
+}
+
+// secretName returns the name of the i-th ephemeral secret of the command, as
+// created, or its prefix if it is not created yet
+func (cmd *Cmd) secretName(i int) string {
+	if i < len(cmd.secrets) {
+		return cmd.secrets[i].Name
+	}
+
+	return cmd.Cfg.EphemeralSecrets[i].Name
+}
+
+// addEphemeralSecrets exposes the ephemeral secrets of the command in c
+func addEphemeralSecrets(cmd *Cmd, spec *v1.PodSpec, c *v1.Container) {
+	for i, s := range cmd.Cfg.EphemeralSecrets {
+		name := cmd.secretName(i)
+		if s.MountPath == "" {
+			c.EnvFrom = append(c.EnvFrom, v1.EnvFromSource{
+				SecretRef: &v1.SecretEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: name}},
+			})
+			continue
+		}
+
+		volume := fmt.Sprintf("ephemeral-secret-%d", i)
+		spec.Volumes = append(spec.Volumes, v1.Volume{
+			Name: volume,
+			VolumeSource: v1.VolumeSource{
+				Secret: &v1.SecretVolumeSource{SecretName: name},
+			},
+		})
+		c.VolumeMounts = append(c.VolumeMounts, v1.VolumeMount{
+			Name:      volume,
+			MountPath: s.MountPath,
+			ReadOnly:  true,
+		})
+	}
+}
+
+// createSecrets creates the ephemeral secrets of the command, before its pod
+func (cmd *Cmd) createSecrets() error {
+	if len(cmd.Cfg.EphemeralSecrets) == 0 || cmd.Cfg.DryRun {
+		return nil
+	}
+
+	for _, s := range cmd.Cfg.EphemeralSecrets {
+		secret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				GenerateName: s.Name + "-",
+				Namespace:    cmd.Cfg.Namespace,
+				Labels:       runLabels(cmd.runID),
+			},
+			Data: s.Data,
+			Type: v1.SecretTypeOpaque,
+		}
+		// the request is kept on failure, the client returns an empty object
+		var created *v1.Secret
+		err := retryNameCollision(&secret.ObjectMeta, func() (err error) {
+			created, err = cmd.client.clientset.CoreV1().Secrets(cmd.Cfg.Namespace).Create(secret)
+			return err
+		})
+		if err != nil {
+			cmd.deleteSecrets()
+			return fmt.Errorf("cannot create secret %s: %w", s.Name, err)
+		}
+		cmd.secrets = append(cmd.secrets, created)
+		cmd.log.Debug("secret created", "namespace", created.Namespace, "secret", created.Name)
+	}
+
+	return nil
+}
+
+// ownSecrets adds the given owner to the ephemeral secrets of the command, so
+// they are garbage collected once all their owners are deleted
+func (cmd *Cmd) ownSecrets(owner metav1.OwnerReference) {
+	for i, secret := range cmd.secrets {
+		secret.OwnerReferences = append(secret.OwnerReferences, owner)
+		updated, err := cmd.client.clientset.CoreV1().Secrets(secret.Namespace).Update(secret)
+		if err != nil {
+			cmd.log.Warn("cannot set owner of secret", "namespace", secret.Namespace, "secret", secret.Name, "error", err)
+			continue
+		}
+		cmd.secrets[i] = updated
+	}
+}
+
+// ownerReference returns a reference to the object, as owner of others
+func ownerReference(apiVersion, kind string, meta metav1.ObjectMeta) metav1.OwnerReference {
+	return metav1.OwnerReference{
+		APIVersion: apiVersion,
+		Kind:       kind,
+		Name:       meta.Name,
+		UID:        meta.UID,
+	}
+}
+
+// deleteSecrets deletes the ephemeral secrets of the command
+func (cmd *Cmd) deleteSecrets() {
+	for _, secret := range cmd.secrets {
+		err := cmd.client.clientset.CoreV1().Secrets(secret.Namespace).Delete(secret.Name, &metav1.DeleteOptions{})
+		if err != nil {
+			cmd.log.Warn("cannot delete secret", "namespace", secret.Namespace, "secret", secret.Name, "error", err)
+			continue
+		}
+		cmd.log.Debug("secret deleted", "namespace", secret.Namespace, "secret", secret.Name)
+	}
+	cmd.secrets = nil
+}
@@ -0,0 +1,192 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// defaultConcurrency is the number of pods a command runs in at the same time
+// with RunOnSelector, if not set in the options
+const defaultConcurrency = 10
+
+// SelectorOptions contains the options for running a command in the pods
+// matching a selector
+type SelectorOptions struct {
+	Kubeconfig string
+
+	// Client is the client used to reach the cluster. If nil, the shared
+	// client for Kubeconfig is used.
+	Client *Client
+
+	// Logger receives the events of the sessions. If nil, events are discarded.
+	Logger Logger
+
+	// Container is the container of the pods to execute in. If empty, the first
+	// container of each pod is used.
+	Container string
+
+	// Stdout and Stderr receive the output of all pods, each line prefixed
+	// with the name of the pod.
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Concurrency bounds the number of pods the command runs in at the same time.
+	// If zero, the command runs in up to 10 pods at the same time.
+	Concurrency int
+}
+
+// PodResult is the result of a command executed in one of the pods matching
+// a selector
+type PodResult struct {
+	Pod string
+
+	// ExitCode is the exit code of the command, or -1 if it could not be
+	// executed.
+	ExitCode int
+
+	// Err is the error returned executing the command, if any.
+	Err error
+}
+
+// RunOnSelector executes a command in every running pod matching the label
+// selector, like ExecInPod, with bounded concurrency. The output of every
+// pod is streamed with a "[pod] " prefix on every line.
+//
+// It returns the results of all pods, and a *SelectorError if the command
+// failed in any of them.
+func RunOnSelector(ctx context.Context, namespace, labelSelector string, command []string, opts SelectorOptions) ([]PodResult, error) {
+	client, err := getClient(opts.Client, kubeconfigKey{path: opts.Kubeconfig}, loggerOrNop(opts.Logger))
+	if err != nil {
+		return nil, err
+	}
+
+	if namespace == "" {
+		namespace = client.namespace
+	}
+
+	list, err := client.clientset.CoreV1().Pods(namespace).List(metav1.ListOptions{LabelSelector: labelSelector})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list pods: %w", err)
+	}
+
+	var pods []string
+	for _, p := range list.Items {
+		if p.Status.Phase == v1.PodRunning {
+			pods = append(pods, p.Name)
+		}
+	}
+
+	return runInPods(ctx, client, namespace, pods, command, opts)
+}
+
+// runInPods executes a command in the given pods, with the output of every pod
+// prefixed with its name
+func runInPods(ctx context.Context, client *Client, namespace string, pods []string, command []string, opts SelectorOptions) ([]PodResult, error) {
+	stdout, stderr := opts.Stdout, opts.Stderr
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+	if stderr == nil {
+		stderr = ioutil.Discard
+	}
+	stdout = &lockedWriter{w: stdout}
+	stderr = &lockedWriter{w: stderr}
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+
+	results := make([]PodResult, len(pods))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i, pod := range pods {
+		wg.Add(1)
+		go func(i int, pod string) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			prefix := fmt.Sprintf("[%s] ", pod)
+			out := &prefixWriter{w: stdout, prefix: prefix}
+			errOut := &prefixWriter{w: stderr, prefix: prefix}
+
+			err := ExecInPod(ctx, namespace, pod, opts.Container, command, ExecOptions{
+				Client: client,
+				Logger: opts.Logger,
+				Stdout: out,
+				Stderr: errOut,
+			})
+			out.Flush()
+			errOut.Flush()
+
+			results[i] = PodResult{Pod: pod, Err: err}
+			switch e := err.(type) {
+			case nil:
+			case *ExitError:
+				results[i].ExitCode = e.Code
+			default:
+				results[i].ExitCode = -1
+			}
+		}(i, pod)
+	}
+
+	wg.Wait()
+
+	for _, r := range results {
+		if r.Err != nil {
+			return results, &SelectorError{Results: results}
+		}
+	}
+
+	return results, nil
+}
+
+// prefixWriter writes the lines written to it to w, each with a prefix. Lines
+// ending with CRLF, as written by Windows programs, are written ending with LF.
+type prefixWriter struct {
+	w      io.Writer
+	prefix string
+	buf    bytes.Buffer
+}
+
+// Write writes the complete lines of p, and keeps the last incomplete line
+// until it is completed or flushed
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf.Write(b)
+
+	for {
+		i := bytes.IndexByte(p.buf.Bytes(), '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+
+		line := bytes.TrimSuffix(p.buf.Next(i+1), []byte("\r\n"))
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		if _, err := p.w.Write(append(append([]byte(p.prefix), line...), '\n')); err != nil {
+			return len(b), err
+		}
+	}
+}
+
+// Flush writes the last incomplete line, if any
+func (p *prefixWriter) Flush() error {
+	if p.buf.Len() == 0 {
+		return nil
+	}
+
+	line := append([]byte(p.prefix), p.buf.Bytes()...)
+	p.buf.Reset()
+
+	_, err := p.w.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,287 @@
+package exec
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+const (
+	// asciicastVersion is the version of the asciicast format of asciinema
+	// sessions are recorded in
+	asciicastVersion = 2
+
+	// defaultWidth and defaultHeight are the size of the terminal of a session
+	// recorded without a local terminal
+	defaultWidth  = 80
+	defaultHeight = 24
+)
+
+// Types of the events of a recorded session.
+const (
+	EventOutput = "o"
+	EventInput  = "i"
+	EventResize = "r"
+)
+
+// Recording is a terminal session recorded with SessionRecording, in the
+// asciicast v2 format of asciinema.
+type Recording struct {
+	// Width and Height are the initial size of the terminal.
+	Width  int
+	Height int
+
+	// Timestamp is the time the session started.
+	Timestamp time.Time
+
+	// Title is the command of the session.
+	Title string
+
+	Events []RecordingEvent
+}
+
+// RecordingEvent is an event of a recorded session
+type RecordingEvent struct {
+	// Time is the time of the event since the start of the session.
+	Time time.Duration
+
+	// Type is EventOutput, EventInput or EventResize.
+	Type string
+
+	// Data is the output or input of the event, or the new size of the
+	// terminal, as "WIDTHxHEIGHT".
+	Data string
+}
+
+// asciicastHeader is the first line of an asciicast v2 recording
+type asciicastHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// ReadRecording reads a session recorded in the asciicast v2 format.
+func ReadRecording(r io.Reader) (*Recording, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16*1024*1024)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("exec: empty recording")
+	}
+	var header asciicastHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return nil, fmt.Errorf("invalid recording header: %w", err)
+	}
+	if header.Version != asciicastVersion {
+		return nil, fmt.Errorf("exec: unsupported recording version %d", header.Version)
+	}
+
+	rec := &Recording{Width: header.Width, Height: header.Height, Title: header.Title}
+	if header.Timestamp > 0 {
+		rec.Timestamp = time.Unix(header.Timestamp, 0)
+	}
+
+	for line := 2; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+
+		var event [3]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, fmt.Errorf("invalid recording event on line %d: %w", line, err)
+		}
+		t, ok1 := event[0].(float64)
+		typ, ok2 := event[1].(string)
+		data, ok3 := event[2].(string)
+		if !ok1 || !ok2 || !ok3 {
+			return nil, fmt.Errorf("exec: invalid recording event on line %d", line)
+		}
+
+		rec.Events = append(rec.Events, RecordingEvent{
+			Time: time.Duration(t * float64(time.Second)),
+			Type: typ,
+			Data: data,
+		})
+	}
+
+	return rec, scanner.Err()
+}
+
+// Play writes the output of the session to w, with its original timing divided
+// by speed, or 1 if not positive, until all the output is written or the
+// context is done. Pauses are capped to maxIdle, if not zero.
+func (rec *Recording) Play(ctx context.Context, w io.Writer, speed float64, maxIdle time.Duration) error {
+	if speed <= 0 {
+		speed = 1
+	}
+
+	var last time.Duration
+	for _, e := range rec.Events {
+		if e.Type != EventOutput {
+			continue
+		}
+
+		wait := e.Time - last
+		if maxIdle > 0 && wait > maxIdle {
+			wait = maxIdle
+		}
+		last = e.Time
+
+		if wait > 0 {
+			select {
+			case <-time.After(time.Duration(float64(wait) / speed)):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if _, err := io.WriteString(w, e.Data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sessionRecorder writes the events of a session to a writer, in the
+// asciicast v2 format. Once writing fails, the session is no longer recorded,
+// without failing it.
+type sessionRecorder struct {
+	start time.Time
+
+	mu  sync.Mutex
+	w   io.Writer
+	err error
+}
+
+// newSessionRecorder returns a recorder writing the header of the session to
+// w, with the given size of its terminal, if not nil
+func newSessionRecorder(w io.Writer, size *remotecommand.TerminalSize, title string) *sessionRecorder {
+	r := &sessionRecorder{start: time.Now(), w: w}
+
+	header := asciicastHeader{
+		Version:   asciicastVersion,
+		Width:     defaultWidth,
+		Height:    defaultHeight,
+		Timestamp: r.start.Unix(),
+		Title:     title,
+	}
+	if size != nil {
+		header.Width, header.Height = int(size.Width), int(size.Height)
+	}
+	if term := os.Getenv("TERM"); term != "" {
+		header.Env = map[string]string{"TERM": term}
+	}
+
+	r.write(header)
+	return r
+}
+
+// event records an event of the given type
+func (r *sessionRecorder) event(typ, data string) {
+	t := time.Since(r.start).Seconds()
+	r.write([]interface{}{json.Number(strconv.FormatFloat(t, 'f', 6, 64)), typ, data})
+}
+
+// write writes a line of the recording
+func (r *sessionRecorder) write(v interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return
+	}
+	b, err := json.Marshal(v)
+	if err == nil {
+		_, err = r.w.Write(append(b, '\n'))
+	}
+	r.err = err
+}
+
+// writer returns a writer recording the data written to it as events of the
+// given type
+func (r *sessionRecorder) writer(typ string) *eventWriter {
+	return &eventWriter{rec: r, typ: typ}
+}
+
+// wrap records the output, the resizes and, if input is set, the input of
+// the streams of the request
+func (r *sessionRecorder) wrap(req *StreamRequest, input bool) {
+	if req.Stdout != nil {
+		req.Stdout = io.MultiWriter(req.Stdout, r.writer(EventOutput))
+	}
+	if req.Stderr != nil {
+		req.Stderr = io.MultiWriter(req.Stderr, r.writer(EventOutput))
+	}
+	if input && req.Stdin != nil {
+		req.Stdin = io.TeeReader(req.Stdin, r.writer(EventInput))
+	}
+	if req.SizeQueue != nil {
+		req.SizeQueue = &recordedSizeQueue{q: req.SizeQueue, rec: r}
+	}
+}
+
+// eventWriter records the data written to it as events, holding back the
+// bytes of an incomplete UTF-8 character until it is completed, as events are
+// JSON strings
+type eventWriter struct {
+	rec *sessionRecorder
+	typ string
+
+	mu      sync.Mutex
+	pending []byte
+}
+
+func (w *eventWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	data := append(w.pending, p...)
+	n := len(data)
+	for i := n - 1; i >= 0 && i >= n-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				n = i
+			}
+			break
+		}
+	}
+
+	w.pending = append([]byte{}, data[n:]...)
+	if n > 0 {
+		w.rec.event(w.typ, string(data[:n]))
+	}
+
+	return len(p), nil
+}
+
+// recordedSizeQueue records the sizes of a terminal size queue
+type recordedSizeQueue struct {
+	q   remotecommand.TerminalSizeQueue
+	rec *sessionRecorder
+}
+
+func (q *recordedSizeQueue) Next() *remotecommand.TerminalSize {
+	size := q.q.Next()
+	if size != nil {
+		q.rec.event(EventResize, fmt.Sprintf("%dx%d", size.Width, size.Height))
+	}
+	return size
+}
@@ -0,0 +1,151 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// completionIndexEnv is the env variable of the index of a shard, named
+	// after the one set in the pods of indexed jobs
+	completionIndexEnv = "JOB_COMPLETION_INDEX"
+
+	// labelShardIndex is the label of the index of the shard of a pod
+	labelShardIndex = "kube-exec/shard-index"
+)
+
+// ShardOptions contains the options for running a command in shards
+type ShardOptions struct {
+	// Shards is the number of shards, indexed from 0.
+	Shards int
+
+	// Parallelism bounds the number of shards running at the same time. If
+	// zero, all the shards run at the same time.
+	Parallelism int
+
+	// Env is added to the environment of the command of every shard.
+	Env []string
+
+	// Stdout and Stderr receive the output of all shards, each line prefixed
+	// with the index of the shard.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// ShardResult is the result of a shard of a command run by RunShards
+type ShardResult struct {
+	Index int
+
+	// Pod is the name of the pod of the shard, if it was created.
+	Pod string
+
+	// ExitCode is the exit code of the command, or -1 if it could not run.
+	ExitCode int
+
+	// Err is the error returned running the command, if any.
+	Err error
+}
+
+// RunShards runs the command in the given number of shards, for parallel work
+// such as splitting tests, each in its own pod with the index of the shard in
+// the JOB_COMPLETION_INDEX env variable, and labeled kube-exec/shard-index.
+// The output of every shard is streamed with a "[index] " prefix on every line.
+//
+// Indexed jobs are not supported by the version of the Kubernetes API of the
+// package, so the shards run as commands with their own pods, which also
+// support the options of the config that jobs do not, such as Stdin and Retry.
+// With a Name in the config, the pods are named after it with the index of
+// their shard as suffix.
+//
+// It returns the results of all shards, and a *ShardError if the command
+// failed in any of them.
+func RunShards(ctx context.Context, cfg Config, opts ShardOptions, name string, arg ...string) ([]ShardResult, error) {
+	if opts.Shards <= 0 {
+		return nil, errors.New("exec: the number of shards must be positive")
+	}
+
+	stdout, stderr := opts.Stdout, opts.Stderr
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+	if stderr == nil {
+		stderr = ioutil.Discard
+	}
+	stdout = &lockedWriter{w: stdout}
+	stderr = &lockedWriter{w: stderr}
+
+	parallelism := opts.Parallelism
+	if parallelism <= 0 {
+		parallelism = opts.Shards
+	}
+
+	results := make([]ShardResult, opts.Shards)
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+
+	for i := 0; i < opts.Shards; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			prefix := fmt.Sprintf("[%d] ", i)
+			out := &prefixWriter{w: stdout, prefix: prefix}
+			errOut := &prefixWriter{w: stderr, prefix: prefix}
+
+			cmd := CommandContext(ctx, shardConfig(cfg, i), name, arg...)
+			cmd.Env = opts.Env
+			cmd.Stdout = out
+			cmd.Stderr = errOut
+
+			err := cmd.Run()
+			out.Flush()
+			errOut.Flush()
+
+			results[i] = ShardResult{Index: i, Pod: cmd.PodName(), Err: err}
+			var exitErr *ExitError
+			switch {
+			case err == nil:
+			case errors.As(err, &exitErr):
+				results[i].ExitCode = exitErr.Code
+			default:
+				results[i].ExitCode = -1
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	for _, r := range results {
+		if r.Err != nil {
+			return results, &ShardError{Results: results}
+		}
+	}
+
+	return results, nil
+}
+
+// shardConfig returns the config of the shard with the given index
+func shardConfig(cfg Config, index int) Config {
+	i := strconv.Itoa(index)
+
+	cfg.EnvVars = append(append([]v1.EnvVar{}, cfg.EnvVars...), v1.EnvVar{Name: completionIndexEnv, Value: i})
+	cfg.Mutators = append(append([]PodMutator{}, cfg.Mutators...), func(pod *v1.Pod) error {
+		pod.Labels = setLabel(pod.Labels, labelShardIndex, i)
+		return nil
+	})
+	if cfg.Name != "" {
+		cfg.Name += "-" + i
+	}
+
+	return cfg
+}
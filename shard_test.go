@@ -0,0 +1,82 @@
+package exec_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	exec "github.com/engineerd/kube-exec"
+	"github.com/engineerd/kube-exec/kubeexectest"
+)
+
+func TestRunShards(t *testing.T) {
+	backend := kubeexectest.New()
+	backend.Handle(func(run kubeexectest.Run) kubeexectest.Result {
+		index := ""
+		for _, c := range run.Pod.Spec.Containers {
+			for _, env := range c.Env {
+				if c.Name == run.Container && env.Name == "JOB_COMPLETION_INDEX" {
+					index = env.Value
+				}
+			}
+		}
+
+		// the last line is not terminated, and the last shard fails
+		r := kubeexectest.Result{Stdout: []byte("ok " + index + "\nlast " + index)}
+		if index == "2" {
+			r.Stderr = []byte("failed\n")
+			r.ExitCode = 1
+		}
+		return r
+	})
+
+	cfg := exec.Config{Client: backend.Client, Namespace: "test", GenerateName: "shard-", Image: "busybox"}
+	var stdout, stderr bytes.Buffer
+	opts := exec.ShardOptions{Shards: 3, Parallelism: 2, Stdout: &stdout, Stderr: &stderr}
+
+	results, err := exec.RunShards(context.Background(), cfg, opts, "make", "test")
+	var shardErr *exec.ShardError
+	if !errors.As(err, &shardErr) {
+		t.Fatalf("RunShards() = %v, want a *ShardError", err)
+	}
+
+	for i, r := range results {
+		wantCode := 0
+		if i == 2 {
+			wantCode = 1
+		}
+		if r.Index != i || r.ExitCode != wantCode || (r.Err != nil) != (wantCode != 0) {
+			t.Errorf("result of shard %d = %+v, want exit code %d", i, r, wantCode)
+		}
+	}
+
+	// the lines of the shards are not interleaved within lines
+	lines := strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n")
+	sort.Strings(lines)
+	want := []string{"[0] last 0", "[0] ok 0", "[1] last 1", "[1] ok 1", "[2] last 2", "[2] ok 2"}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("stdout lines = %q, want %q", lines, want)
+	}
+	if stderr.String() != "[2] failed\n" {
+		t.Errorf("stderr = %q, want %q", stderr.String(), "[2] failed\n")
+	}
+
+	pods := backend.Pods()
+	if len(pods) != 3 {
+		t.Fatalf("%d pods, want 3", len(pods))
+	}
+	indexes := map[string]bool{}
+	for _, pod := range pods {
+		indexes[pod.Labels["kube-exec/shard-index"]] = true
+	}
+	for i := 0; i < 3; i++ {
+		if !indexes[strconv.Itoa(i)] {
+			t.Errorf("no pod labeled with shard index %d", i)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package exec
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// signalNames are the names, for kill, of the signals that can be sent to the
+// command
+var signalNames = map[os.Signal]string{
+	syscall.SIGHUP:  "HUP",
+	syscall.SIGINT:  "INT",
+	syscall.SIGQUIT: "QUIT",
+	syscall.SIGKILL: "KILL",
+	syscall.SIGTERM: "TERM",
+}
+
+// Signal sends a signal to the command. SIGKILL deletes the pod immediately.
+// Other signals are sent by executing kill in the container of the command,
+// which requires the kill binary in the image, or a Helper; if it cannot be
+// executed, the pod is deleted with the grace period of the config, so the
+// container runtime sends SIGTERM, then SIGKILL once the grace period is over.
+//
+// The command is the process with PID 1 of its container: unless it handles
+// the signal, or runs through a Shell that does, the signal is ignored.
+//
+// The command must have been started by Start, and must not run as a job.
+func (cmd *Cmd) Signal(sig os.Signal) error {
+	if cmd.pod == nil {
+		return errors.New("exec: Signal before command started")
+	}
+
+	name, ok := signalNames[sig]
+	if !ok {
+		return fmt.Errorf("exec: unsupported signal %v", sig)
+	}
+
+	if sig == syscall.SIGKILL {
+		cmd.log.Info("killing command", "namespace", cmd.pod.Namespace, "pod", cmd.pod.Name)
+		atomic.StoreInt32(&cmd.killed, 1)
+		var now int64
+		return cmd.deletePod(cmd.ctx, &now)
+	}
+
+	err := cmd.execute(cmd.helperCommand("kill", "-s", name, "1"), ExecOptions{})
+	if err == nil {
+		cmd.log.Info("signal sent", "namespace", cmd.pod.Namespace, "pod", cmd.pod.Name, "signal", name)
+		return nil
+	}
+
+	cmd.log.Warn("cannot send signal, deleting pod", "namespace", cmd.pod.Namespace, "pod", cmd.pod.Name, "signal", name, "error", err)
+	atomic.StoreInt32(&cmd.killed, 1)
+	return cmd.deletePod(cmd.ctx, cmd.Cfg.CleanupGracePeriod)
+}
+
+// forwardSignals sends the interrupt and termination signals received by the
+// local process to the command until it terminates. A second signal kills it.
+func (cmd *Cmd) forwardSignals() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(c)
+
+	received := false
+	for {
+		select {
+		case sig := <-c:
+			if received {
+				sig = syscall.SIGKILL
+			}
+			received = true
+
+			go func() {
+				if err := cmd.Signal(sig); err != nil {
+					cmd.log.Error("cannot forward signal", "namespace", cmd.pod.Namespace, "pod", cmd.pod.Name, "signal", sig, "error", err)
+				}
+			}()
+		case <-cmd.exited:
+			return
+		}
+	}
+}
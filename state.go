@@ -0,0 +1,118 @@
+package exec
+
+import (
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// ProcessState holds information about a command that terminated, assembled
+// from the status of the container that ran it.
+type ProcessState struct {
+	// StartedAt and FinishedAt are the times the container started and terminated.
+	StartedAt  time.Time
+	FinishedAt time.Time
+
+	// ExitCode is the exit code of the command.
+	ExitCode int
+
+	// Reason is the brief reason the container terminated (i.e. Completed, Error,
+	// OOMKilled), and Message its termination message, if any.
+	Reason  string
+	Message string
+
+	// RestartCount is the number of times the container was restarted.
+	RestartCount int32
+
+	// Attempts is the number of pods the command ran in, with a RetryPolicy.
+	Attempts int
+
+	// Placement is where the pod of the command ran.
+	Placement *Placement
+
+	// InitContainers are the results of the init containers of the pod.
+	InitContainers []InitResult
+
+	// Environment is the environment the command ran in, with
+	// CaptureEnvironment.
+	Environment *Environment
+}
+
+// Placement describes where the pod of a command was scheduled and runs.
+type Placement struct {
+	// PodIP is the IP of the pod, and HostIP the IP of its node, which is the
+	// same with host network.
+	PodIP  string
+	HostIP string
+
+	// NodeName is the name of the node the pod was scheduled on.
+	NodeName string
+
+	// ScheduledAt is the time the pod was scheduled, if known.
+	ScheduledAt time.Time
+}
+
+// newPlacement returns the placement of the pod
+func newPlacement(pod *v1.Pod) *Placement {
+	p := &Placement{
+		PodIP:    pod.Status.PodIP,
+		HostIP:   pod.Status.HostIP,
+		NodeName: pod.Spec.NodeName,
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Type == v1.PodScheduled && c.Status == v1.ConditionTrue {
+			p.ScheduledAt = c.LastTransitionTime.Time
+		}
+	}
+
+	return p
+}
+
+// Placement returns where the pod of the command runs, once it started, or nil
+// before. With a RetryPolicy, it is the pod of the latest attempt, once it
+// started.
+func (cmd *Cmd) Placement() *Placement {
+	cmd.placementMu.Lock()
+	defer cmd.placementMu.Unlock()
+
+	return cmd.placement
+}
+
+// setPlacement records the placement of the started pod of the command
+func (cmd *Cmd) setPlacement(pod *v1.Pod) {
+	p := newPlacement(pod)
+
+	cmd.placementMu.Lock()
+	cmd.placement = p
+	cmd.placementMu.Unlock()
+}
+
+// newProcessState returns the state of the terminated container of the given status
+func newProcessState(status *v1.ContainerStatus, state *v1.ContainerStateTerminated) *ProcessState {
+	return &ProcessState{
+		StartedAt:    state.StartedAt.Time,
+		FinishedAt:   state.FinishedAt.Time,
+		ExitCode:     int(state.ExitCode),
+		Reason:       state.Reason,
+		Message:      state.Message,
+		RestartCount: status.RestartCount,
+	}
+}
+
+// Success reports whether the command exited successfully.
+func (p *ProcessState) Success() bool {
+	return p.ExitCode == 0
+}
+
+// Duration returns the time the command ran for.
+func (p *ProcessState) Duration() time.Duration {
+	return p.FinishedAt.Sub(p.StartedAt)
+}
+
+func (p *ProcessState) String() string {
+	if p.Reason != "" {
+		return fmt.Sprintf("exit status %d (%s)", p.ExitCode, p.Reason)
+	}
+	return fmt.Sprintf("exit status %d", p.ExitCode)
+}
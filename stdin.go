@@ -0,0 +1,73 @@
+package exec
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// StdinBytes sets the standard input of the command to the given bytes. Once
+// they are sent, the command reads EOF.
+func (cmd *Cmd) StdinBytes(b []byte) error {
+	if err := cmd.setStdin(bytes.NewReader(b)); err != nil {
+		return err
+	}
+	cmd.stdinData = b
+
+	return nil
+}
+
+// StdinString sets the standard input of the command to the given string. Once
+// it is sent, the command reads EOF.
+func (cmd *Cmd) StdinString(s string) error {
+	if err := cmd.setStdin(strings.NewReader(s)); err != nil {
+		return err
+	}
+	cmd.stdinData = []byte(s)
+
+	return nil
+}
+
+// StdinFile sets the standard input of the command to the content of the local
+// file at path, like a shell redirection. Once it is sent, the command reads
+// EOF. The file is closed by Wait.
+func (cmd *Cmd) StdinFile(path string) error {
+	if err := cmd.checkStdin(); err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	cmd.Stdin = f
+	cmd.stdinPath = path
+	cmd.closeAfterWait = append(cmd.closeAfterWait, f)
+
+	return nil
+}
+
+// setStdin sets the standard input of the command if it is not set yet
+func (cmd *Cmd) setStdin(r io.Reader) error {
+	if err := cmd.checkStdin(); err != nil {
+		return err
+	}
+	cmd.Stdin = r
+
+	return nil
+}
+
+// checkStdin returns an error if the standard input of the command cannot be
+// set anymore
+func (cmd *Cmd) checkStdin() error {
+	if cmd.Stdin != nil {
+		return errors.New("exec: Stdin already set")
+	}
+	if cmd.done != nil {
+		return errors.New("exec: Stdin set after command started")
+	}
+
+	return nil
+}
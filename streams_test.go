@@ -0,0 +1,102 @@
+package exec_test
+
+import (
+	"bytes"
+	"testing"
+
+	exec "github.com/engineerd/kube-exec"
+	"github.com/engineerd/kube-exec/kubeexectest"
+)
+
+func TestStreams(t *testing.T) {
+	result := kubeexectest.Result{Stdout: []byte("out\n"), Stderr: []byte("err\n")}
+
+	tests := []struct {
+		name        string
+		tty         bool
+		mergeStderr bool
+		shell       []string
+		sameWriter  bool
+
+		wantStdout string
+		wantStderr string
+
+		// wantArgs, if not empty, is the last argument of the container
+		wantArgs string
+	}{
+		{
+			name:       "separate writers",
+			wantStdout: "out\n",
+			wantStderr: "err\n",
+		},
+		{
+			name:       "same writer",
+			sameWriter: true,
+			wantStdout: "out\nerr\n",
+		},
+		{
+			name:        "merged",
+			mergeStderr: true,
+			wantStdout:  "out\nerr\n",
+		},
+		{
+			name:        "merged with a shell",
+			mergeStderr: true,
+			shell:       []string{"/bin/sh", "-c"},
+			wantStdout:  "out\nerr\n",
+			wantArgs:    "make test 2>&1",
+		},
+		{
+			name:       "merged by the TTY",
+			tty:        true,
+			wantStdout: "out\nerr\n",
+		},
+		{
+			name:        "merged by the TTY, not the shell",
+			tty:         true,
+			mergeStderr: true,
+			shell:       []string{"/bin/sh", "-c"},
+			wantStdout:  "out\nerr\n",
+			wantArgs:    "make test",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := kubeexectest.New()
+			backend.Handle(func(kubeexectest.Run) kubeexectest.Result { return result })
+
+			cfg := exec.Config{Client: backend.Client, Namespace: "test", Image: "busybox"}
+			cmd := exec.Command(cfg, "make", "test")
+			cmd.TTY = tt.tty
+			cmd.MergeStderr = tt.mergeStderr
+			cmd.Shell = tt.shell
+
+			var stdout, stderr bytes.Buffer
+			cmd.Stdout = &stdout
+			cmd.Stderr = &stderr
+			if tt.sameWriter {
+				cmd.Stderr = &stdout
+			}
+
+			if err := cmd.Run(); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+
+			if got := stdout.String(); got != tt.wantStdout {
+				t.Errorf("stdout = %q, want %q", got, tt.wantStdout)
+			}
+			if got := stderr.String(); got != tt.wantStderr {
+				t.Errorf("stderr = %q, want %q", got, tt.wantStderr)
+			}
+
+			c := backend.Pods()[0].Spec.Containers[0]
+			if c.TTY != tt.tty {
+				t.Errorf("container TTY = %v, want %v", c.TTY, tt.tty)
+			}
+			if tt.wantArgs != "" && (len(c.Args) == 0 || c.Args[len(c.Args)-1] != tt.wantArgs) {
+				t.Errorf("container args = %q, want last %q", c.Args, tt.wantArgs)
+			}
+		})
+	}
+}
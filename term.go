@@ -0,0 +1,172 @@
+package exec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/ssh/terminal"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// term is a local terminal put in raw mode for an interactive session
+type term struct {
+	in    *os.File
+	out   *os.File
+	state *terminal.State
+}
+
+// setupTerminal puts the local terminal connected to stdin in raw mode.
+// It returns nil if stdin is not a terminal.
+func setupTerminal(stdin io.Reader, stdout io.Writer) (*term, error) {
+	in, ok := stdin.(*os.File)
+	if !ok || !terminal.IsTerminal(int(in.Fd())) {
+		return nil, nil
+	}
+
+	state, err := terminal.MakeRaw(int(in.Fd()))
+	if err != nil {
+		return nil, err
+	}
+
+	t := &term{in: in, state: state}
+
+	// the size is read from stdout if it is a terminal, and from stdin otherwise
+	if out, ok := stdout.(*os.File); ok && terminal.IsTerminal(int(out.Fd())) {
+		t.out = out
+	}
+
+	return t, nil
+}
+
+// restore restores the terminal to its state before setupTerminal
+func (t *term) restore() error {
+	return terminal.Restore(int(t.in.Fd()), t.state)
+}
+
+// size returns the current size of the terminal, or nil if it cannot be read
+func (t *term) size() *remotecommand.TerminalSize {
+	f := t.in
+	if t.out != nil {
+		f = t.out
+	}
+
+	width, height, err := terminal.GetSize(int(f.Fd()))
+	if err != nil {
+		return nil
+	}
+
+	return &remotecommand.TerminalSize{Width: uint16(width), Height: uint16(height)}
+}
+
+// sizeQueue returns a remotecommand.TerminalSizeQueue that sends the initial size
+// of the terminal, then a new size every time the terminal is resized, until stop
+// is closed
+func (t *term) sizeQueue(stop <-chan struct{}) remotecommand.TerminalSizeQueue {
+	q := &sizeQueue{c: make(chan remotecommand.TerminalSize, 1)}
+
+	resized := make(chan struct{}, 1)
+	watchResize(stop, resized)
+
+	go func() {
+		defer close(q.c)
+
+		var last remotecommand.TerminalSize
+		for {
+			if size := t.size(); size != nil && *size != last {
+				last = *size
+				select {
+				case q.c <- *size:
+				case <-stop:
+					return
+				}
+			}
+
+			select {
+			case <-resized:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return q
+}
+
+// sizeQueue implements remotecommand.TerminalSizeQueue
+type sizeQueue struct {
+	c chan remotecommand.TerminalSize
+}
+
+// Next returns the new terminal size after the terminal is resized,
+// or nil when there are no more sizes to send
+func (q *sizeQueue) Next() *remotecommand.TerminalSize {
+	size, ok := <-q.c
+	if !ok {
+		return nil
+	}
+
+	return &size
+}
+
+// noEchoReader reads the lines typed in a local terminal without echoing them
+type noEchoReader struct {
+	fd   int
+	line []byte
+}
+
+func (r *noEchoReader) Read(p []byte) (int, error) {
+	if len(r.line) == 0 {
+		line, err := terminal.ReadPassword(r.fd)
+		if err != nil {
+			return 0, err
+		}
+		r.line = append(line, '\n')
+	}
+
+	n := copy(p, r.line)
+	r.line = r.line[n:]
+	return n, nil
+}
+
+// noEcho returns a reader of stdin without echo if it is a local terminal, or
+// stdin itself otherwise
+func noEcho(stdin io.Reader) io.Reader {
+	in, ok := stdin.(*os.File)
+	if !ok || !terminal.IsTerminal(int(in.Fd())) {
+		return stdin
+	}
+
+	return &noEchoReader{fd: int(in.Fd())}
+}
+
+// PromptSecret writes the prompt to the standard error, reads a secret, such
+// as a password, from the local terminal of the standard input without echo,
+// and writes it followed by a newline to w, such as the pipe returned by
+// Cmd.StdinPipe, for commands like vault login or mysql -p. The secret is
+// not kept once written.
+func PromptSecret(w io.Writer, prompt string) error {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return errors.New("exec: standard input is not a terminal")
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	secret, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return fmt.Errorf("cannot read secret: %w", err)
+	}
+
+	line := append(secret, '\n')
+	_, err = w.Write(line)
+	for i := range line {
+		line[i] = 0
+	}
+	for i := range secret {
+		secret[i] = 0
+	}
+
+	return err
+}
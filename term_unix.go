@@ -0,0 +1,32 @@
+//go:build !windows
+// +build !windows
+
+package exec
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchResize notifies resized every time the terminal receives SIGWINCH,
+// until stop is closed
+func watchResize(stop <-chan struct{}, resized chan<- struct{}) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGWINCH)
+
+	go func() {
+		defer signal.Stop(sigs)
+		for {
+			select {
+			case <-sigs:
+				select {
+				case resized <- struct{}{}:
+				default:
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
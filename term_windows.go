@@ -0,0 +1,28 @@
+//go:build windows
+// +build windows
+
+package exec
+
+import (
+	"time"
+)
+
+// watchResize notifies resized periodically, as there is no resize signal on
+// Windows, until stop is closed
+func watchResize(stop <-chan struct{}, resized chan<- struct{}) {
+	go func() {
+		ticker := time.NewTicker(250 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				select {
+				case resized <- struct{}{}:
+				default:
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,215 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// minIdleCheckPeriod is the shortest period of the checks of the stream idle
+// timeout, a quarter of the timeout otherwise
+const minIdleCheckPeriod = time.Millisecond
+
+// TimeoutStage is the stage of a command whose timeout was exceeded
+type TimeoutStage string
+
+const (
+	// TimeoutSchedule is the stage of the pod waiting to be scheduled on a
+	// node, bounded by TimeToSchedule.
+	TimeoutSchedule TimeoutStage = "schedule"
+
+	// TimeoutStart is the stage of the scheduled pod starting, pulling its
+	// images and running its init containers, bounded by TimeToStart.
+	TimeoutStart TimeoutStage = "start"
+
+	// TimeoutExecution is the stage of the command running, bounded by
+	// ExecutionTimeout.
+	TimeoutExecution TimeoutStage = "execution"
+
+	// TimeoutStreamIdle is the stage of the command running without output,
+	// bounded by StreamIdleTimeout.
+	TimeoutStreamIdle TimeoutStage = "stream idle"
+)
+
+// TimeoutError reports a command that exceeded one of the timeouts of its
+// config, so the slowness can be attributed to scheduling, to starting the
+// pod, or to the command itself. It matches ErrTimeout with errors.Is.
+type TimeoutError struct {
+	Stage   TimeoutStage
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s timeout of %v exceeded", e.Stage, e.Timeout)
+}
+
+// Is reports whether the target is ErrTimeout.
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+// terminationError returns the error of a command stopped before it exited on
+// its own, as an error matching the kind of its termination, and err otherwise
+func (cmd *Cmd) terminationError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	// the stream attached to a killed command breaks as its pod is deleted
+	var e *Error
+	if errors.As(err, &e) && e.Kind == ErrStreamLost && atomic.LoadInt32(&cmd.killed) != 0 {
+		err = e.Err
+	}
+	if Termination(err) != nil {
+		return err
+	}
+
+	// a command that exited on its own is not reported as stopped by its
+	// context done afterwards
+	var exitErr *ExitError
+	stopped := !errors.As(err, &exitErr)
+
+	switch {
+	case atomic.LoadInt32(&cmd.killed) != 0:
+		return &Error{Kind: ErrKilled, Err: err}
+	case errors.Is(err, context.DeadlineExceeded) || stopped && cmd.ctx.Err() == context.DeadlineExceeded:
+		return &Error{Kind: ErrTimeout, Err: err}
+	case errors.Is(err, context.Canceled) || stopped && cmd.ctx.Err() == context.Canceled:
+		return &Error{Kind: ErrCanceled, Err: err}
+	}
+
+	return err
+}
+
+// waitStage waits for the pod to satisfy cond, within the timeout of the given
+// stage of its start if not zero
+func (cmd *Cmd) waitStage(ctx context.Context, stage TimeoutStage, timeout time.Duration, cond func(*v1.Pod) bool) (*v1.Pod, error) {
+	stageCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		stageCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	pod, err := cmd.exec.WaitReady(stageCtx, cmd.pod, cmd.observe(cond), cmd.startFailure)
+	if err == context.DeadlineExceeded && ctx.Err() == nil {
+		err = &TimeoutError{Stage: stage, Timeout: timeout}
+	}
+
+	return pod, err
+}
+
+// expire stops the command, by canceling its context, because it exceeded
+// the timeout of the given stage, unless it already terminated
+func (cmd *Cmd) expire(stage TimeoutStage, timeout time.Duration) {
+	cmd.timeoutMu.Lock()
+	defer cmd.timeoutMu.Unlock()
+
+	if cmd.timeoutErr != nil || cmd.disarmed {
+		return
+	}
+
+	cmd.log.Warn("command timed out", "namespace", cmd.pod.Namespace, "pod", cmd.pod.Name, "stage", stage, "timeout", timeout)
+	cmd.timeoutErr = &TimeoutError{Stage: stage, Timeout: timeout}
+	cmd.cancel()
+}
+
+// disarm prevents the timeouts of the command from stopping it once it
+// terminated, and returns the timeout it exceeded, if any
+func (cmd *Cmd) disarm() error {
+	cmd.timeoutMu.Lock()
+	defer cmd.timeoutMu.Unlock()
+
+	cmd.disarmed = true
+	if cmd.timeoutErr == nil {
+		return nil
+	}
+
+	return cmd.timeoutErr
+}
+
+// executionTimer stops the command once it ran longer than the execution
+// timeout of the config, if any, and returns a function stopping the timer
+func (cmd *Cmd) executionTimer() func() {
+	timeout := cmd.Cfg.ExecutionTimeout
+	if timeout <= 0 {
+		return func() {}
+	}
+
+	timer := time.AfterFunc(timeout, func() { cmd.expire(TimeoutExecution, timeout) })
+	return func() { timer.Stop() }
+}
+
+// activityWriter records the time of the last write to w
+type activityWriter struct {
+	w    io.Writer
+	last *int64
+}
+
+func (a *activityWriter) Write(p []byte) (int, error) {
+	atomic.StoreInt64(a.last, time.Now().UnixNano())
+	return a.w.Write(p)
+}
+
+// flushLogs copies the output of the command stopped, or whose stream was
+// lost, with err, not received yet from the logs of its pod, within the flush
+// timeout of the config, then closes flushed
+func (cmd *Cmd) flushLogs(err error) {
+	if cmd.flushed == nil {
+		return
+	}
+	defer close(cmd.flushed)
+
+	if cmd.client == nil || cmd.pod == nil || cmd.job != nil || cmd.Logs != LogsAttach || cmd.Compression != nil {
+		return
+	}
+	if cmd.ctx.Err() == nil && !errors.Is(err, ErrStreamLost) {
+		return
+	}
+	last := atomic.LoadInt64(&cmd.lastOutput)
+	if last == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cmd.Cfg.FlushTimeout)
+	defer cancel()
+
+	since := metav1.NewTime(time.Unix(0, last))
+	opts := &v1.PodLogOptions{Container: cmd.Container, SinceTime: &since}
+	if ferr := cmd.client.streamLogs(ctx, cmd.pod, opts, cmd.Stdout); ferr != nil {
+		cmd.log.Warn("cannot flush logs", "namespace", cmd.pod.Namespace, "pod", cmd.pod.Name, "error", ferr)
+		return
+	}
+	cmd.log.Debug("logs flushed", "namespace", cmd.pod.Namespace, "pod", cmd.pod.Name)
+}
+
+// watchIdle stops the command once no write was recorded in last for the
+// stream idle timeout of the config, until stop is closed
+func (cmd *Cmd) watchIdle(last *int64, stop <-chan struct{}) {
+	timeout := cmd.Cfg.StreamIdleTimeout
+	period := timeout / 4
+	if period < minIdleCheckPeriod {
+		period = minIdleCheckPeriod
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			idle := time.Since(time.Unix(0, atomic.LoadInt64(last)))
+			if idle >= timeout {
+				cmd.expire(TimeoutStreamIdle, timeout)
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package exec
+
+import (
+	"context"
+)
+
+// Tracer creates the spans of command runs. It is implemented by the
+// application on top of its tracing library, such as OpenTelemetry, whose
+// Tracer.Start and TextMapPropagator.Inject with a MapCarrier map to Start and
+// Inject: the package does not depend on one.
+type Tracer interface {
+	// Start starts a span with the given name and attributes, as a child of the
+	// span of the context, if any, and returns a context holding the new span.
+	Start(ctx context.Context, name string, attrs map[string]string) (context.Context, Span)
+
+	// Inject adds the trace context of the span of the context to the carrier,
+	// which becomes the annotations of the pod.
+	Inject(ctx context.Context, carrier map[string]string)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	// End ends the span, marking it as failed if err is not nil.
+	End(err error)
+}
+
+// startSpan starts a span as a child of the span of the command, and returns
+// the function ending it, which does nothing if the config has no tracer
+func (cmd *Cmd) startSpan(name string) func(error) {
+	if cmd.Cfg.Tracer == nil {
+		return func(error) {}
+	}
+
+	attrs := map[string]string{
+		"k8s.namespace.name": cmd.Cfg.Namespace,
+	}
+	if name := cmd.PodName(); name != "" {
+		attrs["k8s.pod.name"] = name
+	}
+
+	_, span := cmd.Cfg.Tracer.Start(cmd.traceCtx, name, attrs)
+	return span.End
+}
+
+// injectTrace adds the trace context of the command to the annotations
+func (cmd *Cmd) injectTrace(annotations map[string]string) map[string]string {
+	if cmd.Cfg.Tracer == nil {
+		return annotations
+	}
+
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	cmd.Cfg.Tracer.Inject(cmd.traceCtx, annotations)
+
+	return annotations
+}
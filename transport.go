@@ -0,0 +1,106 @@
+package exec
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+
+	httpspdy "k8s.io/apimachinery/pkg/util/httpstream/spdy"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/transport/spdy"
+)
+
+const (
+	// maxIdleConnsPerHost is the number of idle connections to the API server
+	// kept by a client, for the requests of the commands running at the same
+	// time over HTTP/1.1
+	maxIdleConnsPerHost = 25
+
+	// dialTimeout and dialKeepAlive are the timeout of the connections to the
+	// API server, and the period of their TCP keepalives, as in client-go
+	dialTimeout   = 30 * time.Second
+	dialKeepAlive = 30 * time.Second
+)
+
+// newTransport returns the transport of the requests of a client, pooling its
+// connections to the API server, with HTTP/2 over TLS unless the DISABLE_HTTP2
+// env variable is set, as in client-go
+func newTransport(config *restclient.Config, tlsConfig *tls.Config) *http.Transport {
+	t := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: 10 * time.Second,
+		MaxIdleConnsPerHost: maxIdleConnsPerHost,
+		DialContext:         (&net.Dialer{Timeout: dialTimeout, KeepAlive: dialKeepAlive}).DialContext,
+	}
+	if config.Dial != nil {
+		t.DialContext = config.Dial
+	}
+
+	return utilnet.SetTransportDefaults(t)
+}
+
+// clientsetConfig returns the config of the clientset of a client, making its
+// requests with the given transport. The auth of the config is kept, so it is
+// added to the requests by the clientset.
+func clientsetConfig(config *restclient.Config, transport http.RoundTripper) *restclient.Config {
+	config = restclient.CopyConfig(config)
+	config.Transport = transport
+	config.TLSClientConfig = restclient.TLSClientConfig{}
+
+	return config
+}
+
+// Close closes the idle connections of the client to the API server, and
+// removes it from the clients cached for the commands that do not set one.
+// The streams and watches in progress are not interrupted, and the client can
+// still be used, opening new connections.
+func (c *Client) Close() error {
+	clients.Lock()
+	for key, cached := range clients.m {
+		if cached == c {
+			delete(clients.m, key)
+		}
+	}
+	clients.Unlock()
+
+	if c.transport != nil {
+		c.transport.CloseIdleConnections()
+	}
+
+	return nil
+}
+
+// roundTripperFor returns the round trippers to upgrade a connection to SPDY,
+// like spdy.RoundTripperFor, with the TLS config of the client and with TCP
+// keepalives sent with the given period if not zero. An upgraded connection is
+// hijacked from the pool and held by the upgrader, so a new upgrader is needed
+// for every stream.
+func (c *Client) roundTripperFor(keepalive time.Duration) (http.RoundTripper, spdy.Upgrader, error) {
+	if c.tlsConfig == nil && keepalive == 0 {
+		return spdy.RoundTripperFor(c.config)
+	}
+
+	tlsConfig := c.tlsConfig
+	if tlsConfig == nil {
+		var err error
+		if tlsConfig, err = restclient.TLSConfigFor(c.config); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	upgrader := httpspdy.NewSpdyRoundTripper(tlsConfig, true, false)
+	if keepalive == 0 {
+		keepalive = dialKeepAlive
+	}
+	upgrader.Dialer = &net.Dialer{Timeout: dialTimeout, KeepAlive: keepalive}
+
+	wrapper, err := restclient.HTTPWrappersForConfig(c.config, upgrader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return wrapper, upgrader, nil
+}
@@ -0,0 +1,395 @@
+package exec
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// Validate checks the config for problems that would make creating the pod
+// fail, without reaching the cluster. It returns an *Error of kind
+// ErrInvalidConfig listing all the problems found, or nil.
+//
+// Start validates the config before making any API call.
+func (cfg *Config) Validate() error {
+	var errs field.ErrorList
+
+	if cfg.Image == "" && templateImage(cfg) == "" {
+		errs = append(errs, field.Required(field.NewPath("Image"), "the image of the command must be set"))
+	}
+	if cfg.Name != "" {
+		errs = append(errs, dns1123(field.NewPath("Name"), cfg.Name, validation.IsDNS1123Subdomain)...)
+	}
+	if cfg.GenerateName != "" {
+		// a random suffix of 5 characters is added to the prefix
+		for _, msg := range validation.IsDNS1123Subdomain(cfg.GenerateName + "abcde") {
+			errs = append(errs, field.Invalid(field.NewPath("GenerateName"), cfg.GenerateName, msg))
+		}
+	}
+	if cfg.Namespace != "" {
+		errs = append(errs, dns1123(field.NewPath("Namespace"), cfg.Namespace, validation.IsDNS1123Label)...)
+	}
+
+	for i, s := range cfg.Secrets {
+		p := field.NewPath("Secrets").Index(i)
+		errs = append(errs, envVarName(p.Child("EnvVarName"), s.EnvVarName)...)
+		errs = append(errs, dns1123(p.Child("SecretName"), s.SecretName, validation.IsDNS1123Subdomain)...)
+		errs = append(errs, key(p.Child("SecretKey"), s.SecretKey)...)
+	}
+	for i, s := range cfg.EphemeralSecrets {
+		p := field.NewPath("EphemeralSecrets").Index(i)
+		if s.Name == "" {
+			errs = append(errs, field.Required(p.Child("Name"), ""))
+		} else {
+			// a random suffix is added to the prefix
+			for _, msg := range validation.IsDNS1123Subdomain(s.Name + "-abcde") {
+				errs = append(errs, field.Invalid(p.Child("Name"), s.Name, msg))
+			}
+		}
+		for k := range s.Data {
+			errs = append(errs, key(p.Child("Data").Key(k), k)...)
+		}
+	}
+	for i, cm := range cfg.ConfigMaps {
+		p := field.NewPath("ConfigMaps").Index(i)
+		errs = append(errs, envVarName(p.Child("EnvVarName"), cm.EnvVarName)...)
+		errs = append(errs, dns1123(p.Child("ConfigMapName"), cm.ConfigMapName, validation.IsDNS1123Subdomain)...)
+		errs = append(errs, key(p.Child("ConfigMapKey"), cm.ConfigMapKey)...)
+	}
+
+	errs = append(errs, cfg.Requests.validate(field.NewPath("Requests"))...)
+	errs = append(errs, cfg.Limits.validate(field.NewPath("Limits"))...)
+	errs = append(errs, validateExtended(cfg.Requests, cfg.Limits)...)
+
+	if cfg.MainContainer != "" && (cfg.PodTemplate == nil || containerIndex(cfg.PodTemplate.Containers, cfg.MainContainer) < 0) {
+		errs = append(errs, field.NotFound(field.NewPath("MainContainer"), cfg.MainContainer))
+	}
+	if cfg.Host != nil {
+		if cfg.Restricted {
+			errs = append(errs, field.Forbidden(field.NewPath("Host"), "host access cannot be used with Restricted"))
+		}
+		for i, hp := range cfg.Host.Paths {
+			p := field.NewPath("Host", "Paths").Index(i)
+			if hp.Path == "" {
+				errs = append(errs, field.Required(p.Child("Path"), ""))
+			}
+			if hp.MountPath == "" {
+				errs = append(errs, field.Required(p.Child("MountPath"), ""))
+			}
+		}
+	}
+	errs = append(errs, cfg.validateDNS()...)
+	if cfg.RunAsJob && len(cfg.Sidecars) > 0 {
+		errs = append(errs, field.Forbidden(field.NewPath("Sidecars"), "sidecars cannot be used with RunAsJob"))
+	}
+	if cfg.Cache != nil && cfg.ImageDigest == "" && cfg.ResolveDigest == nil {
+		errs = append(errs, field.Required(field.NewPath("ImageDigest"), "the results of commands are only cached for images pinned to a digest"))
+	}
+	if cfg.RunAsJob && len(cfg.Artifacts) > 0 {
+		errs = append(errs, field.Forbidden(field.NewPath("Artifacts"), "artifacts cannot be used with RunAsJob"))
+	}
+	if cfg.RunAsJob && cfg.ServerSideApply {
+		errs = append(errs, field.Forbidden(field.NewPath("ServerSideApply"), "jobs cannot be created with server-side apply"))
+	}
+	if cfg.RunAsJob && cfg.CaptureEnvironment {
+		errs = append(errs, field.Forbidden(field.NewPath("CaptureEnvironment"), "the environment cannot be captured with RunAsJob"))
+	}
+	if h := cfg.HealthCheck; h != nil {
+		p := field.NewPath("HealthCheck")
+		if cfg.RunAsJob {
+			errs = append(errs, field.Forbidden(p, "health checks cannot be used with RunAsJob"))
+		}
+		for _, msg := range validation.IsValidPortNum(h.Port) {
+			errs = append(errs, field.Invalid(p.Child("Port"), h.Port, msg))
+		}
+		if h.Path != "" && !strings.HasPrefix(h.Path, "/") {
+			errs = append(errs, field.Invalid(p.Child("Path"), h.Path, "must start with /"))
+		}
+	}
+	for i, a := range cfg.Artifacts {
+		if a == "" {
+			errs = append(errs, field.Required(field.NewPath("Artifacts").Index(i), ""))
+		}
+	}
+	if w := cfg.Workspace; w != nil {
+		p := field.NewPath("Workspace")
+		if cfg.RunAsJob && (len(w.Inputs) > 0 || len(w.Outputs) > 0) {
+			errs = append(errs, field.Forbidden(p, "workspace inputs and outputs cannot be used with RunAsJob"))
+		}
+		if w.SizeLimit != "" {
+			if _, err := resource.ParseQuantity(w.SizeLimit); err != nil {
+				errs = append(errs, field.Invalid(p.Child("SizeLimit"), w.SizeLimit, err.Error()))
+			}
+		}
+		for i, in := range w.Inputs {
+			errs = append(errs, relativePath(p.Child("Inputs").Index(i).Child("Path"), in.Path)...)
+		}
+		for i, out := range w.Outputs {
+			errs = append(errs, relativePath(p.Child("Outputs").Index(i), out)...)
+		}
+	}
+
+	switch cfg.RestartPolicy {
+	case "", v1.RestartPolicyNever, v1.RestartPolicyOnFailure:
+	default:
+		errs = append(errs, field.NotSupported(field.NewPath("RestartPolicy"), cfg.RestartPolicy, []string{string(v1.RestartPolicyNever), string(v1.RestartPolicyOnFailure)}))
+	}
+	if cfg.MaxRestarts < 0 {
+		errs = append(errs, field.Invalid(field.NewPath("MaxRestarts"), cfg.MaxRestarts, "must be greater than or equal to 0"))
+	}
+
+	if cfg.Retry != nil && cfg.Retry.IdempotencyKey != "" {
+		for _, msg := range validation.IsValidLabelValue(cfg.Retry.IdempotencyKey) {
+			errs = append(errs, field.Invalid(field.NewPath("Retry", "IdempotencyKey"), cfg.Retry.IdempotencyKey, msg))
+		}
+	}
+
+	if _, ok := cfg.Executor.(*Client); cfg.Executor != nil && !ok {
+		p := field.NewPath("Executor")
+		for _, f := range []struct {
+			name string
+			set  bool
+		}{
+			{"RunAsJob", cfg.RunAsJob},
+			{"DryRun", cfg.DryRun},
+			{"Precheck", cfg.Precheck},
+			{"Preflight", cfg.Preflight},
+			{"NoPreemption", cfg.NoPreemption},
+			{"FieldManager", cfg.FieldManager != ""},
+			{"ServerSideApply", cfg.ServerSideApply},
+			{"CreateNamespace", cfg.CreateNamespace},
+			{"EphemeralSecrets", len(cfg.EphemeralSecrets) > 0},
+			{"HealthCheck", cfg.HealthCheck != nil},
+			{"RestartPolicy", cfg.RestartPolicy == v1.RestartPolicyOnFailure},
+			{"Workspace.Outputs", cfg.Workspace != nil && len(cfg.Workspace.Outputs) > 0},
+		} {
+			if f.set {
+				errs = append(errs, field.Forbidden(p, f.name+" needs a Kubernetes client"))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return &Error{Kind: ErrInvalidConfig, Err: errs.ToAggregate()}
+	}
+
+	return nil
+}
+
+// templateImage returns the image of the container of the pod template that
+// runs the command, if any
+func templateImage(cfg *Config) string {
+	if cfg.PodTemplate == nil || len(cfg.PodTemplate.Containers) == 0 {
+		return ""
+	}
+
+	i := 0
+	if cfg.MainContainer != "" {
+		i = containerIndex(cfg.PodTemplate.Containers, cfg.MainContainer)
+	}
+	if i < 0 {
+		return ""
+	}
+
+	return cfg.PodTemplate.Containers[i].Image
+}
+
+// validate checks that the amounts are valid quantities, of extended resources
+// that can be requested
+func (r Resources) validate(p *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	for name, amount := range map[string]string{
+		"CPU":              r.CPU,
+		"Memory":           r.Memory,
+		"EphemeralStorage": r.EphemeralStorage,
+	} {
+		if amount == "" {
+			continue
+		}
+		if _, err := resource.ParseQuantity(amount); err != nil {
+			errs = append(errs, field.Invalid(p.Child(name), amount, err.Error()))
+		}
+	}
+
+	for name, amount := range r.Extended {
+		kp := p.Child("Extended").Key(name)
+		hugepages := strings.HasPrefix(name, v1.ResourceHugePagesPrefix)
+		if !hugepages && (!strings.Contains(name, "/") || strings.Contains(name, "kubernetes.io/")) {
+			errs = append(errs, field.Invalid(kp, name, "must be hugepages or a domain-prefixed extended resource outside of kubernetes.io"))
+			continue
+		}
+		for _, msg := range validation.IsQualifiedName(name) {
+			errs = append(errs, field.Invalid(kp, name, msg))
+		}
+		q, err := resource.ParseQuantity(amount)
+		if err != nil {
+			errs = append(errs, field.Invalid(kp, amount, err.Error()))
+			continue
+		}
+		if !hugepages && q.MilliValue()%1000 != 0 {
+			errs = append(errs, field.Invalid(kp, amount, "must be a whole number"))
+		}
+	}
+
+	return errs
+}
+
+// validateExtended checks that the extended resources and hugepages both
+// requested and limited have the same amounts, and that hugepages come with
+// CPU or memory
+func validateExtended(requests, limits Resources) field.ErrorList {
+	var errs field.ErrorList
+	hugepages := false
+	for _, r := range []Resources{requests, limits} {
+		for name := range r.Extended {
+			hugepages = hugepages || strings.HasPrefix(name, v1.ResourceHugePagesPrefix)
+		}
+	}
+	if hugepages && requests.CPU == "" && requests.Memory == "" && limits.CPU == "" && limits.Memory == "" {
+		errs = append(errs, field.Required(field.NewPath("Limits", "Memory"), "hugepages need a CPU or memory amount"))
+	}
+
+	for name, amount := range requests.Extended {
+		limit, ok := limits.Extended[name]
+		if !ok {
+			continue
+		}
+		rq, rerr := resource.ParseQuantity(amount)
+		lq, lerr := resource.ParseQuantity(limit)
+		if rerr == nil && lerr == nil && rq.Cmp(lq) != 0 {
+			errs = append(errs, field.Invalid(field.NewPath("Requests", "Extended").Key(name), amount, "must equal the limit, as it cannot be overcommitted"))
+		}
+	}
+
+	return errs
+}
+
+// dns1123 checks a name with one of the DNS-1123 validation functions
+func dns1123(p *field.Path, name string, validate func(string) []string) field.ErrorList {
+	if name == "" {
+		return field.ErrorList{field.Required(p, "")}
+	}
+
+	var errs field.ErrorList
+	for _, msg := range validate(name) {
+		errs = append(errs, field.Invalid(p, name, msg))
+	}
+
+	return errs
+}
+
+// envVarName checks the name of an env variable
+func envVarName(p *field.Path, name string) field.ErrorList {
+	if name == "" {
+		return field.ErrorList{field.Required(p, "")}
+	}
+
+	var errs field.ErrorList
+	for _, msg := range validation.IsEnvVarName(name) {
+		errs = append(errs, field.Invalid(p, name, msg))
+	}
+
+	return errs
+}
+
+// relativePath checks a path relative to a directory, which must stay in it
+func relativePath(p *field.Path, rel string) field.ErrorList {
+	if rel == "" {
+		return field.ErrorList{field.Required(p, "")}
+	}
+
+	clean := path.Clean(rel)
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return field.ErrorList{field.Invalid(p, rel, "must be a relative path within the workspace")}
+	}
+
+	return nil
+}
+
+// key checks a key of a secret or config map
+func key(p *field.Path, k string) field.ErrorList {
+	if k == "" {
+		return field.ErrorList{field.Required(p, "")}
+	}
+
+	var errs field.ErrorList
+	for _, msg := range validation.IsConfigMapKey(k) {
+		errs = append(errs, field.Invalid(p, k, msg))
+	}
+
+	return errs
+}
+
+// maxDNSNameservers and maxDNSSearches are the limits of the resolv.conf of
+// pods enforced by the API server
+const (
+	maxDNSNameservers = 3
+	maxDNSSearches    = 6
+)
+
+// validateDNS checks the DNS policy, the DNS config and the host aliases
+func (cfg *Config) validateDNS() field.ErrorList {
+	var errs field.ErrorList
+
+	switch cfg.DNSPolicy {
+	case "", v1.DNSClusterFirst, v1.DNSClusterFirstWithHostNet, v1.DNSDefault:
+	case v1.DNSNone:
+		if cfg.DNSConfig == nil || len(cfg.DNSConfig.Nameservers) == 0 {
+			errs = append(errs, field.Required(field.NewPath("DNSConfig", "Nameservers"), "a name server must be set with the None DNS policy"))
+		}
+	default:
+		errs = append(errs, field.NotSupported(field.NewPath("DNSPolicy"), cfg.DNSPolicy, []string{
+			string(v1.DNSClusterFirst), string(v1.DNSClusterFirstWithHostNet), string(v1.DNSDefault), string(v1.DNSNone),
+		}))
+	}
+
+	if dc := cfg.DNSConfig; dc != nil {
+		p := field.NewPath("DNSConfig")
+		if len(dc.Nameservers) > maxDNSNameservers {
+			errs = append(errs, field.Invalid(p.Child("Nameservers"), len(dc.Nameservers), fmt.Sprintf("must have at most %d items", maxDNSNameservers)))
+		}
+		for i, ns := range dc.Nameservers {
+			for _, msg := range validation.IsValidIP(ns) {
+				errs = append(errs, field.Invalid(p.Child("Nameservers").Index(i), ns, msg))
+			}
+		}
+		if len(dc.Searches) > maxDNSSearches {
+			errs = append(errs, field.Invalid(p.Child("Searches"), len(dc.Searches), fmt.Sprintf("must have at most %d items", maxDNSSearches)))
+		}
+		for i, s := range dc.Searches {
+			for _, msg := range validation.IsDNS1123Subdomain(strings.TrimSuffix(s, ".")) {
+				errs = append(errs, field.Invalid(p.Child("Searches").Index(i), s, msg))
+			}
+		}
+		for i, o := range dc.Options {
+			if o.Name == "" {
+				errs = append(errs, field.Required(p.Child("Options").Index(i).Child("Name"), ""))
+			}
+		}
+	}
+
+	if cfg.Host != nil && cfg.Host.Network && len(cfg.HostAliases) > 0 {
+		errs = append(errs, field.Forbidden(field.NewPath("HostAliases"), "host aliases cannot be used with the host network"))
+	}
+	for i, ha := range cfg.HostAliases {
+		p := field.NewPath("HostAliases").Index(i)
+		for _, msg := range validation.IsValidIP(ha.IP) {
+			errs = append(errs, field.Invalid(p.Child("IP"), ha.IP, msg))
+		}
+		if len(ha.Hostnames) == 0 {
+			errs = append(errs, field.Required(p.Child("Hostnames"), ""))
+		}
+		for j, h := range ha.Hostnames {
+			for _, msg := range validation.IsDNS1123Subdomain(h) {
+				errs = append(errs, field.Invalid(p.Child("Hostnames").Index(j), h, msg))
+			}
+		}
+	}
+
+	return errs
+}
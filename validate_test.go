@@ -0,0 +1,98 @@
+package exec_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	exec "github.com/engineerd/kube-exec"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  exec.Config
+
+		// fields are the paths of the fields reported, none if the config is
+		// valid
+		fields []string
+	}{
+		{
+			name: "valid",
+			cfg:  exec.Config{Image: "busybox", GenerateName: "run-", Namespace: "test"},
+		},
+		{
+			name:   "no image",
+			cfg:    exec.Config{Name: "run"},
+			fields: []string{"Image"},
+		},
+		{
+			name: "image of the pod template",
+			cfg: exec.Config{
+				Name:        "run",
+				PodTemplate: &v1.PodSpec{Containers: []v1.Container{{Name: "main", Image: "busybox"}}},
+			},
+		},
+		{
+			name:   "invalid names",
+			cfg:    exec.Config{Image: "busybox", Name: "Run", Namespace: "a.b"},
+			fields: []string{"Name", "Namespace"},
+		},
+		{
+			name:   "invalid generated name",
+			cfg:    exec.Config{Image: "busybox", GenerateName: "Run-"},
+			fields: []string{"GenerateName"},
+		},
+		{
+			name: "invalid secrets and config maps",
+			cfg: exec.Config{
+				Image:      "busybox",
+				Secrets:    []exec.Secret{{EnvVarName: "1TOKEN", SecretName: "token", SecretKey: "token"}},
+				ConfigMaps: []exec.ConfigMapKey{{EnvVarName: "MODE", ConfigMapName: "settings"}},
+			},
+			fields: []string{"Secrets[0].EnvVarName", "ConfigMaps[0].ConfigMapKey"},
+		},
+		{
+			name: "invalid resources",
+			cfg: exec.Config{
+				Image:    "busybox",
+				Requests: exec.Resources{CPU: "one"},
+				Limits:   exec.Resources{Memory: "1Gb"},
+			},
+			fields: []string{"Requests.CPU", "Limits.Memory"},
+		},
+		{
+			name:   "unknown main container",
+			cfg:    exec.Config{Image: "busybox", MainContainer: "main"},
+			fields: []string{"MainContainer"},
+		},
+		{
+			name:   "sidecars of a job",
+			cfg:    exec.Config{Image: "busybox", RunAsJob: true, Sidecars: []v1.Container{{Name: "proxy", Image: "proxy"}}},
+			fields: []string{"Sidecars"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if len(tt.fields) == 0 {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+
+			if !errors.Is(err, exec.ErrInvalidConfig) {
+				t.Fatalf("Validate() = %v, want an error of kind ErrInvalidConfig", err)
+			}
+			// every problem is reported at once
+			for _, f := range tt.fields {
+				if !strings.Contains(err.Error(), f+": ") {
+					t.Errorf("Validate() = %v, want a problem with %s", err, f)
+				}
+			}
+		})
+	}
+}
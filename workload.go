@@ -0,0 +1,190 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// WorkloadStrategy selects the ready pods of a workload a command is executed
+// in by RunInWorkload
+type WorkloadStrategy string
+
+const (
+	// WorkloadAny executes the command in one of the ready pods, chosen at
+	// random.
+	WorkloadAny WorkloadStrategy = "any"
+
+	// WorkloadNewest executes the command in the most recently created ready
+	// pod, such as a pod of the latest rollout of a deployment.
+	WorkloadNewest WorkloadStrategy = "newest"
+
+	// WorkloadAll executes the command in all the ready pods.
+	WorkloadAll WorkloadStrategy = "all"
+)
+
+// WorkloadOptions contains the options for running a command in the pods of a
+// workload
+type WorkloadOptions struct {
+	SelectorOptions
+
+	// Strategy selects the pods the command is executed in. If empty, it is
+	// WorkloadAny.
+	Strategy WorkloadStrategy
+}
+
+// RunInWorkload executes a command in the ready pods of a Deployment,
+// StatefulSet or DaemonSet, selected with the strategy of the options, like
+// RunOnSelector. The kind is case insensitive, and can also be the short name
+// used by kubectl, such as "deploy", "sts" or "ds".
+//
+// The pods are those matching the selector of the workload and controlled by
+// it, or for a deployment by one of its replica sets, so the pods of other
+// workloads with overlapping labels are left out.
+//
+// It returns the results of the pods, and a *SelectorError if the command
+// failed in any of them.
+func RunInWorkload(ctx context.Context, namespace, kind, name string, command []string, opts WorkloadOptions) ([]PodResult, error) {
+	strategy := opts.Strategy
+	if strategy == "" {
+		strategy = WorkloadAny
+	}
+	if strategy != WorkloadAny && strategy != WorkloadNewest && strategy != WorkloadAll {
+		return nil, fmt.Errorf("exec: unknown workload strategy %q", strategy)
+	}
+
+	client, err := getClient(opts.Client, kubeconfigKey{path: opts.Kubeconfig}, loggerOrNop(opts.Logger))
+	if err != nil {
+		return nil, err
+	}
+
+	if namespace == "" {
+		namespace = client.namespace
+	}
+
+	pods, err := client.workloadPods(namespace, kind, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(pods) == 0 {
+		return nil, fmt.Errorf("exec: %s %s/%s has no ready pods", kind, namespace, name)
+	}
+
+	switch strategy {
+	case WorkloadAny:
+		pods = []v1.Pod{pods[rand.Intn(len(pods))]}
+	case WorkloadNewest:
+		newest := pods[0]
+		for _, p := range pods[1:] {
+			if newest.CreationTimestamp.Before(&p.CreationTimestamp) {
+				newest = p
+			}
+		}
+		pods = []v1.Pod{newest}
+	}
+
+	names := make([]string, len(pods))
+	for i, p := range pods {
+		names[i] = p.Name
+	}
+	loggerOrNop(opts.Logger).Debug("workload resolved", "namespace", namespace, "kind", kind, "name", name, "strategy", strategy, "pods", names)
+
+	return runInPods(ctx, client, namespace, names, command, opts.SelectorOptions)
+}
+
+// workloadPods returns the ready pods controlled by the workload of the given
+// kind and name
+func (c *Client) workloadPods(namespace, kind, name string) ([]v1.Pod, error) {
+	var (
+		uid      types.UID
+		selector *metav1.LabelSelector
+		err      error
+	)
+
+	apps := c.clientset.AppsV1()
+	deployment := false
+	switch strings.ToLower(kind) {
+	case "deployment", "deployments", "deploy":
+		d, gerr := apps.Deployments(namespace).Get(name, metav1.GetOptions{})
+		if gerr == nil {
+			uid, selector = d.UID, d.Spec.Selector
+		}
+		err, deployment = gerr, true
+	case "statefulset", "statefulsets", "sts":
+		s, gerr := apps.StatefulSets(namespace).Get(name, metav1.GetOptions{})
+		if gerr == nil {
+			uid, selector = s.UID, s.Spec.Selector
+		}
+		err = gerr
+	case "daemonset", "daemonsets", "ds":
+		d, gerr := apps.DaemonSets(namespace).Get(name, metav1.GetOptions{})
+		if gerr == nil {
+			uid, selector = d.UID, d.Spec.Selector
+		}
+		err = gerr
+	default:
+		return nil, fmt.Errorf("exec: unsupported workload kind %q, must be a Deployment, StatefulSet or DaemonSet", kind)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot get %s %s/%s: %w", kind, namespace, name, err)
+	}
+	if selector == nil {
+		return nil, errors.New("exec: the workload has no selector")
+	}
+
+	sel, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector of %s %s/%s: %w", kind, namespace, name, err)
+	}
+	opts := metav1.ListOptions{LabelSelector: sel.String()}
+
+	// the pods of a deployment are controlled by its replica sets
+	owners := map[types.UID]bool{uid: true}
+	if deployment {
+		sets, err := apps.ReplicaSets(namespace).List(opts)
+		if err != nil {
+			return nil, fmt.Errorf("cannot list replica sets: %w", err)
+		}
+		owners = map[types.UID]bool{}
+		for _, rs := range sets.Items {
+			if ref := metav1.GetControllerOf(&rs); ref != nil && ref.UID == uid {
+				owners[rs.UID] = true
+			}
+		}
+	}
+
+	list, err := c.clientset.CoreV1().Pods(namespace).List(opts)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list pods: %w", err)
+	}
+
+	var pods []v1.Pod
+	for _, p := range list.Items {
+		ref := metav1.GetControllerOf(&p)
+		if ref == nil || !owners[ref.UID] || p.DeletionTimestamp != nil || !podReady(&p) {
+			continue
+		}
+		pods = append(pods, p)
+	}
+
+	return pods, nil
+}
+
+// podReady reports whether the pod is running and ready
+func podReady(pod *v1.Pod) bool {
+	if pod.Status.Phase != v1.PodRunning {
+		return false
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Type == v1.PodReady {
+			return c.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,205 @@
+package exec
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// defaultWorkspacePath is the path the workspace is mounted at, if not set
+	defaultWorkspacePath = "/workspace"
+
+	// defaultWorkspaceImage is the image of the containers copying files to
+	// and from the workspace, if not set
+	defaultWorkspaceImage = "busybox"
+
+	// workspaceVolume, workspaceInit and workspaceSidecar are the names of the
+	// volume of the workspace and of the containers copying files to and from it
+	workspaceVolume  = "workspace"
+	workspaceInit    = "workspace-init"
+	workspaceSidecar = "workspace"
+)
+
+// Workspace is a scratch directory of the command, backed by an emptyDir volume,
+// that can be populated with local files before the command starts, and whose
+// outputs can be retrieved after it terminates.
+type Workspace struct {
+	// MountPath is the path of the workspace in the container. If empty, the
+	// workspace is mounted at /workspace.
+	MountPath string
+
+	// SizeLimit, if not empty, is the maximum size of the workspace, as a
+	// quantity such as "1Gi". The pod is evicted if it is exceeded.
+	SizeLimit string
+
+	// Inputs are local files and directories copied into the workspace before
+	// the command starts, through an init container.
+	Inputs []WorkspaceFile
+
+	// Outputs are the paths, relative to the workspace, of the files and
+	// directories copied to OutputDir once the command terminates, whether it
+	// succeeded or not, through a sidecar container.
+	Outputs []string
+
+	// OutputDir is the local directory the outputs are copied to. If empty,
+	// the current directory is used.
+	OutputDir string
+
+	// Image is the image of the containers copying the files, which must have
+	// sh and tar. If empty, busybox is used.
+	Image string
+}
+
+// WorkspaceFile is a local file or directory copied into the workspace
+type WorkspaceFile struct {
+	LocalPath string
+
+	// Path is the path in the workspace, relative to it.
+	Path string
+}
+
+// mountPath returns the path the workspace is mounted at
+func (w *Workspace) mountPath() string {
+	if w.MountPath == "" {
+		return defaultWorkspacePath
+	}
+	return w.MountPath
+}
+
+// image returns the image of the containers copying the files
+func (w *Workspace) image() string {
+	if w.Image == "" {
+		return defaultWorkspaceImage
+	}
+	return w.Image
+}
+
+// addWorkspace adds the volume of the workspace to the spec and mounts it in c,
+// with an init container receiving the inputs and a sidecar serving the outputs
+// if needed
+func addWorkspace(spec *v1.PodSpec, c *v1.Container, w *Workspace) error {
+	source := &v1.EmptyDirVolumeSource{}
+	if w.SizeLimit != "" {
+		q, err := resource.ParseQuantity(w.SizeLimit)
+		if err != nil {
+			return fmt.Errorf("invalid workspace size limit %q: %v", w.SizeLimit, err)
+		}
+		source.SizeLimit = &q
+	}
+
+	spec.Volumes = append(spec.Volumes, v1.Volume{
+		Name:         workspaceVolume,
+		VolumeSource: v1.VolumeSource{EmptyDir: source},
+	})
+	mount := v1.VolumeMount{Name: workspaceVolume, MountPath: w.mountPath()}
+	c.VolumeMounts = append(c.VolumeMounts, mount)
+
+	if len(w.Inputs) > 0 {
+		spec.InitContainers = append(spec.InitContainers, v1.Container{
+			Name:         workspaceInit,
+			Image:        w.image(),
+			Command:      []string{"tar", "-xmf", "-", "-C", w.mountPath()},
+			Stdin:        true,
+			StdinOnce:    true,
+			VolumeMounts: []v1.VolumeMount{mount},
+		})
+	}
+
+	if len(w.Outputs) > 0 {
+		spec.Containers = append(spec.Containers, v1.Container{
+			Name:         workspaceSidecar,
+			Image:        w.image(),
+			Command:      []string{"sh", "-c", "trap 'exit 0' TERM; while true; do sleep 1; done"},
+			VolumeMounts: []v1.VolumeMount{mount},
+		})
+	}
+
+	return nil
+}
+
+// uploadWorkspace waits for the init container of the workspace to run, and
+// streams the inputs to it
+func (cmd *Cmd) uploadWorkspace() error {
+	w := cmd.Cfg.Workspace
+
+	_, err := cmd.exec.WaitReady(cmd.ctx, cmd.pod, cmd.observe(initRunning(workspaceInit)), cmd.startFailure)
+	if err != nil {
+		return &Error{Kind: ErrPodStart, Err: err}
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(pw)
+		for _, in := range w.Inputs {
+			if err := addTar(tw, in.LocalPath, path.Clean(in.Path)); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.CloseWithError(tw.Close())
+	}()
+	defer pr.Close()
+
+	// a failure of tar fails the init container, reported when waiting for
+	// the pod to start
+	err = cmd.exec.Stream(cmd.ctx, StreamRequest{
+		Pod:         cmd.pod,
+		Container:   workspaceInit,
+		Subresource: "attach",
+		Stdin:       pr,
+		Keepalive:   cmd.Keepalive,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot copy inputs to workspace: %w", err)
+	}
+
+	cmd.log.Debug("workspace inputs copied", "namespace", cmd.pod.Namespace, "pod", cmd.pod.Name, "inputs", len(w.Inputs))
+	return nil
+}
+
+// downloadWorkspace copies the outputs of the workspace to the local output
+// directory, through the sidecar of the workspace
+func (cmd *Cmd) downloadWorkspace() error {
+	w := cmd.Cfg.Workspace
+
+	for _, out := range w.Outputs {
+		remote := path.Join(w.mountPath(), out)
+		local := filepath.Join(w.OutputDir, filepath.FromSlash(path.Clean(out)))
+
+		err := CopyFrom(cmd.ctx, cmd.pod.Namespace, cmd.pod.Name, workspaceSidecar, remote, local, ExecOptions{
+			Client: cmd.client,
+			Logger: cmd.Cfg.Logger,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cmd.log.Debug("workspace outputs copied", "namespace", cmd.pod.Namespace, "pod", cmd.pod.Name, "outputs", len(w.Outputs))
+	return nil
+}
+
+// hasSidecars reports whether the pod of the command has containers running
+// next to the command, which keep it running after the command terminates
+func (cmd *Cmd) hasSidecars() bool {
+	w := cmd.Cfg.Workspace
+	return len(cmd.Cfg.Sidecars) > 0 || needsArtifactsVolume(&cmd.Cfg) || (w != nil && len(w.Outputs) > 0)
+}
+
+// initRunning returns a condition satisfied when the named init container runs
+func initRunning(name string) func(*v1.Pod) bool {
+	return func(pod *v1.Pod) bool {
+		for _, s := range pod.Status.InitContainerStatuses {
+			if s.Name == name {
+				return s.State.Running != nil || s.State.Terminated != nil
+			}
+		}
+		return false
+	}
+}